@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/metrics"
+)
+
+// Built-in update action types; see config.UpdateAction.
+const (
+	actionDockerSignal  = "docker-signal"
+	actionDockerRestart = "docker-restart"
+	actionDockerExec    = "docker-exec"
+	actionSignal        = "signal"
+	actionSystemd       = "systemd"
+	actionHAProxy       = "haproxy-runtime"
+)
+
+// hasUpdate reports whether cfg has an update action or update command.
+func hasUpdate(cfg config.CertificateConfiguration) bool {
+	return cfg.UpdateAction != nil || strings.TrimSpace(cfg.UpdateCmd) != ""
+}
+
+// runUpdate runs cfg's update action when one is configured, and its update
+// command otherwise.
+func runUpdate(ctx context.Context, cfg config.CertificateConfiguration) (string, error) {
+	if cfg.UpdateAction == nil {
+		return runUpdateCommand(ctx, cfg)
+	}
+
+	started := time.Now()
+	output, err := runUpdateAction(ctx, cfg, *cfg.UpdateAction)
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+	}
+	metrics.ObserveUpdateCommand(cfg.Id, time.Since(started), exitCode)
+	if err != nil && strings.EqualFold(cfg.UpdateAction.Type, actionHAProxy) && strings.TrimSpace(cfg.UpdateCmd) != "" {
+		// A runtime update that did not take falls back to a full reload.
+		log.Printf("%s update action failed for config %s, running update command: %v", cfg.UpdateAction.Type, cfg.Id, err)
+		commandOutput, commandErr := runUpdateCommand(ctx, cfg)
+		return output + commandOutput, commandErr
+	}
+	if err != nil {
+		return output, fmt.Errorf("%s update action failed: %w", cfg.UpdateAction.Type, err)
+	}
+	return output, nil
+}
+
+func runUpdateAction(ctx context.Context, cfg config.CertificateConfiguration, action config.UpdateAction) (string, error) {
+	log.Printf("Running %s update action for config %s", action.Type, cfg.Id)
+	switch strings.ToLower(action.Type) {
+	case actionDockerSignal, actionDockerRestart, actionDockerExec:
+		return runDockerAction(ctx, cfg.Id, action)
+	case actionSignal:
+		return runSignalAction(ctx, action)
+	case actionSystemd:
+		return runSystemdAction(ctx, action)
+	case actionHAProxy:
+		return runHAProxyAction(ctx, cfg, action)
+	}
+	return "", fmt.Errorf("unknown update action type %q", action.Type)
+}
+
+// actionTimeout returns the action's configured timeout, or fallback.
+func actionTimeout(action config.UpdateAction, fallback time.Duration) time.Duration {
+	if action.TimeoutSeconds > 0 {
+		return time.Duration(action.TimeoutSeconds) * time.Second
+	}
+	return fallback
+}
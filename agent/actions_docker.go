@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/dockerapi"
+)
+
+// dockerReloadLabel marks containers to reload for a configuration when the
+// action names no container: io.certkit.reload=<config_id>.
+const dockerReloadLabel = "io.certkit.reload"
+
+const dockerTimeout = 60 * time.Second
+
+type dockerTarget struct {
+	id   string
+	name string
+}
+
+// runDockerAction signals, restarts, or runs a command in the action's
+// containers through the Docker or Podman Engine API.
+func runDockerAction(ctx context.Context, configId string, action config.UpdateAction) (string, error) {
+	socket := dockerapi.FindSocket(action.DockerSocket)
+	if socket == "" {
+		return "", errors.New("no Docker or Podman socket found")
+	}
+	client := dockerapi.NewClient(socket)
+
+	timeout := actionTimeout(action, dockerTimeout)
+	if strings.EqualFold(action.Type, actionDockerRestart) {
+		// timeout_seconds is also the stop timeout, after which the engine
+		// still has to start the container again.
+		timeout += dockerTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	targets, err := dockerActionTargets(ctx, client, configId, action)
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	for _, target := range targets {
+		switch strings.ToLower(action.Type) {
+		case actionDockerSignal:
+			signal := action.Signal
+			if signal == "" {
+				signal = "SIGHUP"
+			}
+			if err := client.KillContainer(ctx, target.id, signal); err != nil {
+				return output.String(), fmt.Errorf("signal container %s: %w", target.name, err)
+			}
+			fmt.Fprintf(&output, "Sent %s to container %s\n", signal, target.name)
+		case actionDockerRestart:
+			if err := client.RestartContainer(ctx, target.id, actionTimeout(action, 10*time.Second)); err != nil {
+				return output.String(), fmt.Errorf("restart container %s: %w", target.name, err)
+			}
+			fmt.Fprintf(&output, "Restarted container %s\n", target.name)
+		case actionDockerExec:
+			if len(action.Command) == 0 {
+				return "", errors.New("no command configured")
+			}
+			result, err := client.Exec(ctx, target.id, action.Command)
+			if err != nil {
+				return output.String(), fmt.Errorf("exec in container %s: %w", target.name, err)
+			}
+			fmt.Fprintf(&output, "%s: %s\n", target.name, strings.Join(action.Command, " "))
+			output.WriteString(result.Output)
+			if result.ExitCode != 0 {
+				return output.String(), fmt.Errorf("command in container %s exited with code %d", target.name, result.ExitCode)
+			}
+		}
+	}
+	log.Printf("Update action output for config %s:\n%s", configId, output.String())
+	return output.String(), nil
+}
+
+// dockerActionTargets resolves the container named by the action, or every
+// running container carrying its label.
+func dockerActionTargets(ctx context.Context, client *dockerapi.Client, configId string, action config.UpdateAction) ([]dockerTarget, error) {
+	if action.Container != "" {
+		details, err := client.InspectContainer(ctx, action.Container)
+		if err != nil {
+			if dockerapi.IsNotFound(err) {
+				return nil, fmt.Errorf("container %s not found", action.Container)
+			}
+			return nil, err
+		}
+		if !details.State.Running {
+			return nil, fmt.Errorf("container %s is not running", details.Name)
+		}
+		return []dockerTarget{{id: details.Id, name: details.Name}}, nil
+	}
+
+	label := action.Label
+	if label == "" {
+		label = dockerReloadLabel + "=" + configId
+	}
+	containers, err := client.ListContainers(ctx, map[string][]string{"label": {label}})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no running container has label %s", label)
+	}
+	targets := make([]dockerTarget, 0, len(containers))
+	for _, container := range containers {
+		targets = append(targets, dockerTarget{id: container.Id, name: container.Name()})
+	}
+	return targets, nil
+}
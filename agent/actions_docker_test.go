@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+func fakeDockerEngine(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return socket
+}
+
+func TestDockerSignalActionUsesConfigLabel(t *testing.T) {
+	var requests []string
+	socket := fakeDockerEngine(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.URL.RawQuery)
+		switch r.URL.Path {
+		case "/containers/json":
+			_, _ = w.Write([]byte(`[{"Id":"c1","Names":["/web"]}]`))
+		case "/containers/c1/kill":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	action := config.UpdateAction{Type: actionDockerSignal, DockerSocket: socket}
+	output, err := runUpdateAction(context.Background(), config.CertificateConfiguration{Id: "cfg1"}, action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "Sent SIGHUP to container web\n" {
+		t.Errorf("output = %q", output)
+	}
+	if len(requests) != 2 || !strings.Contains(requests[0], "io.certkit.reload%3Dcfg1") || requests[1] != "POST /containers/c1/kill signal=SIGHUP" {
+		t.Errorf("requests = %q", requests)
+	}
+}
+
+func TestDockerExecActionReportsExitCode(t *testing.T) {
+	socket := fakeDockerEngine(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/nginx/json":
+			_, _ = w.Write([]byte(`{"Id":"c2","Name":"/nginx","State":{"Running":true}}`))
+		case "/containers/c2/exec":
+			_, _ = w.Write([]byte(`{"Id":"e1"}`))
+		case "/exec/e1/start":
+			message := "nginx: configuration file test failed\n"
+			frame := make([]byte, 8, 8+len(message))
+			frame[0] = 2
+			binary.BigEndian.PutUint32(frame[4:], uint32(len(message)))
+			_, _ = w.Write(append(frame, message...))
+		case "/exec/e1/json":
+			_, _ = w.Write([]byte(`{"ExitCode":1}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	action := config.UpdateAction{Type: actionDockerExec, Container: "nginx", Command: []string{"nginx", "-s", "reload"}, DockerSocket: socket}
+	output, err := runUpdateAction(context.Background(), config.CertificateConfiguration{Id: "cfg1"}, action)
+	if err == nil || !strings.Contains(err.Error(), "exited with code 1") {
+		t.Fatalf("err = %v", err)
+	}
+	if !strings.Contains(output, "configuration file test failed") {
+		t.Errorf("output = %q", output)
+	}
+}
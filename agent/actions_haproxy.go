@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+const (
+	haproxyDefaultSocket = "/run/haproxy/admin.sock"
+	haproxyTimeout       = 30 * time.Second
+	haproxyMaxResponse   = 1 << 20
+
+	// haproxyNotLoaded is how "set ssl cert" answers for a certificate that
+	// no bind line or crt-list loaded: "Can't replace a certificate which is
+	// not referenced by the configuration!"
+	haproxyNotLoaded = "not referenced by the configuration"
+)
+
+// runHAProxyAction replaces the certificate in a running HAProxy through its
+// Runtime API: set ssl cert, then commit ssl cert. A certificate HAProxy has
+// not loaded yet is created and added to the action's crt-list.
+func runHAProxyAction(ctx context.Context, cfg config.CertificateConfiguration, action config.UpdateAction) (string, error) {
+	payload, err := haproxyPayload(cfg)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout(action, haproxyTimeout))
+	defer cancel()
+
+	cli := haproxyCLI{socket: action.Socket, master: action.Master}
+	if cli.socket == "" {
+		cli.socket = haproxyDefaultSocket
+	}
+	name := cfg.PemDestination
+
+	var output strings.Builder
+	response, err := cli.run(ctx, "set ssl cert "+name+" <<\n"+payload+"\n")
+	if err != nil {
+		return "", err
+	}
+	output.WriteString(response)
+
+	isNew := false
+	if strings.Contains(response, haproxyNotLoaded) {
+		if action.CrtList == "" {
+			return output.String(), fmt.Errorf("haproxy has no certificate %s loaded and no crt_list is configured", name)
+		}
+		isNew = true
+		if response, err = cli.expect(ctx, "new ssl cert "+name, "New empty certificate store"); err != nil {
+			return output.String() + response, err
+		}
+		output.WriteString(response)
+		if response, err = cli.run(ctx, "set ssl cert "+name+" <<\n"+payload+"\n"); err != nil {
+			return output.String(), err
+		}
+		output.WriteString(response)
+	}
+	if !strings.Contains(response, "Transaction created") && !strings.Contains(response, "Transaction updated") {
+		// Leave no half-finished transaction behind for the next attempt.
+		_, _ = cli.run(ctx, "abort ssl cert "+name)
+		return output.String(), fmt.Errorf("set ssl cert %s: %s", name, strings.TrimSpace(response))
+	}
+
+	response, err = cli.expect(ctx, "commit ssl cert "+name, "Success!")
+	output.WriteString(response)
+	if err != nil {
+		return output.String(), err
+	}
+
+	if isNew {
+		response, err = cli.expect(ctx, "add ssl crt-list "+action.CrtList+" "+name, "Success!")
+		output.WriteString(response)
+		if err != nil {
+			return output.String(), err
+		}
+	}
+	log.Printf("Update action output:\n%s", output.String())
+	return output.String(), nil
+}
+
+// haproxyPayload assembles the certificate, chain and key HAProxy expects in
+// a single PEM.
+func haproxyPayload(cfg config.CertificateConfiguration) (string, error) {
+	paths := []string{cfg.PemDestination}
+	if chain := strings.TrimSpace(cfg.ChainDestination); chain != "" {
+		paths = append(paths, chain)
+	}
+	if !cfg.AllInOne && cfg.KeyDestination != "" {
+		paths = append(paths, cfg.KeyDestination)
+	}
+
+	var payload strings.Builder
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", path, err)
+		}
+		text := strings.TrimSpace(string(data))
+		if text == "" {
+			continue
+		}
+		payload.WriteString(text)
+		payload.WriteString("\n")
+	}
+	if payload.Len() == 0 {
+		return "", errors.New("no certificate data to send to haproxy")
+	}
+	return payload.String(), nil
+}
+
+// haproxyCLI sends one command per connection to the stats socket, or to
+// the first worker through the master socket.
+type haproxyCLI struct {
+	socket string
+	master bool
+}
+
+func (c haproxyCLI) run(ctx context.Context, command string) (string, error) {
+	network, address := "unix", c.socket
+	if rest, ok := strings.CutPrefix(address, "unix@"); ok {
+		address = rest
+	} else if rest, ok := strings.CutPrefix(address, "ipv4@"); ok {
+		network, address = "tcp", rest
+	} else if !strings.Contains(address, "/") && strings.Contains(address, ":") {
+		network = "tcp"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return "", fmt.Errorf("connect to haproxy socket %s: %w", c.socket, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if c.master {
+		command = "@1 " + command
+	}
+	if !strings.HasSuffix(command, "\n") {
+		command += "\n"
+	}
+	if _, err := io.WriteString(conn, command); err != nil {
+		return "", fmt.Errorf("write to haproxy socket: %w", err)
+	}
+	// HAProxy closes the connection once it has answered a non-interactive
+	// command.
+	response, err := io.ReadAll(io.LimitReader(conn, haproxyMaxResponse))
+	if err != nil {
+		return string(response), fmt.Errorf("read from haproxy socket: %w", err)
+	}
+	return string(response), nil
+}
+
+// expect runs command and fails unless the response contains want.
+func (c haproxyCLI) expect(ctx context.Context, command string, want string) (string, error) {
+	response, err := c.run(ctx, command)
+	if err != nil {
+		return response, err
+	}
+	if !strings.Contains(response, want) {
+		return response, fmt.Errorf("%s: %s", command, strings.TrimSpace(response))
+	}
+	return response, nil
+}
@@ -0,0 +1,207 @@
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"golang.org/x/sys/unix"
+)
+
+// signalVerifyDelay is how long after signalling a process the agent checks
+// that it is still running.
+const signalVerifyDelay = time.Second
+
+// runSignalAction sends the action's signal (default SIGHUP) to the process
+// located by pidfile, name or executable, then verifies it survived.
+func runSignalAction(ctx context.Context, action config.UpdateAction) (string, error) {
+	signal, err := parseSignal(action.Signal)
+	if err != nil {
+		return "", err
+	}
+	pids, err := signalTargets(action)
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	for _, pid := range pids {
+		if err := unix.Kill(pid, signal); err != nil {
+			return output.String(), fmt.Errorf("signal process %d: %w", pid, err)
+		}
+		fmt.Fprintf(&output, "Sent %s to process %d\n", unix.SignalName(signal), pid)
+	}
+
+	select {
+	case <-ctx.Done():
+		return output.String(), ctx.Err()
+	case <-time.After(signalVerifyDelay):
+	}
+	for _, pid := range pids {
+		if !processAlive(pid) {
+			return output.String(), fmt.Errorf("process %d exited after %s", pid, unix.SignalName(signal))
+		}
+	}
+	log.Printf("Update action output:\n%s", output.String())
+	return output.String(), nil
+}
+
+// parseSignal accepts "HUP", "SIGHUP" or a signal number.
+func parseSignal(value string) (syscall.Signal, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return unix.SIGHUP, nil
+	}
+	if number, err := strconv.Atoi(value); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	if !strings.HasPrefix(value, "SIG") {
+		value = "SIG" + value
+	}
+	if signal := unix.SignalNum(value); signal != 0 {
+		return signal, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", value)
+}
+
+func signalTargets(action config.UpdateAction) ([]int, error) {
+	switch {
+	case action.Pidfile != "":
+		data, err := os.ReadFile(action.Pidfile)
+		if err != nil {
+			return nil, fmt.Errorf("read pidfile: %w", err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("pidfile %s does not contain a pid", action.Pidfile)
+		}
+		if !processAlive(pid) {
+			return nil, fmt.Errorf("process %d from %s is not running", pid, action.Pidfile)
+		}
+		return []int{pid}, nil
+	case action.ProcessName != "" || action.Executable != "":
+		pids, err := findProcesses(action.ProcessName, action.Executable)
+		if err != nil {
+			return nil, err
+		}
+		if len(pids) == 0 {
+			target := action.ProcessName
+			if target == "" {
+				target = action.Executable
+			}
+			return nil, fmt.Errorf("no running process matches %s", target)
+		}
+		return pids, nil
+	}
+	return nil, errors.New("signal action needs a pidfile, process_name or executable")
+}
+
+// findProcesses scans /proc for processes with the given name or
+// executable. Only the top of each matching process tree is returned, so a
+// master process is signalled and its workers are not.
+func findProcesses(name string, executable string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[int]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if !processMatches(pid, name, executable) {
+			continue
+		}
+		ppid, _, ok := processStat(pid)
+		if !ok {
+			continue
+		}
+		parents[pid] = ppid
+	}
+	return topProcesses(parents), nil
+}
+
+// topProcesses returns the pids, in ascending order, whose parent is not
+// itself in the set.
+func topProcesses(parents map[int]int) []int {
+	pids := make([]int, 0)
+	for pid, ppid := range parents {
+		if _, ok := parents[ppid]; ok {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func processMatches(pid int, name string, executable string) bool {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	if name != "" {
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			return false
+		}
+		// comm is truncated to 15 bytes by the kernel.
+		want := name
+		if len(want) > 15 {
+			want = want[:15]
+		}
+		if strings.TrimSpace(string(comm)) != want {
+			return false
+		}
+	}
+	if executable != "" {
+		exe, err := os.Readlink(filepath.Join(dir, "exe"))
+		if err != nil {
+			return false
+		}
+		// The binary may have been replaced by a package upgrade since the
+		// process started.
+		exe = strings.TrimSuffix(exe, " (deleted)")
+		if exe != filepath.Clean(executable) {
+			return false
+		}
+	}
+	return true
+}
+
+// processStat returns the parent pid and state letter from /proc/<pid>/stat.
+func processStat(pid int) (int, string, bool) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, "", false
+	}
+	// The command name is parenthesised and may contain spaces.
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, "", false
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 2 {
+		return 0, "", false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return ppid, fields[0], true
+}
+
+// processAlive reports whether pid exists and is not a zombie.
+func processAlive(pid int) bool {
+	_, state, ok := processStat(pid)
+	return ok && state != "Z" && state != "X"
+}
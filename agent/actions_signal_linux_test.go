@@ -0,0 +1,59 @@
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := map[string]unix.Signal{"": unix.SIGHUP, "hup": unix.SIGHUP, "SIGUSR1": unix.SIGUSR1, "10": unix.Signal(10)}
+	for value, want := range tests {
+		got, err := parseSignal(value)
+		if err != nil || got != want {
+			t.Errorf("parseSignal(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseSignal("SIGBOGUS"); err == nil {
+		t.Error("expected error for unknown signal")
+	}
+}
+
+func TestTopProcessesSkipsWorkers(t *testing.T) {
+	got := topProcesses(map[int]int{10: 1, 11: 10, 12: 10, 20: 1})
+	if len(got) != 2 || got[0] != 10 || got[1] != 20 {
+		t.Errorf("topProcesses = %v", got)
+	}
+}
+
+func TestSignalActionVerifiesProcessSurvives(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start sleep: %v", err)
+	}
+	defer cmd.Process.Kill()
+	pidfile := filepath.Join(t.TempDir(), "sleep.pid")
+	if err := os.WriteFile(pidfile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// sleep ignores SIGWINCH, so it is still running afterwards.
+	output, err := runSignalAction(context.Background(), config.UpdateAction{Type: actionSignal, Pidfile: pidfile, Signal: "WINCH"})
+	if err != nil || !strings.Contains(output, "Sent SIGWINCH") {
+		t.Fatalf("output = %q, err = %v", output, err)
+	}
+
+	_, err = runSignalAction(context.Background(), config.UpdateAction{Type: actionSignal, Pidfile: pidfile, Signal: "TERM"})
+	if err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Fatalf("err = %v, want process exited", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"errors"
+
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+func runSignalAction(_ context.Context, _ config.UpdateAction) (string, error) {
+	return "", errors.New("signal update actions are only supported on Linux")
+}
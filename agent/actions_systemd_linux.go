@@ -0,0 +1,146 @@
+//go:build linux
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/dbus"
+)
+
+const (
+	systemdBusName   = "org.freedesktop.systemd1"
+	systemdPath      = dbus.ObjectPath("/org/freedesktop/systemd1")
+	systemdManager   = "org.freedesktop.systemd1.Manager"
+	systemdUnit      = "org.freedesktop.systemd1.Unit"
+	systemdJobMatch  = "type='signal',interface='org.freedesktop.systemd1.Manager',member='JobRemoved'"
+	systemdJobMode   = "replace"
+	systemdTimeout   = 90 * time.Second
+	systemdOperation = "reload"
+)
+
+// systemdMethods maps an action's operation to the Manager method that
+// performs it, as systemctl does.
+var systemdMethods = map[string]string{
+	"reload":                "ReloadUnit",
+	"restart":               "RestartUnit",
+	"try-reload-or-restart": "ReloadOrTryRestartUnit",
+}
+
+// runSystemdAction queues a reload or restart job for the action's unit,
+// waits for the job to finish, and checks the unit did not end up failed.
+func runSystemdAction(ctx context.Context, action config.UpdateAction) (string, error) {
+	unit := strings.TrimSpace(action.Unit)
+	if unit == "" {
+		return "", errors.New("systemd action needs a unit")
+	}
+	operation := strings.ToLower(strings.TrimSpace(action.Operation))
+	if operation == "" {
+		operation = systemdOperation
+	}
+	method, ok := systemdMethods[operation]
+	if !ok {
+		return "", fmt.Errorf("unknown systemd operation %q", action.Operation)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout(action, systemdTimeout))
+	defer cancel()
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return "", fmt.Errorf("connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	// Subscribe before queueing the job so its JobRemoved signal cannot be
+	// missed.
+	if err := conn.AddMatch(ctx, systemdJobMatch); err != nil {
+		return "", fmt.Errorf("add match: %w", err)
+	}
+	if _, err := conn.Call(ctx, systemdBusName, systemdPath, systemdManager, "Subscribe"); err != nil {
+		return "", fmt.Errorf("subscribe: %w", err)
+	}
+
+	reply, err := conn.Call(ctx, systemdBusName, systemdPath, systemdManager, method, unit, systemdJobMode)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", method, unit, err)
+	}
+	if len(reply) == 0 {
+		return "", fmt.Errorf("%s %s: empty reply", method, unit)
+	}
+	job, ok := reply[0].(dbus.ObjectPath)
+	if !ok {
+		return "", fmt.Errorf("%s %s: unexpected reply %v", method, unit, reply[0])
+	}
+
+	result, err := waitForJob(ctx, conn, job)
+	if err != nil {
+		return "", fmt.Errorf("wait for %s job: %w", unit, err)
+	}
+
+	activeState, subState, err := unitState(ctx, conn, unit)
+	if err != nil {
+		return "", err
+	}
+	output := fmt.Sprintf("%s %s: %s (%s)\n", unit, operation, activeState, subState)
+	if activeState == "failed" {
+		return output, fmt.Errorf("unit %s failed after %s", unit, operation)
+	}
+	if result != "done" {
+		return output, fmt.Errorf("%s job for %s finished with result %q", operation, unit, result)
+	}
+	log.Printf("Update action output:\n%s", output)
+	return output, nil
+}
+
+// waitForJob waits for the JobRemoved signal of job and returns its result
+// ("done", "failed", "canceled", ...).
+func waitForJob(ctx context.Context, conn *dbus.Conn, job dbus.ObjectPath) (string, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case signal := <-conn.Signals():
+			if signal.Member != "JobRemoved" || len(signal.Body) < 4 {
+				continue
+			}
+			if path, _ := signal.Body[1].(dbus.ObjectPath); path != job {
+				continue
+			}
+			result, _ := signal.Body[3].(string)
+			return result, nil
+		}
+	}
+}
+
+// unitState returns the unit's ActiveState and SubState.
+func unitState(ctx context.Context, conn *dbus.Conn, unit string) (string, string, error) {
+	reply, err := conn.Call(ctx, systemdBusName, systemdPath, systemdManager, "LoadUnit", unit)
+	if err != nil {
+		return "", "", fmt.Errorf("load unit %s: %w", unit, err)
+	}
+	if len(reply) == 0 {
+		return "", "", fmt.Errorf("load unit %s: empty reply", unit)
+	}
+	path, ok := reply[0].(dbus.ObjectPath)
+	if !ok {
+		return "", "", fmt.Errorf("load unit %s: unexpected reply %v", unit, reply[0])
+	}
+
+	states := make([]string, 0, 2)
+	for _, property := range []string{"ActiveState", "SubState"} {
+		value, err := conn.GetProperty(ctx, systemdBusName, path, systemdUnit, property)
+		if err != nil {
+			return "", "", fmt.Errorf("read %s of %s: %w", property, unit, err)
+		}
+		state, _ := value.(string)
+		states = append(states, state)
+	}
+	return states[0], states[1], nil
+}
@@ -0,0 +1,168 @@
+//go:build linux
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/dbus"
+)
+
+// startTestBus runs a private dbus-daemon and points the system bus address
+// at it.
+func startTestBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not available")
+	}
+	dir := t.TempDir()
+	busConfig := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(busConfig, []byte(fmt.Sprintf(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>system</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`, filepath.Join(dir, "bus.sock"))), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+busConfig, "--nofork", "--nopidfile", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	address = strings.TrimSpace(address)
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", address)
+	return address
+}
+
+// fakeSystemd serves the parts of the systemd1 Manager and Unit interfaces
+// the systemd action uses. Every job completes with jobResult and leaves the
+// unit in activeState.
+type fakeSystemd struct {
+	conn        *dbus.Conn
+	jobResult   string
+	activeState string
+	subState    string
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func startFakeSystemd(t *testing.T, address string, jobResult string, activeState string, subState string) *fakeSystemd {
+	t.Helper()
+	conn, err := dbus.Dial(address)
+	if err != nil {
+		t.Fatalf("dial bus: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fake := &fakeSystemd{conn: conn, jobResult: jobResult, activeState: activeState, subState: subState}
+	conn.Handle(fake.handle)
+	if err := conn.RequestName(context.Background(), systemdBusName); err != nil {
+		t.Fatalf("request name: %v", err)
+	}
+	return fake
+}
+
+func (f *fakeSystemd) handle(call *dbus.Message) ([]any, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, call.Member)
+	f.mu.Unlock()
+	switch call.Member {
+	case "Subscribe":
+		return nil, nil
+	// Only methods the real Manager has; anything else is UnknownMethod.
+	case "ReloadUnit", "RestartUnit", "ReloadOrTryRestartUnit":
+		unit, _ := call.Body[0].(string)
+		job := dbus.ObjectPath("/org/freedesktop/systemd1/job/7")
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			_ = f.conn.Emit(systemdPath, systemdManager, "JobRemoved", uint32(7), job, unit, f.jobResult)
+		}()
+		return []any{job}, nil
+	case "LoadUnit":
+		return []any{dbus.ObjectPath("/org/freedesktop/systemd1/unit/nginx_2eservice")}, nil
+	case "Get":
+		switch call.Body[1] {
+		case "ActiveState":
+			return []any{dbus.MakeVariant(f.activeState)}, nil
+		case "SubState":
+			return []any{dbus.MakeVariant(f.subState)}, nil
+		}
+	}
+	return nil, &dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownMethod", Message: call.Member}
+}
+
+func TestSystemdActionReloadsUnit(t *testing.T) {
+	address := startTestBus(t)
+	fake := startFakeSystemd(t, address, "done", "active", "running")
+
+	output, err := runSystemdAction(context.Background(), config.UpdateAction{Type: actionSystemd, Unit: "nginx.service"})
+	if err != nil {
+		t.Fatalf("runSystemdAction: %v", err)
+	}
+	if output != "nginx.service reload: active (running)\n" {
+		t.Errorf("output = %q", output)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if strings.Join(fake.calls, ",") != "Subscribe,ReloadUnit,LoadUnit,Get,Get" {
+		t.Errorf("calls = %v", fake.calls)
+	}
+}
+
+func TestSystemdActionReportsFailedUnit(t *testing.T) {
+	address := startTestBus(t)
+	fake := startFakeSystemd(t, address, "failed", "failed", "failed")
+
+	output, err := runSystemdAction(context.Background(), config.UpdateAction{Type: actionSystemd, Unit: "nginx.service", Operation: "try-reload-or-restart"})
+	if err == nil || !strings.Contains(err.Error(), "failed after try-reload-or-restart") {
+		t.Fatalf("err = %v", err)
+	}
+	if !strings.Contains(output, "failed (failed)") {
+		t.Errorf("output = %q", output)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.calls) < 2 || fake.calls[1] != "ReloadOrTryRestartUnit" {
+		t.Errorf("calls = %v", fake.calls)
+	}
+}
+
+func TestSystemdActionRejectsUnknownOperation(t *testing.T) {
+	_, err := runSystemdAction(context.Background(), config.UpdateAction{Type: actionSystemd, Unit: "nginx.service", Operation: "stop"})
+	if err == nil {
+		t.Fatal("expected error for unknown operation")
+	}
+}
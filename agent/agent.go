@@ -1,47 +1,89 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/certkit-io/certkit-agent/api"
 	"github.com/certkit-io/certkit-agent/config"
-	"github.com/certkit-io/certkit-agent/inventory"
+	"github.com/certkit-io/certkit-agent/metrics"
 	"github.com/certkit-io/certkit-agent/utils"
 )
 
-func PollAndSync(forceSync bool) {
-	configChanged, err := PollForConfiguration()
+var errAgentUnauthorized = errors.New("agent is not currently authorized by the CertKit server")
+
+func PollAndSync(ctx context.Context, forceSync bool) {
+	_, _ = pollAndSync(ctx, forceSync, "")
+}
+
+// ForceSync polls and synchronizes immediately, limited to configId when it is
+// non-empty and the poll brought no configuration change, and returns the
+// resulting statuses.
+func ForceSync(ctx context.Context, configId string) ([]api.AgentConfigStatusUpdate, error) {
+	return pollAndSync(ctx, true, configId)
+}
+
+func pollAndSync(ctx context.Context, forceSync bool, configId string) ([]api.AgentConfigStatusUpdate, error) {
+	pollStart := time.Now()
+	configChanged, err := PollForConfiguration(ctx)
 	if err != nil {
+		recordPoll(metrics.PollFailure, time.Since(pollStart), err)
 		reportAgentError(err, "", "")
-		return
+		return nil, err
 	}
 	if utils.IsAgentUnauthorized() {
-		return
+		recordPoll(metrics.PollUnauthorized, time.Since(pollStart), errAgentUnauthorized)
+		return nil, errAgentUnauthorized
 	}
+	recordPoll(metrics.PollSuccess, time.Since(pollStart), nil)
 	if !configChanged && !forceSync {
-		return
+		return nil, nil
 	}
 
-	statuses := SynchronizeCertificates(configChanged)
+	// The poll has consumed the change for every configuration, so a sync
+	// limited to one would lose it for the others.
+	if configChanged && configId != "" {
+		if !hasConfiguration(configId) {
+			return nil, fmt.Errorf("unknown certificate configuration %q", configId)
+		}
+		log.Printf("Configuration changed; synchronizing all configurations, not only %s", configId)
+		configId = ""
+	}
+	statuses, err := synchronizeConfigurations(ctx, configChanged, configId)
+	if err != nil {
+		return nil, err
+	}
 	if len(statuses) > 0 {
-		if err := api.UpdateConfigStatus(statuses); err != nil {
+		if err := api.UpdateConfigStatus(ctx, statuses); err != nil {
 			reportAgentError(err, "", "")
 		}
 	}
+	return statuses, nil
+}
+
+func hasConfiguration(configId string) bool {
+	for _, cfg := range config.CurrentConfig.CertificateConfigurations {
+		if cfg.Id == configId {
+			return true
+		}
+	}
+	return false
 }
 
 func NeedsRegistration() bool {
 	return config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == ""
 }
 
-func DoRegistration() {
+func DoRegistration(ctx context.Context) {
 	if config.CurrentConfig.Bootstrap == nil || config.CurrentConfig.Bootstrap.RegistrationKey == "" {
 		log.Printf("Error: missing registration key for agent bootstrap")
 		return
 	}
 
-	response, err := api.RegisterAgent()
+	response, err := api.RegisterAgent(ctx)
 	if err != nil {
 		log.Printf("Error: %v", err)
 		return
@@ -56,11 +98,11 @@ func DoRegistration() {
 
 	log.Printf("Registered agent: %s", response.AgentId)
 
-	SendInventory()
+	SendInventory(ctx)
 }
 
-func PollForConfiguration() (configChanged bool, err error) {
-	response, err := api.PollForConfiguration()
+func PollForConfiguration(ctx context.Context) (configChanged bool, err error) {
+	response, err := api.PollForConfiguration(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -77,25 +119,21 @@ func PollForConfiguration() (configChanged bool, err error) {
 	return true, nil
 }
 
-func SendInventory() {
-	items, err := inventory.Collect()
-	if err != nil {
-		reportAgentError(fmt.Errorf("collect inventory: %w", err), "", "")
+func reportAgentError(err error, configId string, certificateId string) {
+	if err == nil {
 		return
 	}
-
-	if err := api.UpdateInventory(items); err != nil {
-		reportAgentError(fmt.Errorf("update inventory: %w", err), "", "")
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Interrupted: %v", err)
 		return
 	}
-}
 
-func reportAgentError(err error, configId string, certificateId string) {
-	if err == nil {
-		return
-	}
+	// Errors are reported even while shutting down, so they must not inherit
+	// the caller's cancellation.
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-	if reportErr := api.ReportAgentError(err.Error(), configId, certificateId); reportErr != nil {
+	if reportErr := api.ReportAgentError(ctx, err.Error(), configId, certificateId); reportErr != nil {
 		log.Printf("Error reporting agent error: %v", reportErr)
 	}
 	log.Printf("Error: %v", err)
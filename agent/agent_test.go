@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	agentCrypto "github.com/certkit-io/certkit-agent/crypto"
+)
+
+// pollingCertkitAPI points the agent at a stand-in CertKit API whose poll
+// returns configs as changed configurations, or no change when configs is nil.
+// Configurations without destinations are used so a sync only reports status.
+func pollingCertkitAPI(t *testing.T, configs []config.CertificateConfiguration) {
+	t.Helper()
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/poll-config") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if configs == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(api.ConfigurationPollResponse{UpdatedCertificateConfigurations: configs})
+	}))
+	t.Cleanup(server.Close)
+
+	saved, savedPath := config.CurrentConfig, config.CurrentPath
+	t.Cleanup(func() { config.CurrentConfig, config.CurrentPath = saved, savedPath })
+	config.CurrentPath = filepath.Join(t.TempDir(), "config.json")
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+	config.CurrentConfig.CertificateConfigurations = []config.CertificateConfiguration{
+		{Id: "cfg1", CertificateId: "cert1"},
+		{Id: "cfg2", CertificateId: "cert2"},
+	}
+}
+
+func statusConfigIds(statuses []api.AgentConfigStatusUpdate) []string {
+	ids := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		ids = append(ids, status.ConfigId)
+	}
+	return ids
+}
+
+func TestForceSyncLimitedToConfig(t *testing.T) {
+	pollingCertkitAPI(t, nil)
+
+	statuses, err := ForceSync(t.Context(), "cfg2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := statusConfigIds(statuses); len(ids) != 1 || ids[0] != "cfg2" {
+		t.Errorf("synchronized %v, want [cfg2]", ids)
+	}
+
+	if _, err := ForceSync(t.Context(), "missing"); err == nil {
+		t.Error("ForceSync() of an unknown configuration expected error")
+	}
+}
+
+func TestForceSyncWithConfigChangeSyncsAll(t *testing.T) {
+	pollingCertkitAPI(t, []config.CertificateConfiguration{
+		{Id: "cfg1", CertificateId: "cert1"},
+		{Id: "cfg2", CertificateId: "cert2"},
+	})
+
+	statuses, err := ForceSync(t.Context(), "cfg2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := statusConfigIds(statuses); len(ids) != 2 || ids[0] != "cfg1" || ids[1] != "cfg2" {
+		t.Errorf("synchronized %v, want [cfg1 cfg2]", ids)
+	}
+}
@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/inventory"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const configTestTimeout = 60 * time.Second
+
+type configTestCommand struct {
+	binary string
+	args   []string
+}
+
+// configTestCommands are the built-in config checks per server. The first
+// binary that can be found is used.
+var configTestCommands = map[string][]configTestCommand{
+	"nginx": {
+		{binary: "nginx", args: []string{"-t"}},
+	},
+	"apache": {
+		{binary: "apachectl", args: []string{"configtest"}},
+		{binary: "apache2ctl", args: []string{"configtest"}},
+		{binary: "httpd", args: []string{"-t"}},
+	},
+	// The files to check are added by haproxyConfigTestArgs.
+	"haproxy": {
+		{binary: "haproxy", args: []string{"-c"}},
+	},
+	"litespeed": {
+		{binary: "/usr/local/lsws/bin/openlitespeed", args: []string{"-t"}},
+		{binary: "/usr/local/lsws/bin/lshttpd", args: []string{"-t"}},
+	},
+}
+
+// configTestCommandFor resolves the command for cfg's config test, applying
+// the binary and argument overrides to the server's built-in command.
+func configTestCommandFor(cfg config.CertificateConfiguration) (configTestCommand, error) {
+	test := cfg.ConfigTest
+	server := strings.ToLower(strings.TrimSpace(test.Server))
+	if server == "" {
+		server = strings.ToLower(strings.TrimSpace(cfg.ConfigType))
+	}
+
+	var command configTestCommand
+	candidates := configTestCommands[server]
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate.binary); err == nil {
+			command = candidate
+			break
+		}
+	}
+	if command.binary == "" && len(candidates) > 0 {
+		command = candidates[0]
+	}
+
+	if test.Binary != "" {
+		command.binary = test.Binary
+	}
+	if test.Args != nil {
+		command.args = test.Args
+	} else if server == "haproxy" {
+		args, err := haproxyConfigTestArgs(command.args)
+		if err != nil {
+			return configTestCommand{}, err
+		}
+		command.args = args
+	}
+	if command.binary == "" {
+		return configTestCommand{}, fmt.Errorf("no config test for server %q; set a binary", server)
+	}
+	return command, nil
+}
+
+// haproxyConfigTestArgs adds a -f for each config file the haproxy inventory
+// provider finds, since HAProxy has no default config path of its own.
+func haproxyConfigTestArgs(args []string) ([]string, error) {
+	files, err := inventory.HaproxyConfigFiles()
+	if err != nil {
+		return nil, fmt.Errorf("find haproxy config: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no haproxy config found; set config_test.args")
+	}
+	args = append([]string(nil), args...)
+	for _, file := range files {
+		args = append(args, "-f", file)
+	}
+	return args, nil
+}
+
+// runConfigTest runs cfg's config test and returns its combined output.
+func runConfigTest(ctx context.Context, cfg config.CertificateConfiguration) (string, error) {
+	command, err := configTestCommandFor(cfg)
+	if err != nil {
+		return "", err
+	}
+	timeout := configTestTimeout
+	if cfg.ConfigTest.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.ConfigTest.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("Running config test for config %s: %s %s", cfg.Id, command.binary, strings.Join(command.args, " "))
+	cmd := exec.CommandContext(ctx, command.binary, command.args...)
+	cmd.WaitDelay = 5 * time.Second
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("config test %s failed: %w\n%s", command.binary, err, string(output))
+	}
+	return string(output), nil
+}
+
+// fileSnapshot is the content of a certificate file before it was replaced.
+type fileSnapshot struct {
+	path    string
+	data    []byte
+	mode    os.FileMode
+	existed bool
+}
+
+// snapshotCertificateFiles records cfg's certificate files so a failed config
+// test can put them back.
+func snapshotCertificateFiles(cfg config.CertificateConfiguration) ([]fileSnapshot, error) {
+	paths := certificateFilePaths(cfg)
+	snapshots := make([]fileSnapshot, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			snapshots = append(snapshots, fileSnapshot{path: path})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, fileSnapshot{path: path, data: data, mode: info.Mode().Perm(), existed: true})
+	}
+	return snapshots, nil
+}
+
+// restoreCertificateFiles writes the snapshots back, removing files that did
+// not exist before, and reapplies cfg's ownership and permissions.
+func restoreCertificateFiles(cfg config.CertificateConfiguration, snapshots []fileSnapshot) error {
+	var errs []error
+	for _, snapshot := range snapshots {
+		if !snapshot.existed {
+			if err := os.Remove(snapshot.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		log.Printf("Restoring previous %s", snapshot.path)
+		if err := utils.WriteFileAtomic(snapshot.path, snapshot.data, snapshot.mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := applyCertificatePermissions(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
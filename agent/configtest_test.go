@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+func TestConfigTestCommandFor(t *testing.T) {
+	for _, path := range []string{"/etc/haproxy/haproxy.cfg", "/usr/local/etc/haproxy/haproxy.cfg"} {
+		if _, err := os.Stat(path); err == nil {
+			t.Skipf("%s exists on this host", path)
+		}
+	}
+	previous := config.CurrentConfig.Inventory
+	defer func() { config.CurrentConfig.Inventory = previous }()
+
+	config.CurrentConfig.Inventory = nil
+	cfg := config.CertificateConfiguration{ConfigType: "HAProxy", ConfigTest: &config.ConfigTest{}}
+	if _, err := configTestCommandFor(cfg); err == nil {
+		t.Error("expected error for haproxy without a config file")
+	}
+
+	haproxyConfig := filepath.Join(t.TempDir(), "edge.cfg")
+	if err := os.WriteFile(haproxyConfig, []byte("global\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config.CurrentConfig.Inventory = &config.InventoryConfig{ExtraConfigPaths: map[string][]string{"haproxy": {haproxyConfig}}}
+	command, err := configTestCommandFor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command.binary != "haproxy" || strings.Join(command.args, " ") != "-c -f "+haproxyConfig {
+		t.Errorf("haproxy command = %+v", command)
+	}
+
+	cfg.ConfigTest = &config.ConfigTest{Args: []string{"-c", "-f", "/srv/haproxy.cfg"}}
+	command, _ = configTestCommandFor(cfg)
+	if command.binary != "haproxy" || command.args[2] != "/srv/haproxy.cfg" {
+		t.Errorf("args override = %+v", command)
+	}
+
+	cfg.ConfigTest = &config.ConfigTest{Server: "nginx", Binary: "/opt/openresty/bin/openresty"}
+	command, _ = configTestCommandFor(cfg)
+	if command.binary != "/opt/openresty/bin/openresty" || strings.Join(command.args, " ") != "-t" {
+		t.Errorf("binary override = %+v", command)
+	}
+
+	cfg = config.CertificateConfiguration{ConfigType: "custom", ConfigTest: &config.ConfigTest{}}
+	if _, err := configTestCommandFor(cfg); err == nil {
+		t.Error("expected error for unknown server without a binary")
+	}
+}
+
+func TestFailedConfigTestRestoresFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	cfg := config.CertificateConfiguration{
+		Id:             "cfg",
+		PemDestination: filepath.Join(dir, "cert.pem"),
+		KeyDestination: filepath.Join(dir, "key.pem"),
+		ConfigTest:     &config.ConfigTest{Binary: "sh", Args: []string{"-c", "echo 'bad key' >&2; exit 1"}},
+	}
+	if err := os.WriteFile(cfg.PemDestination, []byte("old cert"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := snapshotCertificateFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(cfg.PemDestination, []byte("new cert"), 0o600)
+	os.WriteFile(cfg.KeyDestination, []byte("new key"), 0o600)
+
+	_, err = runConfigTest(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Fatalf("runConfigTest error = %v", err)
+	}
+	if err := restoreCertificateFiles(cfg, snapshots); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(cfg.PemDestination)
+	if string(data) != "old cert" {
+		t.Errorf("cert = %q, want old cert", data)
+	}
+	if info, _ := os.Stat(cfg.PemDestination); info.Mode().Perm() != 0o640 {
+		t.Errorf("cert mode = %v", info.Mode().Perm())
+	}
+	if _, err := os.Stat(cfg.KeyDestination); !os.IsNotExist(err) {
+		t.Errorf("key written by the failed deploy should be removed, stat err = %v", err)
+	}
+}
+
+func TestFailedConfigTestWaitsForChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	certPem, keyPem, sha1 := testCertificate(t, "www.example.com")
+	fetches := fakeCertkitAPI(t, certPem, keyPem)
+	dir := t.TempDir()
+	cfg := config.CertificateConfiguration{
+		Id:                    "cfg",
+		CertificateId:         "cert",
+		LatestCertificateSha1: sha1,
+		PemDestination:        filepath.Join(dir, "cert.pem"),
+		KeyDestination:        filepath.Join(dir, "key.pem"),
+		ConfigTest:            &config.ConfigTest{Binary: "sh", Args: []string{"-c", "exit 1"}},
+	}
+
+	status := synchronizeCertificate(context.Background(), cfg, false)
+	if status.Status != statusErrorConfigTest || fetches.Load() != 1 {
+		t.Fatalf("first sync: status = %s, fetches = %d", status.Status, fetches.Load())
+	}
+
+	cfg.LastStatus = status.Status
+	status = synchronizeCertificate(context.Background(), cfg, false)
+	if status.Status != statusErrorConfigTest || fetches.Load() != 1 {
+		t.Errorf("unchanged config: status = %s, fetches = %d, want no retry", status.Status, fetches.Load())
+	}
+
+	status = synchronizeCertificate(context.Background(), cfg, true)
+	if status.Status != statusErrorConfigTest || fetches.Load() != 2 {
+		t.Errorf("changed config: status = %s, fetches = %d, want a retry", status.Status, fetches.Load())
+	}
+}
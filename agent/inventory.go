@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/inventory"
+)
+
+const (
+	// InventoryInterval is how often the run loop re-collects inventory.
+	InventoryInterval = 8 * time.Hour
+
+	// inventoryResendInterval forces a full upload even when nothing has
+	// changed, in case the server lost what it was sent.
+	inventoryResendInterval = 7 * 24 * time.Hour
+)
+
+// SendInventory collects inventory and uploads it when it differs from what
+// was last sent, or when the last upload is older than the resend interval.
+func SendInventory(ctx context.Context) {
+	update, err := collectInventory(ctx)
+	if err != nil {
+		return
+	}
+
+	hash, err := inventoryHash(update)
+	if err != nil {
+		reportAgentError(fmt.Errorf("hash inventory: %w", err), "", "")
+		return
+	}
+	sentAt := config.CurrentConfig.InventorySentAt
+	if hash == config.CurrentConfig.InventoryHash && sentAt != nil && time.Since(*sentAt) < inventoryResendInterval {
+		log.Printf("Inventory unchanged since %s; not sending", sentAt.Format(time.RFC3339))
+		return
+	}
+	_ = uploadInventory(ctx, update, hash)
+}
+
+// CollectInventory runs inventory discovery and, when send is true, uploads
+// the result to CertKit regardless of whether it changed. Provider errors are
+// part of the result; the error return covers cancellation and upload
+// failures.
+func CollectInventory(ctx context.Context, send bool) (api.InventoryUpdate, error) {
+	update, err := collectInventory(ctx)
+	if err != nil || !send {
+		return update, err
+	}
+
+	hash, err := inventoryHash(update)
+	if err != nil {
+		err = fmt.Errorf("hash inventory: %w", err)
+		reportAgentError(err, "", "")
+		return update, err
+	}
+	return update, uploadInventory(ctx, update, hash)
+}
+
+func collectInventory(ctx context.Context) (api.InventoryUpdate, error) {
+	update, err := inventory.Collect(ctx)
+	if err != nil {
+		err = fmt.Errorf("collect inventory: %w", err)
+		reportAgentError(err, "", "")
+		return api.InventoryUpdate{}, err
+	}
+	return update, nil
+}
+
+func uploadInventory(ctx context.Context, update api.InventoryUpdate, hash string) error {
+	if err := api.UpdateInventory(ctx, update); err != nil {
+		err = fmt.Errorf("update inventory: %w", err)
+		reportAgentError(err, "", "")
+		return err
+	}
+
+	now := time.Now().UTC()
+	config.CurrentConfig.InventoryHash = hash
+	config.CurrentConfig.InventorySentAt = &now
+	if err := config.SaveConfig(&config.CurrentConfig, config.CurrentPath); err != nil {
+		log.Printf("Error saving config: %v", err)
+	}
+	recordInventorySent(now)
+	return nil
+}
+
+// inventoryHash fingerprints an inventory independently of the order items
+// and errors were discovered in, since providers run concurrently and some
+// iterate maps. Process IDs are left out so restarting a server does not
+// count as a change.
+func inventoryHash(update api.InventoryUpdate) (string, error) {
+	entries := make([][]byte, 0, len(update.Items)+len(update.Errors))
+	for _, item := range update.Items {
+		if item.Endpoint != nil {
+			endpoint := *item.Endpoint
+			endpoint.PID = 0
+			item.Endpoint = &endpoint
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, append([]byte("item:"), data...))
+	}
+	for _, inventoryError := range update.Errors {
+		data, err := json.Marshal(inventoryError)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, append([]byte("error:"), data...))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+
+	hash := sha256.New()
+	for _, entry := range entries {
+		hash.Write(entry)
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	agentCrypto "github.com/certkit-io/certkit-agent/crypto"
+)
+
+func TestInventoryHashIgnoresOrder(t *testing.T) {
+	a := api.InventoryItem{Server: "nginx", CertificatePath: "/etc/ssl/a.pem"}
+	b := api.InventoryItem{Server: "apache", CertificatePath: "/etc/ssl/b.pem"}
+	failure := api.InventoryError{Provider: "docker", Error: "permission denied"}
+
+	first, err := inventoryHash(api.InventoryUpdate{Items: []api.InventoryItem{a, b}, Errors: []api.InventoryError{failure}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := inventoryHash(api.InventoryUpdate{Items: []api.InventoryItem{b, a}, Errors: []api.InventoryError{failure}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("hash depends on item order: %s != %s", first, second)
+	}
+
+	changed, err := inventoryHash(api.InventoryUpdate{Items: []api.InventoryItem{a}, Errors: []api.InventoryError{failure}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == first {
+		t.Error("hash did not change when an item was removed")
+	}
+}
+
+func TestInventoryHashIgnoresProcessID(t *testing.T) {
+	served := func(pid int) api.InventoryUpdate {
+		return api.InventoryUpdate{Items: []api.InventoryItem{{
+			Server:          "tls-probe",
+			CertificatePath: "tls://127.0.0.1:443",
+			Endpoint:        &api.InventoryEndpoint{Address: "0.0.0.0:443", PID: pid, ProcessName: "nginx"},
+		}}}
+	}
+	first, err := inventoryHash(served(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := served(200)
+	second, err := inventoryHash(restarted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("hash changed when only the process ID did")
+	}
+	if restarted.Items[0].Endpoint.PID != 200 {
+		t.Error("hashing modified the inventory item")
+	}
+}
+
+// countingInventoryAPI points the agent at a stand-in CertKit API with every
+// inventory provider disabled, and returns a counter of inventory uploads.
+func countingInventoryAPI(t *testing.T) *atomic.Int32 {
+	t.Helper()
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/update-inventory") {
+			uploads.Add(1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	saved, savedPath := config.CurrentConfig, config.CurrentPath
+	t.Cleanup(func() { config.CurrentConfig, config.CurrentPath = saved, savedPath })
+	config.CurrentPath = filepath.Join(t.TempDir(), "config.json")
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+	config.CurrentConfig.InventoryHash = ""
+	config.CurrentConfig.InventorySentAt = nil
+	config.CurrentConfig.Inventory = &config.InventoryConfig{
+		DisabledProviders: []string{"nginx", "apache", "litespeed", "haproxy", "caddy", "traefik", "kubernetes", "docker", "docker-engine", "tls-probe", "filesystem", "iis", "rras"},
+	}
+	return &uploads
+}
+
+func TestSendInventorySkipsUnchangedInventory(t *testing.T) {
+	uploads := countingInventoryAPI(t)
+
+	SendInventory(t.Context())
+	if uploads.Load() != 1 || config.CurrentConfig.InventoryHash == "" || config.CurrentConfig.InventorySentAt == nil {
+		t.Fatalf("first inventory not sent: uploads=%d", uploads.Load())
+	}
+	SendInventory(t.Context())
+	if uploads.Load() != 1 {
+		t.Errorf("unchanged inventory was uploaded again: uploads=%d", uploads.Load())
+	}
+
+	config.CurrentConfig.InventoryHash = "previous"
+	SendInventory(t.Context())
+	if uploads.Load() != 2 {
+		t.Errorf("changed inventory was not uploaded: uploads=%d", uploads.Load())
+	}
+}
+
+func TestSendInventoryResendsAfterInterval(t *testing.T) {
+	uploads := countingInventoryAPI(t)
+
+	SendInventory(t.Context())
+	sentAt := time.Now().Add(-inventoryResendInterval - time.Hour)
+	config.CurrentConfig.InventorySentAt = &sentAt
+	SendInventory(t.Context())
+	if uploads.Load() != 2 {
+		t.Errorf("inventory not resent after %s: uploads=%d", inventoryResendInterval, uploads.Load())
+	}
+	if !config.CurrentConfig.InventorySentAt.After(sentAt) {
+		t.Error("resend did not update the last sent time")
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const (
+	changeWaitDuration        = 60 * time.Second
+	changeRetryMinBackoff     = 5 * time.Second
+	changeRetryMaxBackoff     = 5 * time.Minute
+	changeUnsupportedInterval = 30 * time.Minute
+	// changeMinInterval spaces out requests when the server, or a proxy in
+	// front of it, answers without holding the long poll open.
+	changeMinInterval = 10 * time.Second
+)
+
+// WatchForChanges keeps a long-poll connection open to the CertKit server and
+// sends on changed whenever the server reports a configuration change. The
+// regular polling loop keeps running independently, so when the channel is
+// unavailable the agent simply falls back to polling. It returns when ctx is
+// cancelled.
+func WatchForChanges(ctx context.Context, changed chan<- struct{}) {
+	backoff := changeRetryMinBackoff
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := time.Duration(0)
+		if utils.IsAgentUnauthorized() {
+			wait = changeRetryMaxBackoff
+		} else {
+			started := time.Now()
+			response, err := api.WaitForChanges(ctx, changeWaitDuration)
+			switch {
+			case errors.Is(err, api.ErrChangeNotificationsUnsupported):
+				wait = changeUnsupportedInterval
+			case ctx.Err() != nil:
+				return
+			case err != nil:
+				log.Printf("Change notification channel unavailable, relying on polling: %v", err)
+				wait = backoff
+				backoff = min(backoff*2, changeRetryMaxBackoff)
+			default:
+				backoff = changeRetryMinBackoff
+				wait = max(changeMinInterval-time.Since(started), 0)
+				if response != nil && response.ConfigChanged {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+
+		if wait == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
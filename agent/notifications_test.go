@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	agentCrypto "github.com/certkit-io/certkit-agent/crypto"
+)
+
+func TestWatchForChangesNotifiesAndStops(t *testing.T) {
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"config_changed":true}`))
+	}))
+	t.Cleanup(server.Close)
+
+	saved := config.CurrentConfig
+	t.Cleanup(func() { config.CurrentConfig = saved })
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		WatchForChanges(ctx, changed)
+		close(done)
+	}()
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change notification delivered")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchForChanges did not return after cancellation")
+	}
+}
+
+func TestWatchForChangesSpacesOutImmediateReplies(t *testing.T) {
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+
+	saved := config.CurrentConfig
+	t.Cleanup(func() { config.CurrentConfig = saved })
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+	defer cancel()
+	WatchForChanges(ctx, make(chan struct{}, 1))
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("server received %d requests in 500ms, want 1", got)
+	}
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/control"
+	"github.com/certkit-io/certkit-agent/metrics"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+// Runtime state is read from the control socket goroutines while the run loop
+// updates it, so everything here is guarded by stateMu and only ever holds
+// copies of config data.
+var (
+	stateMu            sync.Mutex
+	lastPoll           time.Time
+	lastSuccessfulPoll time.Time
+	lastPollError      string
+	configStates       []control.ConfigStatus
+	lastInventorySent  time.Time
+)
+
+func recordPoll(result string, duration time.Duration, err error) {
+	metrics.ObservePoll(result, duration)
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	lastPoll = time.Now().UTC()
+	if result == metrics.PollSuccess {
+		lastSuccessfulPoll = lastPoll
+	}
+	lastPollError = ""
+	if err != nil {
+		lastPollError = err.Error()
+	}
+}
+
+func recordInventorySent(sentAt time.Time) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	lastInventorySent = sentAt
+}
+
+// RefreshConfigState republishes per-configuration status and the expiry of
+// the certificate currently deployed on disk for each configuration, for both
+// the metrics endpoint and the control socket.
+func RefreshConfigState() {
+	statuses := make(map[string]string, len(config.CurrentConfig.CertificateConfigurations))
+	expiries := make(map[string]time.Time, len(config.CurrentConfig.CertificateConfigurations))
+	states := make([]control.ConfigStatus, 0, len(config.CurrentConfig.CertificateConfigurations))
+	for _, cfg := range config.CurrentConfig.CertificateConfigurations {
+		if cfg.Id == "" {
+			continue
+		}
+		state := control.ConfigStatus{
+			ConfigId:      cfg.Id,
+			Name:          cfg.Name,
+			ConfigType:    cfg.ConfigType,
+			CertificateId: cfg.CertificateId,
+			LastStatus:    cfg.LastStatus,
+		}
+		if cfg.LastStatus != "" {
+			statuses[cfg.Id] = cfg.LastStatus
+		}
+		if notAfter, ok := deployedCertificateNotAfter(cfg); ok {
+			expiries[cfg.Id] = notAfter
+			state.DeployedExpiry = &notAfter
+		}
+		states = append(states, state)
+	}
+	metrics.SetConfigStatuses(statuses)
+	metrics.SetCertificateExpiries(expiries)
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	configStates = states
+	if sentAt := config.CurrentConfig.InventorySentAt; sentAt != nil && sentAt.After(lastInventorySent) {
+		lastInventorySent = *sentAt
+	}
+}
+
+// Status reports what the running agent is doing for `certkit-agent status`.
+func Status(agentId string, version string) control.StatusReport {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	report := control.StatusReport{
+		AgentId:       agentId,
+		Version:       version,
+		Authorized:    !utils.IsAgentUnauthorized(),
+		LastPollError: lastPollError,
+		Configs:       append([]control.ConfigStatus(nil), configStates...),
+	}
+	if !lastPoll.IsZero() {
+		t := lastPoll
+		report.LastPoll = &t
+	}
+	if !lastSuccessfulPoll.IsZero() {
+		t := lastSuccessfulPoll
+		report.LastSuccessfulPoll = &t
+	}
+	if !lastInventorySent.IsZero() {
+		t := lastInventorySent
+		report.LastInventorySent = &t
+	}
+	return report
+}
+
+// deployedExpiries holds the expiry of the certificate last deployed for each
+// configuration whose type leaves no local file to read it from.
+var deployedExpiries = struct {
+	sync.Mutex
+	byConfig map[string]time.Time
+}{byConfig: make(map[string]time.Time)}
+
+// recordDeployedCertificate remembers the expiry of the certificate now held
+// by the configuration's target, or forgets it when certPem has none.
+func recordDeployedCertificate(configId string, certPem []byte) {
+	notAfter, err := utils.GetCertificateNotAfterFromPem(certPem)
+	deployedExpiries.Lock()
+	defer deployedExpiries.Unlock()
+	if err != nil {
+		delete(deployedExpiries.byConfig, configId)
+		return
+	}
+	deployedExpiries.byConfig[configId] = notAfter
+}
+
+func deployedCertificateNotAfter(cfg config.CertificateConfiguration) (time.Time, bool) {
+	switch strings.ToLower(cfg.ConfigType) {
+	case "iis", "rras":
+		return time.Time{}, false
+	case configTypeKubernetesSecret, configTypeVaultKV, configTypeSSH:
+		deployedExpiries.Lock()
+		defer deployedExpiries.Unlock()
+		notAfter, ok := deployedExpiries.byConfig[cfg.Id]
+		return notAfter, ok
+	}
+	if strings.TrimSpace(cfg.PemDestination) == "" {
+		return time.Time{}, false
+	}
+
+	if cfg.IsPfx {
+		password, err := os.ReadFile(utils.PfxPasswordFilePath(cfg.PemDestination))
+		if err != nil {
+			return time.Time{}, false
+		}
+		notAfter, err := utils.GetCertificateNotAfterFromPfx(cfg.PemDestination, string(password))
+		if err != nil {
+			return time.Time{}, false
+		}
+		return notAfter, true
+	}
+
+	notAfter, err := utils.GetCertificateNotAfter(cfg.PemDestination)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return notAfter, true
+}
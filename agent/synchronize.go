@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"encoding/pem"
 	"fmt"
 	"log"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/certkit-io/certkit-agent/api"
 	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/metrics"
 	"github.com/certkit-io/certkit-agent/utils"
 )
 
@@ -25,15 +27,36 @@ const (
 	statusErrorGetCert   = "ERROR_GET_CERTS"
 	statusErrorWriteCert = "ERROR_WRITE_CERTS"
 	statusErrorGeneral   = "ERROR_GENERAL"
+	// statusErrorConfigTest is not retried on its own: the same certificate
+	// would fail the same test. A new certificate or configuration arrives
+	// as a configuration change.
+	statusErrorConfigTest = "ERROR_CONFIG_TEST"
 )
 
-func SynchronizeCertificates(configChanged bool) []api.AgentConfigStatusUpdate {
+func SynchronizeCertificates(ctx context.Context, configChanged bool) []api.AgentConfigStatusUpdate {
+	statuses, _ := synchronizeConfigurations(ctx, configChanged, "")
+	return statuses
+}
+
+// synchronizeConfigurations synchronizes every configuration, or only the one
+// matching configId when it is non-empty.
+func synchronizeConfigurations(ctx context.Context, configChanged bool, configId string) ([]api.AgentConfigStatusUpdate, error) {
 	statuses := make([]api.AgentConfigStatusUpdate, 0, len(config.CurrentConfig.CertificateConfigurations))
 	configDirty := false
+	matched := false
 
 	for i := range config.CurrentConfig.CertificateConfigurations {
+		// Configurations are only abandoned between deployments, never midway.
+		if ctx.Err() != nil {
+			log.Printf("Synchronization interrupted; remaining configurations will be synchronized on next start")
+			break
+		}
 		cfg := &config.CurrentConfig.CertificateConfigurations[i]
-		status := synchronizeCertificate(*cfg, configChanged)
+		if configId != "" && cfg.Id != configId {
+			continue
+		}
+		matched = true
+		status := synchronizeCertificate(ctx, *cfg, configChanged)
 		if status.ConfigId != "" {
 			statuses = append(statuses, status)
 			if status.Status != "" && status.Status != cfg.LastStatus {
@@ -47,15 +70,28 @@ func SynchronizeCertificates(configChanged bool) []api.AgentConfigStatusUpdate {
 			reportAgentError(err, "", "")
 		}
 	}
-	return statuses
+	RefreshConfigState()
+	if configId != "" && !matched {
+		return nil, fmt.Errorf("unknown certificate configuration %q", configId)
+	}
+	return statuses, nil
 }
 
-func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
+func synchronizeCertificate(ctx context.Context, cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
 	if strings.EqualFold(cfg.ConfigType, "iis") {
-		return synchronizeIISCertificate(cfg, configChanged)
+		return synchronizeIISCertificate(ctx, cfg, configChanged)
 	}
 	if strings.EqualFold(cfg.ConfigType, "rras") {
-		return synchronizeRRASCertificate(cfg, configChanged)
+		return synchronizeRRASCertificate(ctx, cfg, configChanged)
+	}
+	if strings.EqualFold(cfg.ConfigType, configTypeKubernetesSecret) {
+		return synchronizeKubernetesSecret(ctx, cfg, configChanged)
+	}
+	if strings.EqualFold(cfg.ConfigType, configTypeVaultKV) {
+		return synchronizeVaultKV(ctx, cfg, configChanged)
+	}
+	if strings.EqualFold(cfg.ConfigType, configTypeSSH) {
+		return synchronizeSSH(ctx, cfg, configChanged)
 	}
 
 	status := api.AgentConfigStatusUpdate{
@@ -76,11 +112,23 @@ func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged b
 		status.Message = "Error: missing destination path(s) in configuration"
 		return status
 	}
+	if err := validateTarget(cfg); err != nil {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error: %v", err)
+		return status
+	}
 	if cfg.Id == "" || cfg.CertificateId == "" {
 		log.Printf("Skipping certificate config with missing ids (config_id=%s, certificate_id=%s)", cfg.Id, cfg.CertificateId)
 		return api.AgentConfigStatusUpdate{}
 	}
 
+	if cfg.LastStatus == statusErrorConfigTest && !configChanged {
+		log.Printf("Skipping certificate config %s: config test failed; waiting for a configuration or certificate change", cfg.Id)
+		status.Status = statusErrorConfigTest
+		status.Message = "Error: config test failed; waiting for a configuration or certificate change"
+		return status
+	}
+
 	needsFetch, err := needsCertificateFetch(cfg)
 	if err != nil {
 		status.Status = statusErrorGetCert
@@ -89,10 +137,21 @@ func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged b
 	}
 
 	shouldFetch := needsFetch || retryFull
+	// With a config test configured, the files being replaced are kept so a
+	// failed write or test can be rolled back.
+	var snapshots []fileSnapshot
+	if shouldFetch && cfg.ConfigTest != nil {
+		snapshots, err = snapshotCertificateFiles(cfg)
+		if err != nil {
+			status.Status = statusErrorWriteCert
+			status.Message = fmt.Sprintf("Error saving current certificate files: %v", err)
+			return status
+		}
+	}
 	if shouldFetch {
 		if isPfx {
 			log.Printf("Fetching new PFX for config %s and certificate %s", cfg.Id, cfg.CertificateId)
-			pfxResponse, err := api.FetchPfx(cfg.Id, cfg.CertificateId)
+			pfxResponse, err := api.FetchPfx(ctx, cfg.Id, cfg.CertificateId)
 			if err != nil {
 				status.Status = statusErrorGetCert
 				status.Message = fmt.Sprintf("Error fetching PFX: %v", err)
@@ -104,15 +163,21 @@ func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged b
 				status.Message = "Error: no issued PFX returned"
 				return status
 			}
+			if err := ctx.Err(); err != nil {
+				status.Status = statusErrorGetCert
+				status.Message = fmt.Sprintf("Error: synchronization interrupted before writing PFX: %v", err)
+				return status
+			}
 
 			if err := writePfxFiles(cfg, pfxResponse); err != nil {
+				rollbackCertificateFiles(cfg, snapshots)
 				status.Status = statusErrorWriteCert
 				status.Message = fmt.Sprintf("Error writing PFX files: %v", err)
 				return status
 			}
 		} else {
 			log.Printf("Fetching new certificate for config %s and certificate %s", cfg.Id, cfg.CertificateId)
-			response, err := api.FetchCertificate(cfg.Id, cfg.CertificateId)
+			response, err := api.FetchCertificate(ctx, cfg.Id, cfg.CertificateId)
 			if err != nil {
 				status.Status = statusErrorGetCert
 				status.Message = fmt.Sprintf("Error fetching certificate: %v", err)
@@ -124,8 +189,14 @@ func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged b
 				status.Message = "Error: no issued certificate returned"
 				return status
 			}
+			if err := ctx.Err(); err != nil {
+				status.Status = statusErrorGetCert
+				status.Message = fmt.Sprintf("Error: synchronization interrupted before writing certificate: %v", err)
+				return status
+			}
 
 			if err := writeCertificateFiles(cfg, response); err != nil {
+				rollbackCertificateFiles(cfg, snapshots)
 				status.Status = statusErrorWriteCert
 				status.Message = fmt.Sprintf("Error writing certificate files: %v", err)
 				return status
@@ -135,12 +206,22 @@ func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged b
 
 	if needsFetch || configChanged || retryUpdateOnly || retryFull {
 		if err := applyCertificatePermissions(cfg); err != nil {
+			rollbackCertificateFiles(cfg, snapshots)
 			status.Status = statusErrorWriteCert
 			status.Message = fmt.Sprintf("Error applying certificate permissions: %v", err)
 			return status
 		}
 	}
 
+	if shouldFetch && cfg.ConfigTest != nil {
+		if _, err := runConfigTest(ctx, cfg); err != nil {
+			rollbackCertificateFiles(cfg, snapshots)
+			status.Status = statusErrorConfigTest
+			status.Message = fmt.Sprintf("Error: config test failed, previous certificate files restored: %v", err)
+			return status
+		}
+	}
+
 	if needsFetch || configChanged || retryUpdateOnly || retryFull {
 		if !needsFetch && configChanged {
 			log.Print("Running update cmd due to configuration change...")
@@ -148,25 +229,53 @@ func synchronizeCertificate(cfg config.CertificateConfiguration, configChanged b
 		if retryUpdateOnly || retryFull {
 			log.Print("Retrying update command due to previous failure...")
 		}
-		if strings.TrimSpace(cfg.UpdateCmd) == "" {
+		deployOutput, err := deployTarget(ctx, cfg)
+		if err != nil {
+			status.Status = statusErrorUpdateCmd
+			status.Message = fmt.Sprintf("Error deploying certificate to %s: %v", cfg.ConfigType, err)
+			return status
+		}
+		status.Message = deployOutput
+		if !hasUpdate(cfg) {
 			log.Print("No update command configured; skipping update command.")
 		} else {
-			if commandOutput, err := runUpdateCommand(cfg); err != nil {
+			if commandOutput, err := runUpdate(ctx, cfg); err != nil {
 				status.Status = statusErrorUpdateCmd
 				status.Message = fmt.Sprintf("Error running update command: %v", err)
 				return status
 			} else {
-				status.Message = fmt.Sprintf("Update command output: \n%s", commandOutput)
+				status.Message += fmt.Sprintf("Update command output: \n%s", commandOutput)
 			}
 		}
 	} else {
-		log.Printf("Synchronization checks complete.  No action taken, everything up to date (config=%s).", cfg.Id)
+		deployOutput, err := verifyTarget(ctx, cfg)
+		if err != nil {
+			status.Status = statusErrorUpdateCmd
+			status.Message = fmt.Sprintf("Error deploying certificate to %s: %v", cfg.ConfigType, err)
+			return status
+		}
+		if deployOutput != "" {
+			status.Message = deployOutput
+		} else {
+			log.Printf("Synchronization checks complete.  No action taken, everything up to date (config=%s).", cfg.Id)
+		}
 	}
 
 	status.Status = statusSynced
 	return status
 }
 
+// rollbackCertificateFiles restores snapshots, if any were taken. Failures
+// are logged; the sync is already failing.
+func rollbackCertificateFiles(cfg config.CertificateConfiguration, snapshots []fileSnapshot) {
+	if snapshots == nil {
+		return
+	}
+	if err := restoreCertificateFiles(cfg, snapshots); err != nil {
+		log.Printf("Failed to restore previous certificate files for config %s: %v", cfg.Id, err)
+	}
+}
+
 func needsCertificateFetch(cfg config.CertificateConfiguration) (bool, error) {
 	if cfg.IsPfx {
 		pfxExists, err := utils.FileExists(cfg.PemDestination)
@@ -178,7 +287,7 @@ func needsCertificateFetch(cfg config.CertificateConfiguration) (bool, error) {
 			return true, nil
 		}
 
-		passwordFilePath := pfxPasswordFilePath(cfg.PemDestination)
+		passwordFilePath := utils.PfxPasswordFilePath(cfg.PemDestination)
 		passwordExists, err := utils.FileExists(passwordFilePath)
 		if err != nil {
 			log.Printf("Failed to stat PFX password file %s: %v (forcing fetch)", passwordFilePath, err)
@@ -330,7 +439,7 @@ func writePfxFiles(cfg config.CertificateConfiguration, response *api.FetchPfxRe
 		return err
 	}
 
-	passwordFilePath := pfxPasswordFilePath(cfg.PemDestination)
+	passwordFilePath := utils.PfxPasswordFilePath(cfg.PemDestination)
 	log.Printf("Writing PFX password to %s", passwordFilePath)
 	if err := utils.WriteFileAtomic(passwordFilePath, []byte(response.Password), 0o600); err != nil {
 		return err
@@ -382,21 +491,7 @@ func applyCertificatePermissions(cfg config.CertificateConfiguration) error {
 		return nil
 	}
 
-	paths := []string{cfg.PemDestination}
-	if cfg.IsPfx {
-		paths = append(paths, pfxPasswordFilePath(cfg.PemDestination))
-	} else if !cfg.AllInOne {
-		paths = append(paths, cfg.KeyDestination)
-	}
-	chainDestination := strings.TrimSpace(cfg.ChainDestination)
-	if chainDestination != "" {
-		paths = append(paths, chainDestination)
-	}
-
-	for _, path := range paths {
-		if strings.TrimSpace(path) == "" {
-			continue
-		}
+	for _, path := range certificateFilePaths(cfg) {
 		exists, err := utils.FileExists(path)
 		if err != nil {
 			return err
@@ -412,14 +507,26 @@ func applyCertificatePermissions(cfg config.CertificateConfiguration) error {
 	return nil
 }
 
-func pfxPasswordFilePath(pfxPath string) string {
-	fileName := filepath.Base(pfxPath)
-	fileExt := filepath.Ext(fileName)
-	fileStem := strings.TrimSuffix(fileName, fileExt)
-	if fileStem == "" {
-		fileStem = fileName
+// certificateFilePaths returns the files cfg writes.
+func certificateFilePaths(cfg config.CertificateConfiguration) []string {
+	paths := []string{cfg.PemDestination}
+	if cfg.IsPfx {
+		paths = append(paths, utils.PfxPasswordFilePath(cfg.PemDestination))
+	} else if !cfg.AllInOne {
+		paths = append(paths, cfg.KeyDestination)
+	}
+	chainDestination := strings.TrimSpace(cfg.ChainDestination)
+	if chainDestination != "" {
+		paths = append(paths, chainDestination)
+	}
+
+	nonEmpty := paths[:0]
+	for _, path := range paths {
+		if strings.TrimSpace(path) != "" {
+			nonEmpty = append(nonEmpty, path)
+		}
 	}
-	return filepath.Join(filepath.Dir(pfxPath), fileStem+".pfxpassword.txt")
+	return nonEmpty
 }
 
 func applyFileOwnershipAndPermissions(cfg config.CertificateConfiguration, path string) error {
@@ -510,7 +617,10 @@ func resolveGroupId(name string) (int, error) {
 	return gid, nil
 }
 
-func runUpdateCommand(cfg config.CertificateConfiguration) (output string, err error) {
+// runUpdateCommand runs the configured update command. The command is killed if
+// ctx is cancelled; the resulting ERROR_UPDATE_CMD status makes the next run
+// retry it against the files that were already written.
+func runUpdateCommand(ctx context.Context, cfg config.CertificateConfiguration) (output string, err error) {
 	if strings.TrimSpace(cfg.UpdateCmd) == "" {
 		return "", nil
 	}
@@ -519,12 +629,16 @@ func runUpdateCommand(cfg config.CertificateConfiguration) (output string, err e
 
 	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" {
-		cmd = exec.Command("powershell", "-NoProfile", "-Command", cfg.UpdateCmd)
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", cfg.UpdateCmd)
 	} else {
-		cmd = exec.Command("sh", "-c", cfg.UpdateCmd)
+		cmd = exec.CommandContext(ctx, "sh", "-c", cfg.UpdateCmd)
 	}
+	// Don't wait forever on grandchildren that inherited the output pipes.
+	cmd.WaitDelay = 5 * time.Second
 
+	started := time.Now()
 	combinedOutput, err := cmd.CombinedOutput()
+	metrics.ObserveUpdateCommand(cfg.Id, time.Since(started), commandExitCode(cmd, err))
 	if len(combinedOutput) > 0 {
 		log.Printf("Update command output for '%s':\n%s", cfg.UpdateCmd, string(combinedOutput))
 	}
@@ -534,3 +648,13 @@ func runUpdateCommand(cfg config.CertificateConfiguration) (output string, err e
 
 	return string(combinedOutput), nil
 }
+
+func commandExitCode(cmd *exec.Cmd, err error) int {
+	if err == nil {
+		return 0
+	}
+	if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
+		return cmd.ProcessState.ExitCode()
+	}
+	return -1
+}
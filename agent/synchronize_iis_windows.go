@@ -3,6 +3,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -13,7 +14,7 @@ import (
 	"github.com/certkit-io/certkit-agent/utils"
 )
 
-func synchronizeIISCertificate(cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
+func synchronizeIISCertificate(ctx context.Context, cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
 	status := api.AgentConfigStatusUpdate{
 		ConfigId:       cfg.Id,
 		LastStatusDate: time.Now().UTC(),
@@ -52,7 +53,7 @@ func synchronizeIISCertificate(cfg config.CertificateConfiguration, configChange
 
 	if needsFetch || retryFull {
 		log.Printf("Fetching new PFX for config %s and certificate %s", cfg.Id, cfg.CertificateId)
-		resp, err := api.FetchPfx(cfg.Id, cfg.CertificateId)
+		resp, err := api.FetchPfx(ctx, cfg.Id, cfg.CertificateId)
 		if err != nil {
 			status.Status = statusErrorGetCert
 			status.Message = fmt.Sprintf("Error fetching PFX: %v", err)
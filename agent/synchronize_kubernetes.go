@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/kubeapi"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const (
+	configTypeKubernetesSecret = "k8s-secret"
+
+	kubernetesConfigIdAnnotation = "certkit.io/config-id"
+	kubernetesManagedByLabel     = "app.kubernetes.io/managed-by"
+)
+
+// synchronizeKubernetesSecret keeps a kubernetes.io/tls Secret in sync. The
+// Secret's leaf certificate is compared with LatestCertificateSha1, so a
+// Secret edited or deleted by someone else is put back on the next poll.
+func synchronizeKubernetesSecret(ctx context.Context, cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
+	status := api.AgentConfigStatusUpdate{
+		ConfigId:       cfg.Id,
+		LastStatusDate: time.Now().UTC(),
+	}
+	retryUpdateOnly := cfg.LastStatus == statusErrorUpdateCmd
+	retryFull := cfg.LastStatus == statusPendingSync ||
+		cfg.LastStatus == statusErrorGetCert ||
+		cfg.LastStatus == statusErrorWriteCert ||
+		cfg.LastStatus == statusErrorGeneral
+
+	target := cfg.Kubernetes
+	if target == nil || strings.TrimSpace(target.SecretName) == "" {
+		status.Status = statusErrorGeneral
+		status.Message = "Error: missing Kubernetes secret name in configuration"
+		return status
+	}
+	if cfg.Id == "" || cfg.CertificateId == "" {
+		log.Printf("Skipping Kubernetes config with missing ids (config_id=%s, certificate_id=%s)", cfg.Id, cfg.CertificateId)
+		return api.AgentConfigStatusUpdate{}
+	}
+
+	client, err := kubernetesClient(target.Kubeconfig, target.Context)
+	if err != nil {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error connecting to Kubernetes: %v", err)
+		return status
+	}
+	namespace := target.Namespace
+	if namespace == "" {
+		namespace = client.Namespace()
+	}
+
+	existing, err := client.GetSecret(ctx, namespace, target.SecretName)
+	if err != nil && !kubeapi.IsNotFound(err) {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error reading secret %s/%s: %v", namespace, target.SecretName, err)
+		return status
+	}
+	if existing != nil && existing.Type != "" && existing.Type != kubeapi.SecretTypeTLS {
+		status.Status = statusErrorWriteCert
+		status.Message = fmt.Sprintf("Error: secret %s/%s has type %s, not %s", namespace, target.SecretName, existing.Type, kubeapi.SecretTypeTLS)
+		return status
+	}
+	needsFetch := secretNeedsUpdate(existing, cfg.LatestCertificateSha1)
+	var deployedPem []byte
+	if existing != nil {
+		deployedPem = existing.Data["tls.crt"]
+	}
+
+	if needsFetch || configChanged || retryFull {
+		log.Printf("Fetching new certificate for config %s and certificate %s", cfg.Id, cfg.CertificateId)
+		response, err := api.FetchCertificate(ctx, cfg.Id, cfg.CertificateId)
+		if err != nil {
+			status.Status = statusErrorGetCert
+			status.Message = fmt.Sprintf("Error fetching certificate: %v", err)
+			return status
+		}
+		if response == nil || response.CertificatePem == "" || response.KeyPem == "" {
+			status.Status = statusErrorGetCert
+			status.Message = "Error: no issued certificate returned"
+			return status
+		}
+		if err := ctx.Err(); err != nil {
+			status.Status = statusErrorGetCert
+			status.Message = fmt.Sprintf("Error: synchronization interrupted before writing secret: %v", err)
+			return status
+		}
+
+		if err := writeKubernetesSecret(ctx, client, namespace, cfg, existing, response); err != nil {
+			status.Status = statusErrorWriteCert
+			status.Message = fmt.Sprintf("Error writing secret %s/%s: %v", namespace, target.SecretName, err)
+			return status
+		}
+		deployedPem = []byte(response.CertificatePem)
+	}
+	recordDeployedCertificate(cfg.Id, deployedPem)
+
+	if needsFetch || configChanged || retryUpdateOnly || retryFull {
+		if hasUpdate(cfg) {
+			commandOutput, err := runUpdate(ctx, cfg)
+			if err != nil {
+				status.Status = statusErrorUpdateCmd
+				status.Message = fmt.Sprintf("Error running update command: %v", err)
+				return status
+			}
+			status.Message = fmt.Sprintf("Update command output: \n%s", commandOutput)
+		}
+	} else {
+		log.Printf("Kubernetes secret %s/%s (config=%s) is up to date.", namespace, target.SecretName, cfg.Id)
+	}
+
+	status.Status = statusSynced
+	return status
+}
+
+func kubernetesClient(kubeconfig string, kubeContext string) (*kubeapi.Client, error) {
+	kubeConfig, err := kubeapi.LoadConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return kubeapi.NewClient(kubeConfig)
+}
+
+// secretNeedsUpdate reports whether secret is missing or holds a leaf
+// certificate other than the latest one.
+func secretNeedsUpdate(secret *kubeapi.Secret, latestSha1 string) bool {
+	if secret == nil || latestSha1 == "" {
+		return true
+	}
+	actual, err := utils.GetCertificateSha1FromPem(secret.Data["tls.crt"])
+	if err != nil {
+		return true
+	}
+	return !strings.EqualFold(actual, latestSha1)
+}
+
+// writeKubernetesSecret creates the Secret, or merge-patches the existing
+// one so labels and annotations added by others are kept.
+func writeKubernetesSecret(ctx context.Context, client *kubeapi.Client, namespace string, cfg config.CertificateConfiguration, existing *kubeapi.Secret, response *api.FetchCertificateResponse) error {
+	target := cfg.Kubernetes
+	leafPem, chainPem, err := splitLeafAndChain(response.CertificatePem)
+	if err != nil {
+		return fmt.Errorf("split certificate pem: %w", err)
+	}
+	data := map[string][]byte{
+		"tls.crt": []byte(leafPem + chainPem),
+		"tls.key": []byte(response.KeyPem),
+	}
+	if target.IncludeCA && chainPem != "" {
+		data["ca.crt"] = []byte(chainPem)
+	}
+	labels := map[string]string{kubernetesManagedByLabel: "certkit-agent"}
+	for key, value := range target.Labels {
+		labels[key] = value
+	}
+	annotations := map[string]string{kubernetesConfigIdAnnotation: cfg.Id}
+
+	if existing == nil {
+		log.Printf("Creating secret %s/%s", namespace, target.SecretName)
+		_, err := client.CreateSecret(ctx, &kubeapi.Secret{
+			Metadata: kubeapi.ObjectMeta{Name: target.SecretName, Namespace: namespace, Labels: labels, Annotations: annotations},
+			Type:     kubeapi.SecretTypeTLS,
+			Data:     data,
+		})
+		return err
+	}
+
+	patchData := make(map[string]any, len(data)+1)
+	for key, value := range data {
+		patchData[key] = value
+	}
+	if _, ok := existing.Data["ca.crt"]; ok && data["ca.crt"] == nil {
+		patchData["ca.crt"] = nil
+	}
+	patch := map[string]any{
+		"metadata": map[string]any{"labels": labels, "annotations": annotations},
+		"data":     patchData,
+	}
+	log.Printf("Updating secret %s/%s", namespace, target.SecretName)
+	_, err = client.PatchSecret(ctx, namespace, target.SecretName, patch)
+	return err
+}
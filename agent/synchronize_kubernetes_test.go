@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/kubeapi"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+// fakeKubernetes serves the core/v1 Secret endpoints for one namespace and
+// writes a kubeconfig pointing at itself.
+type fakeKubernetes struct {
+	mu       sync.Mutex
+	secrets  map[string]*kubeapi.Secret
+	requests []string
+}
+
+func startFakeKubernetes(t *testing.T) (*fakeKubernetes, string) {
+	t.Helper()
+	fake := &fakeKubernetes{secrets: make(map[string]*kubeapi.Secret)}
+	server := httptest.NewTLSServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	writeErr := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: agent
+    namespace: web
+users:
+- name: agent
+  user:
+    token: "sekret"
+`, server.URL, base64.StdEncoding.EncodeToString(caPem))), 0o600)
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	return fake, kubeconfig
+}
+
+func (f *fakeKubernetes) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("Authorization") != "Bearer sekret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/web/secrets")
+	name = strings.TrimPrefix(name, "/")
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","reason":"NotFound","message":"secrets \"` + name + `\" not found"}`))
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		secret, ok := f.secrets[name]
+		if !ok {
+			notFound()
+			return
+		}
+		json.NewEncoder(w).Encode(secret)
+	case http.MethodPost:
+		var secret kubeapi.Secret
+		json.NewDecoder(r.Body).Decode(&secret)
+		f.secrets[secret.Metadata.Name] = &secret
+		json.NewEncoder(w).Encode(secret)
+	case http.MethodPatch:
+		secret, ok := f.secrets[name]
+		if !ok {
+			notFound()
+			return
+		}
+		var patch struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+			Data map[string]*[]byte `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&patch)
+		for key, value := range patch.Data {
+			if value == nil {
+				delete(secret.Data, key)
+				continue
+			}
+			secret.Data[key] = *value
+		}
+		for key, value := range patch.Metadata.Annotations {
+			if secret.Metadata.Annotations == nil {
+				secret.Metadata.Annotations = map[string]string{}
+			}
+			secret.Metadata.Annotations[key] = value
+		}
+		json.NewEncoder(w).Encode(secret)
+	}
+}
+
+func TestSynchronizeKubernetesSecret(t *testing.T) {
+	certPem, keyPem, sha1 := testCertificate(t, "web.example.com")
+	fetches := fakeCertkitAPI(t, certPem, keyPem)
+	fake, kubeconfig := startFakeKubernetes(t)
+
+	cfg := config.CertificateConfiguration{
+		Id:                    "cfg1",
+		CertificateId:         "cert1",
+		ConfigType:            configTypeKubernetesSecret,
+		LatestCertificateSha1: sha1,
+		Kubernetes:            &config.KubernetesTarget{SecretName: "web-tls", IncludeCA: true, Kubeconfig: kubeconfig},
+	}
+
+	status := synchronizeCertificate(context.Background(), cfg, false)
+	if status.Status != statusSynced {
+		t.Fatalf("first sync = %+v", status)
+	}
+	secret := fake.secrets["web-tls"]
+	if secret == nil || secret.Type != kubeapi.SecretTypeTLS || string(secret.Data["tls.key"]) != keyPem {
+		t.Fatalf("secret = %+v", secret)
+	}
+	if string(secret.Data["tls.crt"]) != certPem || !strings.Contains(certPem, string(secret.Data["ca.crt"])) || len(secret.Data["ca.crt"]) == 0 {
+		t.Errorf("secret data = %q", secret.Data)
+	}
+	if secret.Metadata.Annotations[kubernetesConfigIdAnnotation] != "cfg1" {
+		t.Errorf("annotations = %v", secret.Metadata.Annotations)
+	}
+
+	// The Secret already holds the latest certificate, so nothing is fetched;
+	// its expiry is still known for status.
+	recordDeployedCertificate(cfg.Id, nil)
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced || fetches.Load() != 1 {
+		t.Fatalf("second sync = %+v, fetches = %d", status, fetches.Load())
+	}
+	if want, _ := utils.GetCertificateNotAfterFromPem([]byte(certPem)); !deployedExpiryIs(cfg, want) {
+		t.Errorf("deployed expiry not recorded")
+	}
+
+	// Someone replaced the certificate; it is fetched and patched back, and
+	// ca.crt is dropped now that it is not wanted.
+	secret.Data["tls.crt"] = []byte("stale")
+	cfg.Kubernetes.IncludeCA = false
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("third sync = %+v", status)
+	}
+	if fetches.Load() != 2 || string(secret.Data["tls.crt"]) != certPem {
+		t.Errorf("fetches = %d, tls.crt = %q", fetches.Load(), secret.Data["tls.crt"])
+	}
+	if _, ok := secret.Data["ca.crt"]; ok {
+		t.Error("ca.crt was not removed")
+	}
+	if last := fake.requests[len(fake.requests)-1]; last != "PATCH /api/v1/namespaces/web/secrets/web-tls" {
+		t.Errorf("last request = %s", last)
+	}
+}
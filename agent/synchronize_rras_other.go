@@ -3,13 +3,14 @@
 package agent
 
 import (
+	"context"
 	"time"
 
 	"github.com/certkit-io/certkit-agent/api"
 	"github.com/certkit-io/certkit-agent/config"
 )
 
-func synchronizeRRASCertificate(cfg config.CertificateConfiguration, _ bool) api.AgentConfigStatusUpdate {
+func synchronizeRRASCertificate(_ context.Context, cfg config.CertificateConfiguration, _ bool) api.AgentConfigStatusUpdate {
 	status := api.AgentConfigStatusUpdate{
 		ConfigId:       cfg.Id,
 		LastStatusDate: time.Now().UTC(),
@@ -3,6 +3,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -12,7 +13,7 @@ import (
 	"github.com/certkit-io/certkit-agent/utils"
 )
 
-func synchronizeRRASCertificate(cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
+func synchronizeRRASCertificate(ctx context.Context, cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
 	status := api.AgentConfigStatusUpdate{
 		ConfigId:       cfg.Id,
 		LastStatusDate: time.Now().UTC(),
@@ -48,7 +49,7 @@ func synchronizeRRASCertificate(cfg config.CertificateConfiguration, configChang
 
 	if needsFetch || retryFull {
 		log.Printf("Fetching new RRAS PFX for config %s and certificate %s", cfg.Id, cfg.CertificateId)
-		resp, err := api.FetchPfx(cfg.Id, cfg.CertificateId)
+		resp, err := api.FetchPfx(ctx, cfg.Id, cfg.CertificateId)
 		if err != nil {
 			status.Status = statusErrorGetCert
 			status.Message = fmt.Sprintf("Error fetching PFX: %v", err)
@@ -0,0 +1,465 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/metrics"
+	"github.com/certkit-io/certkit-agent/sftp"
+	"github.com/certkit-io/certkit-agent/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	configTypeSSH = "ssh"
+
+	defaultSSHTimeout = 60 * time.Second
+	// remoteTempSuffix is appended to each destination while it is written,
+	// so the file is only replaced once it is complete.
+	remoteTempSuffix = ".certkit-tmp"
+	// sshVerifyInterval is how often the remote certificate is read back
+	// when nothing new has to be deployed.
+	sshVerifyInterval = 6 * time.Hour
+)
+
+// sshDeployments remembers, per configuration, the certificate last found on
+// or written to the remote host, so polls with nothing new to deploy do not
+// log in to it.
+var sshDeployments = struct {
+	sync.Mutex
+	byConfig map[string]sshDeployment
+}{byConfig: make(map[string]sshDeployment)}
+
+type sshDeployment struct {
+	target   string
+	sha1     string
+	verified time.Time
+}
+
+// synchronizeSSH deploys to a host the agent cannot be installed on. The
+// certificate is fetched as usual and pushed over SFTP. The agent only
+// connects when there is a new certificate, the configuration changed, the
+// last sync failed, or sshVerifyInterval has passed since the certificate on
+// the remote host was last compared with LatestCertificateSha1.
+func synchronizeSSH(ctx context.Context, cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
+	status := api.AgentConfigStatusUpdate{
+		ConfigId:       cfg.Id,
+		LastStatusDate: time.Now().UTC(),
+	}
+	retryUpdateOnly := cfg.LastStatus == statusErrorUpdateCmd
+	retryFull := cfg.LastStatus == statusPendingSync ||
+		cfg.LastStatus == statusErrorGetCert ||
+		cfg.LastStatus == statusErrorWriteCert ||
+		cfg.LastStatus == statusErrorGeneral
+
+	if err := validateSSHConfig(cfg); err != nil {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error: %v", err)
+		return status
+	}
+	if cfg.Id == "" || cfg.CertificateId == "" {
+		log.Printf("Skipping SSH config with missing ids (config_id=%s, certificate_id=%s)", cfg.Id, cfg.CertificateId)
+		return api.AgentConfigStatusUpdate{}
+	}
+	target := cfg.SSH
+
+	if !configChanged && !retryUpdateOnly && !retryFull && sshDeploymentCurrent(cfg) {
+		log.Printf("Certificate on %s (config=%s) is up to date.", target.Host, cfg.Id)
+		status.Status = statusSynced
+		return status
+	}
+
+	conn, err := dialSSH(ctx, target)
+	if err != nil {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error connecting to %s: %v", target.Host, err)
+		return status
+	}
+	defer conn.Close()
+	// Closing the connection unblocks any request in flight when ctx ends.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error connecting to %s: %v", target.Host, err)
+		return status
+	}
+	defer client.Close()
+
+	deployedPem, needsFetch := remoteCertificate(client, cfg)
+	deployedSha1 := cfg.LatestCertificateSha1
+
+	if needsFetch || configChanged || retryFull {
+		log.Printf("Fetching new certificate for config %s and certificate %s", cfg.Id, cfg.CertificateId)
+		response, err := api.FetchCertificate(ctx, cfg.Id, cfg.CertificateId)
+		if err != nil {
+			status.Status = statusErrorGetCert
+			status.Message = fmt.Sprintf("Error fetching certificate: %v", err)
+			return status
+		}
+		if response == nil || response.CertificatePem == "" || response.KeyPem == "" {
+			status.Status = statusErrorGetCert
+			status.Message = "Error: no issued certificate returned"
+			return status
+		}
+		if err := ctx.Err(); err != nil {
+			status.Status = statusErrorGetCert
+			status.Message = fmt.Sprintf("Error: synchronization interrupted before writing certificate: %v", err)
+			return status
+		}
+
+		if err := writeRemoteCertificateFiles(ctx, conn, client, cfg, response); err != nil {
+			status.Status = statusErrorWriteCert
+			status.Message = fmt.Sprintf("Error writing certificate files to %s: %v", target.Host, err)
+			return status
+		}
+		deployedPem = []byte(response.CertificatePem)
+		deployedSha1, _ = utils.GetCertificateSha1FromPem(deployedPem)
+	}
+	recordDeployedCertificate(cfg.Id, deployedPem)
+
+	if needsFetch || configChanged || retryUpdateOnly || retryFull {
+		if strings.TrimSpace(cfg.UpdateCmd) != "" {
+			commandOutput, err := runRemoteUpdateCommand(ctx, conn, cfg)
+			if err != nil {
+				status.Status = statusErrorUpdateCmd
+				status.Message = fmt.Sprintf("Error running update command on %s: %v", target.Host, err)
+				return status
+			}
+			status.Message = fmt.Sprintf("Update command output: \n%s", commandOutput)
+		}
+	} else {
+		log.Printf("Certificate on %s (config=%s) is up to date.", target.Host, cfg.Id)
+	}
+
+	recordSSHDeployment(cfg, deployedSha1)
+	status.Status = statusSynced
+	return status
+}
+
+// sshDeploymentKey identifies where a configuration deploys to, so a cached
+// result does not outlive a change of host or paths.
+func sshDeploymentKey(cfg config.CertificateConfiguration) string {
+	return strings.Join(append([]string{cfg.SSH.Host, cfg.SSH.User}, remoteCertificateFilePaths(cfg)...), "\x00")
+}
+
+// sshDeploymentCurrent reports whether the latest certificate was deployed
+// or verified on the remote host within sshVerifyInterval.
+func sshDeploymentCurrent(cfg config.CertificateConfiguration) bool {
+	sshDeployments.Lock()
+	deployed, ok := sshDeployments.byConfig[cfg.Id]
+	sshDeployments.Unlock()
+	return ok && cfg.LatestCertificateSha1 != "" &&
+		deployed.target == sshDeploymentKey(cfg) &&
+		strings.EqualFold(deployed.sha1, cfg.LatestCertificateSha1) &&
+		time.Since(deployed.verified) < sshVerifyInterval
+}
+
+func recordSSHDeployment(cfg config.CertificateConfiguration, sha1 string) {
+	sshDeployments.Lock()
+	defer sshDeployments.Unlock()
+	if sha1 == "" {
+		delete(sshDeployments.byConfig, cfg.Id)
+		return
+	}
+	sshDeployments.byConfig[cfg.Id] = sshDeployment{target: sshDeploymentKey(cfg), sha1: sha1, verified: time.Now()}
+}
+
+func validateSSHConfig(cfg config.CertificateConfiguration) error {
+	target := cfg.SSH
+	switch {
+	case target == nil || strings.TrimSpace(target.Host) == "":
+		return errors.New("missing SSH host in configuration")
+	case strings.TrimSpace(target.User) == "" || strings.TrimSpace(target.IdentityFile) == "":
+		return errors.New("missing SSH user or identity file in configuration")
+	case strings.TrimSpace(target.HostKey) == "":
+		return errors.New("missing SSH host key in configuration; the host key must be pinned")
+	case cfg.IsPfx:
+		return errors.New("PFX files are not supported over SSH")
+	case cfg.UpdateAction != nil:
+		return errors.New("update actions are not supported over SSH; use update_cmd")
+	case cfg.PemDestination == "" || (!cfg.AllInOne && cfg.KeyDestination == ""):
+		return errors.New("missing destination path(s) in configuration")
+	}
+	return nil
+}
+
+func dialSSH(ctx context.Context, target *config.SSHTarget) (*ssh.Client, error) {
+	keyData, err := os.ReadFile(target.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("read identity file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("parse identity file: %w", err)
+	}
+	hostKeyCallback, err := pinnedHostKey(target.HostKey)
+	if err != nil {
+		return nil, err
+	}
+	timeout := defaultSSHTimeout
+	if target.TimeoutSeconds > 0 {
+		timeout = time.Duration(target.TimeoutSeconds) * time.Second
+	}
+
+	address := target.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), "22")
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	// The deadline covers the handshake; it is cleared once connected.
+	_ = netConn.SetDeadline(time.Now().Add(timeout))
+	sshConn, channels, requests, err := ssh.NewClientConn(netConn, address, &ssh.ClientConfig{
+		User:            target.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	_ = netConn.SetDeadline(time.Time{})
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+// pinnedHostKey accepts only the configured key, given as an authorized_keys
+// line or a SHA256 fingerprint.
+func pinnedHostKey(pinned string) (ssh.HostKeyCallback, error) {
+	pinned = strings.TrimSpace(pinned)
+	if strings.HasPrefix(pinned, "SHA256:") {
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != pinned {
+				return fmt.Errorf("host key %s does not match pinned %s", ssh.FingerprintSHA256(key), pinned)
+			}
+			return nil
+		}, nil
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+	if err != nil {
+		return nil, fmt.Errorf("parse host key: %w", err)
+	}
+	return func(_ string, _ net.Addr, presented ssh.PublicKey) error {
+		if !bytes.Equal(presented.Marshal(), key.Marshal()) {
+			return fmt.Errorf("host key %s does not match pinned %s", ssh.FingerprintSHA256(presented), ssh.FingerprintSHA256(key))
+		}
+		return nil
+	}, nil
+}
+
+// remoteCertificateFilePaths are the destinations written on the remote
+// host.
+func remoteCertificateFilePaths(cfg config.CertificateConfiguration) []string {
+	paths := []string{cfg.PemDestination}
+	if !cfg.AllInOne {
+		paths = append(paths, cfg.KeyDestination)
+		if chain := strings.TrimSpace(cfg.ChainDestination); chain != "" {
+			paths = append(paths, chain)
+		}
+	}
+	return paths
+}
+
+// remoteCertificate reads the certificate on the remote host and reports
+// whether the files must be written again: a destination is missing or the
+// certificate is not the latest one. Read errors force an update.
+func remoteCertificate(client *sftp.Client, cfg config.CertificateConfiguration) ([]byte, bool) {
+	data, err := client.ReadFile(cfg.PemDestination)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to read %s on %s: %v (forcing fetch)", cfg.PemDestination, cfg.SSH.Host, err)
+		}
+		return nil, true
+	}
+	if cfg.LatestCertificateSha1 == "" {
+		return data, true
+	}
+	for _, remotePath := range remoteCertificateFilePaths(cfg)[1:] {
+		if _, err := client.Stat(remotePath); err != nil {
+			return data, true
+		}
+	}
+	actual, err := utils.GetCertificateSha1FromPem(data)
+	if err != nil {
+		return data, true
+	}
+	return data, !strings.EqualFold(actual, cfg.LatestCertificateSha1)
+}
+
+// writeRemoteCertificateFiles writes every file to a temporary name, applies
+// ownership and mode, and only then renames them into place, so a failed
+// upload leaves the previous files untouched.
+func writeRemoteCertificateFiles(ctx context.Context, conn *ssh.Client, client *sftp.Client, cfg config.CertificateConfiguration, response *api.FetchCertificateResponse) error {
+	contents := make(map[string][]byte)
+	if cfg.AllInOne {
+		contents[cfg.PemDestination] = []byte(utils.MergeKeyAndCert(response.KeyPem, response.CertificatePem))
+	} else {
+		certPem := response.CertificatePem
+		if chain := strings.TrimSpace(cfg.ChainDestination); chain != "" {
+			leafPem, chainPem, err := splitLeafAndChain(response.CertificatePem)
+			if err != nil {
+				return fmt.Errorf("split certificate pem: %w", err)
+			}
+			certPem = leafPem
+			contents[chain] = []byte(chainPem)
+		}
+		contents[cfg.PemDestination] = []byte(certPem)
+		contents[cfg.KeyDestination] = []byte(response.KeyPem)
+	}
+
+	mode := os.FileMode(0o600)
+	if permValue := strings.TrimSpace(cfg.FilePermissions); permValue != "" {
+		parsed, err := parseFileMode(permValue)
+		if err != nil {
+			return err
+		}
+		mode = parsed
+	}
+
+	paths := remoteCertificateFilePaths(cfg)
+	temps := make([]string, 0, len(paths))
+	cleanup := func() {
+		for _, temp := range temps {
+			_ = client.Remove(temp)
+		}
+	}
+	for _, remotePath := range paths {
+		if err := client.MkdirAll(path.Dir(remotePath), 0o755); err != nil {
+			cleanup()
+			return fmt.Errorf("create %s: %w", path.Dir(remotePath), err)
+		}
+		temp := remotePath + remoteTempSuffix
+		log.Printf("Writing %s on %s", remotePath, cfg.SSH.Host)
+		if err := client.WriteFile(temp, contents[remotePath], 0o600); err != nil {
+			cleanup()
+			return fmt.Errorf("write %s: %w", temp, err)
+		}
+		temps = append(temps, temp)
+		// The server's umask applies at creation, so the mode is set
+		// explicitly.
+		if err := client.Chmod(temp, mode); err != nil {
+			cleanup()
+			return fmt.Errorf("chmod %s: %w", temp, err)
+		}
+	}
+
+	if owner := remoteOwner(cfg); owner != "" {
+		args := []string{"chown", shellQuote(owner), "--"}
+		for _, temp := range temps {
+			args = append(args, shellQuote(temp))
+		}
+		if output, _, err := runRemoteCommand(ctx, conn, strings.Join(args, " ")); err != nil {
+			cleanup()
+			return fmt.Errorf("chown: %w: %s", err, strings.TrimSpace(output))
+		}
+	}
+
+	for i, remotePath := range paths {
+		if err := client.Rename(temps[i], remotePath); err != nil {
+			cleanup()
+			return fmt.Errorf("rename %s: %w", remotePath, err)
+		}
+	}
+	return nil
+}
+
+// remoteOwner is the chown argument for the configured owner and group, or
+// "" to leave ownership to the login user. Names are resolved on the remote
+// host.
+func remoteOwner(cfg config.CertificateConfiguration) string {
+	ownerUser := strings.TrimSpace(cfg.OwnerUser)
+	ownerGroup := strings.TrimSpace(cfg.OwnerGroup)
+	switch {
+	case ownerUser != "" && ownerGroup != "":
+		return ownerUser + ":" + ownerGroup
+	case ownerGroup != "":
+		return ":" + ownerGroup
+	}
+	return ownerUser
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// runRemoteCommand runs command in a new session and returns its combined
+// output and exit status.
+func runRemoteCommand(ctx context.Context, conn *ssh.Client, command string) (string, int, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", -1, err
+	}
+	defer session.Close()
+
+	var output commandOutput
+	session.Stdout = &output
+	session.Stderr = &output
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+
+	select {
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		session.Close()
+		return "", -1, ctx.Err()
+	case err := <-done:
+		if err == nil {
+			return output.String(), 0, nil
+		}
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return output.String(), exitErr.ExitStatus(), err
+		}
+		return output.String(), -1, err
+	}
+}
+
+// commandOutput collects stdout and stderr, which the session copies from
+// separate goroutines.
+type commandOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *commandOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+func runRemoteUpdateCommand(ctx context.Context, conn *ssh.Client, cfg config.CertificateConfiguration) (string, error) {
+	log.Printf("Running update command on %s: '%s'", cfg.SSH.Host, cfg.UpdateCmd)
+	started := time.Now()
+	output, exitCode, err := runRemoteCommand(ctx, conn, cfg.UpdateCmd)
+	metrics.ObserveUpdateCommand(cfg.Id, time.Since(started), exitCode)
+	if len(output) > 0 {
+		log.Printf("Update command output for '%s':\n%s", cfg.UpdateCmd, output)
+	}
+	if err != nil {
+		return output, fmt.Errorf("Update command failed: \n%w\n%s", err, output)
+	}
+	return output, nil
+}
@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/sftp/sftptest"
+	"github.com/certkit-io/certkit-agent/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer starts an in-process SSH server and returns it with the
+// path of an identity file it accepts.
+func startSSHServer(t *testing.T) (*sftptest.Server, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "certkit-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(identityFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := sftptest.NewServer(signer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, identityFile
+}
+
+func TestSynchronizeSSH(t *testing.T) {
+	certPem, keyPem, sha1 := testCertificate(t, "appliance.example.com")
+	fetches := fakeCertkitAPI(t, certPem, keyPem)
+	server, identityFile := startSSHServer(t)
+	remote := filepath.Join(t.TempDir(), "etc", "appliance")
+	marker := filepath.Join(t.TempDir(), "reloaded")
+
+	cfg := config.CertificateConfiguration{
+		Id:                    "cfg1",
+		CertificateId:         "cert1",
+		ConfigType:            configTypeSSH,
+		LatestCertificateSha1: sha1,
+		PemDestination:        filepath.Join(remote, "cert.pem"),
+		KeyDestination:        filepath.Join(remote, "key.pem"),
+		ChainDestination:      filepath.Join(remote, "chain.pem"),
+		OwnerUser:             strconv.Itoa(os.Getuid()),
+		FilePermissions:       "0640",
+		UpdateCmd:             "echo reloaded && touch " + marker,
+		SSH: &config.SSHTarget{
+			Host:         server.Addr,
+			User:         "deploy",
+			IdentityFile: identityFile,
+			HostKey:      string(ssh.MarshalAuthorizedKey(server.HostKey)),
+		},
+	}
+
+	status := synchronizeCertificate(context.Background(), cfg, false)
+	if status.Status != statusSynced || !strings.Contains(status.Message, "reloaded") {
+		t.Fatalf("first sync = %+v", status)
+	}
+	leaf, chain, err := splitLeafAndChain(certPem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{cfg.PemDestination: leaf, cfg.KeyDestination: keyPem, cfg.ChainDestination: chain} {
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", path, data, err)
+			continue
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+			t.Errorf("%s mode = %v", path, info.Mode())
+		}
+		if _, err := os.Stat(path + remoteTempSuffix); !os.IsNotExist(err) {
+			t.Errorf("temporary file for %s left behind", path)
+		}
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("update command did not run: %v", err)
+	}
+	commands := server.Commands()
+	if len(commands) != 2 || !strings.HasPrefix(commands[0], "chown '"+cfg.OwnerUser+"' -- ") || commands[1] != cfg.UpdateCmd {
+		t.Errorf("commands = %q", commands)
+	}
+
+	if want, _ := utils.GetCertificateNotAfterFromPem([]byte(certPem)); !deployedExpiryIs(cfg, want) {
+		t.Errorf("deployed expiry not recorded")
+	}
+
+	// Nothing new to deploy: the host is not contacted.
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("second sync = %+v", status)
+	}
+	if server.Logins() != 1 || fetches.Load() != 1 {
+		t.Errorf("logins = %d, fetches = %d", server.Logins(), fetches.Load())
+	}
+
+	// Once the verify interval has passed the remote certificate is read
+	// back; it is current. The fingerprint form of the pinned key works too.
+	sshDeployments.Lock()
+	deployed := sshDeployments.byConfig[cfg.Id]
+	deployed.verified = deployed.verified.Add(-sshVerifyInterval)
+	sshDeployments.byConfig[cfg.Id] = deployed
+	sshDeployments.Unlock()
+	cfg.SSH.HostKey = ssh.FingerprintSHA256(server.HostKey)
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("third sync = %+v", status)
+	}
+	if server.Logins() != 2 || fetches.Load() != 1 || len(server.Commands()) != 2 {
+		t.Errorf("logins = %d, fetches = %d, commands = %q", server.Logins(), fetches.Load(), server.Commands())
+	}
+
+	// A failing update command is reported under the same config.
+	cfg.UpdateCmd = "echo broken >&2; exit 3"
+	cfg.LastStatus = statusErrorUpdateCmd
+	status = synchronizeCertificate(context.Background(), cfg, false)
+	if status.ConfigId != "cfg1" || status.Status != statusErrorUpdateCmd || !strings.Contains(status.Message, "broken") {
+		t.Errorf("failing update = %+v", status)
+	}
+}
+
+func TestSynchronizeSSHRejectsUnpinnedHostKey(t *testing.T) {
+	certPem, keyPem, sha1 := testCertificate(t, "appliance.example.com")
+	fetches := fakeCertkitAPI(t, certPem, keyPem)
+	server, identityFile := startSSHServer(t)
+	other, _ := startSSHServer(t)
+	remote := t.TempDir()
+
+	cfg := config.CertificateConfiguration{
+		Id:                    "cfg1",
+		CertificateId:         "cert1",
+		ConfigType:            configTypeSSH,
+		LatestCertificateSha1: sha1,
+		PemDestination:        filepath.Join(remote, "cert.pem"),
+		KeyDestination:        filepath.Join(remote, "key.pem"),
+		SSH: &config.SSHTarget{
+			Host:         server.Addr,
+			User:         "deploy",
+			IdentityFile: identityFile,
+			HostKey:      string(ssh.MarshalAuthorizedKey(other.HostKey)),
+		},
+	}
+	status := synchronizeCertificate(context.Background(), cfg, false)
+	if status.Status != statusErrorGeneral || !strings.Contains(status.Message, "does not match pinned") {
+		t.Errorf("status = %+v", status)
+	}
+
+	cfg.SSH.HostKey = ""
+	status = synchronizeCertificate(context.Background(), cfg, false)
+	if status.Status != statusErrorGeneral || !strings.Contains(status.Message, "must be pinned") {
+		t.Errorf("status = %+v", status)
+	}
+	if fetches.Load() != 0 {
+		t.Errorf("fetches = %d", fetches.Load())
+	}
+	if _, err := os.Stat(cfg.PemDestination); !os.IsNotExist(err) {
+		t.Errorf("certificate written despite host key mismatch: %v", err)
+	}
+}
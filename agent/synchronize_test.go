@@ -1,6 +1,29 @@
 package agent
 
-import "testing"
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	agentCrypto "github.com/certkit-io/certkit-agent/crypto"
+	"github.com/certkit-io/certkit-agent/utils"
+)
 
 func TestParseFileMode(t *testing.T) {
 	tests := []struct {
@@ -44,3 +67,97 @@ func TestParseFileMode(t *testing.T) {
 		})
 	}
 }
+
+// testCertificate returns a self-signed leaf PEM with a second certificate
+// as its chain, the key PEM, and the leaf's SHA-1.
+func testCertificate(t *testing.T, name string) (string, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certPem string
+	for _, commonName := range []string{name, "Test CA"} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      pkix.Name{CommonName: commonName},
+			DNSNames:     []string{commonName},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPem += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPem := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}))
+	sha1, err := utils.GetCertificateSha1FromPem([]byte(certPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPem, keyPem, sha1
+}
+
+// deployedExpiryIs reports whether status shows want as the expiry of the
+// certificate deployed for cfg.
+func deployedExpiryIs(cfg config.CertificateConfiguration, want time.Time) bool {
+	notAfter, ok := deployedCertificateNotAfter(cfg)
+	return ok && !want.IsZero() && notAfter.Equal(want)
+}
+
+// fakeCertkitAPI points the agent at a stand-in CertKit API that answers
+// fetch-certificate with certPem and keyPem, and returns a counter of
+// fetches.
+func fakeCertkitAPI(t *testing.T, certPem string, keyPem string) *atomic.Int32 {
+	t.Helper()
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/fetch-certificate") {
+			http.NotFound(w, r)
+			return
+		}
+		fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(api.FetchCertificateResponse{CertificatePem: certPem, KeyPem: keyPem})
+	}))
+	t.Cleanup(server.Close)
+
+	saved := config.CurrentConfig
+	t.Cleanup(func() { config.CurrentConfig = saved })
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+	return &fetches
+}
+
+func TestSynchronizeCertificatesStopsWhenCancelled(t *testing.T) {
+	saved := config.CurrentConfig
+	t.Cleanup(func() { config.CurrentConfig = saved })
+	dir := t.TempDir()
+	config.CurrentConfig.CertificateConfigurations = []config.CertificateConfiguration{
+		{
+			Id:             "cfg1",
+			CertificateId:  "cert1",
+			PemDestination: filepath.Join(dir, "cert.pem"),
+			KeyDestination: filepath.Join(dir, "key.pem"),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	statuses := SynchronizeCertificates(ctx, false)
+	if len(statuses) != 0 {
+		t.Errorf("statuses = %+v, want none", statuses)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cert.pem")); !os.IsNotExist(err) {
+		t.Errorf("certificate written after cancellation: %v", err)
+	}
+}
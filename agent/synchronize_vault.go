@@ -0,0 +1,361 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/utils"
+	"github.com/certkit-io/certkit-agent/vaultapi"
+)
+
+const (
+	configTypeVaultKV = "vault-kv"
+
+	vaultAuthToken      = "token"
+	vaultAuthAppRole    = "approle"
+	vaultAuthKubernetes = "kubernetes"
+
+	defaultVaultMount            = "secret"
+	defaultVaultCertificateField = "certificate"
+	defaultVaultKeyField         = "private_key"
+	defaultVaultChainField       = "ca_chain"
+	defaultVaultKubernetesJwt    = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// vaultTokenMargin is how long before expiry a cached login token is
+	// replaced.
+	vaultTokenMargin = time.Minute
+)
+
+// vaultTokens caches AppRole and Kubernetes login tokens between polls so
+// the agent does not log in every time it checks a secret.
+var vaultTokens = struct {
+	sync.Mutex
+	byKey map[string]vaultToken
+}{byKey: make(map[string]vaultToken)}
+
+type vaultToken struct {
+	token   string
+	expires time.Time
+}
+
+// vaultFields are the KV field names a configuration writes to.
+type vaultFields struct {
+	certificate string
+	key         string
+	chain       string
+}
+
+func vaultFieldsFor(target *config.VaultTarget) vaultFields {
+	fields := vaultFields{
+		certificate: target.CertificateField,
+		key:         target.KeyField,
+		chain:       target.ChainField,
+	}
+	if fields.certificate == "" {
+		fields.certificate = defaultVaultCertificateField
+	}
+	if fields.key == "" {
+		fields.key = defaultVaultKeyField
+	}
+	if fields.chain == "" {
+		fields.chain = defaultVaultChainField
+	}
+	return fields
+}
+
+// synchronizeVaultKV keeps a Vault KV v2 secret in sync. The certificate
+// stored in the secret is compared with LatestCertificateSha1, so a secret
+// changed by someone else is put back on the next poll.
+func synchronizeVaultKV(ctx context.Context, cfg config.CertificateConfiguration, configChanged bool) api.AgentConfigStatusUpdate {
+	status := api.AgentConfigStatusUpdate{
+		ConfigId:       cfg.Id,
+		LastStatusDate: time.Now().UTC(),
+	}
+	retryUpdateOnly := cfg.LastStatus == statusErrorUpdateCmd
+	retryFull := cfg.LastStatus == statusPendingSync ||
+		cfg.LastStatus == statusErrorGetCert ||
+		cfg.LastStatus == statusErrorWriteCert ||
+		cfg.LastStatus == statusErrorGeneral
+
+	target := cfg.Vault
+	if target == nil || strings.Trim(target.Path, "/ ") == "" {
+		status.Status = statusErrorGeneral
+		status.Message = "Error: missing Vault path in configuration"
+		return status
+	}
+	if cfg.Id == "" || cfg.CertificateId == "" {
+		log.Printf("Skipping Vault config with missing ids (config_id=%s, certificate_id=%s)", cfg.Id, cfg.CertificateId)
+		return api.AgentConfigStatusUpdate{}
+	}
+
+	client, err := vaultClient(ctx, target)
+	if err != nil {
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error connecting to Vault: %v", err)
+		return status
+	}
+	mount := target.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	fields := vaultFieldsFor(target)
+
+	existing, err := client.ReadKV(ctx, mount, target.Path)
+	if err != nil && !vaultapi.IsNotFound(err) {
+		if vaultapi.IsPermissionDenied(err) {
+			forgetVaultToken(target)
+		}
+		status.Status = statusErrorGeneral
+		status.Message = fmt.Sprintf("Error reading Vault secret %s/%s: %v", mount, target.Path, err)
+		return status
+	}
+	needsFetch := vaultSecretNeedsUpdate(existing, fields, cfg.LatestCertificateSha1)
+	var deployedPem string
+	if existing != nil {
+		deployedPem, _ = existing.Data[fields.certificate].(string)
+	}
+
+	if needsFetch || configChanged || retryFull {
+		log.Printf("Fetching new certificate for config %s and certificate %s", cfg.Id, cfg.CertificateId)
+		response, err := api.FetchCertificate(ctx, cfg.Id, cfg.CertificateId)
+		if err != nil {
+			status.Status = statusErrorGetCert
+			status.Message = fmt.Sprintf("Error fetching certificate: %v", err)
+			return status
+		}
+		if response == nil || response.CertificatePem == "" || response.KeyPem == "" {
+			status.Status = statusErrorGetCert
+			status.Message = "Error: no issued certificate returned"
+			return status
+		}
+		if err := ctx.Err(); err != nil {
+			status.Status = statusErrorGetCert
+			status.Message = fmt.Sprintf("Error: synchronization interrupted before writing Vault secret: %v", err)
+			return status
+		}
+
+		if err := writeVaultSecret(ctx, client, mount, target.Path, fields, existing, response); err != nil {
+			status.Status = statusErrorWriteCert
+			status.Message = fmt.Sprintf("Error writing Vault secret %s/%s: %v", mount, target.Path, err)
+			return status
+		}
+		deployedPem = response.CertificatePem
+	}
+	recordDeployedCertificate(cfg.Id, []byte(deployedPem))
+
+	if needsFetch || configChanged || retryUpdateOnly || retryFull {
+		if hasUpdate(cfg) {
+			commandOutput, err := runUpdate(ctx, cfg)
+			if err != nil {
+				status.Status = statusErrorUpdateCmd
+				status.Message = fmt.Sprintf("Error running update command: %v", err)
+				return status
+			}
+			status.Message = fmt.Sprintf("Update command output: \n%s", commandOutput)
+		}
+	} else {
+		log.Printf("Vault secret %s/%s (config=%s) is up to date.", mount, target.Path, cfg.Id)
+	}
+
+	status.Status = statusSynced
+	return status
+}
+
+// vaultClient connects to the target's Vault server and logs in.
+func vaultClient(ctx context.Context, target *config.VaultTarget) (*vaultapi.Client, error) {
+	address := target.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	caPath := target.CACert
+	if caPath == "" {
+		caPath = os.Getenv("VAULT_CACERT")
+	}
+	vaultConfig := vaultapi.Config{Address: address, Namespace: target.Namespace}
+	if caPath != "" {
+		caData, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate: %w", err)
+		}
+		vaultConfig.CAData = caData
+	}
+	client, err := vaultapi.NewClient(vaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := vaultLogin(ctx, client, target); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func vaultLogin(ctx context.Context, client *vaultapi.Client, target *config.VaultTarget) error {
+	auth := target.Auth
+	method := strings.ToLower(strings.TrimSpace(auth.Method))
+	if method == vaultAuthToken {
+		token, err := vaultTokenFromFile(auth.TokenFile)
+		if err != nil {
+			return err
+		}
+		client.SetToken(token)
+		return nil
+	}
+
+	key := vaultTokenKey(target)
+	vaultTokens.Lock()
+	cached, ok := vaultTokens.byKey[key]
+	vaultTokens.Unlock()
+	if ok && (cached.expires.IsZero() || time.Until(cached.expires) > vaultTokenMargin) {
+		client.SetToken(cached.token)
+		return nil
+	}
+
+	var login *vaultapi.Auth
+	switch method {
+	case vaultAuthAppRole:
+		if auth.RoleId == "" {
+			return errors.New("approle auth requires role_id")
+		}
+		secretId := ""
+		if auth.SecretIdFile != "" {
+			data, err := os.ReadFile(auth.SecretIdFile)
+			if err != nil {
+				return fmt.Errorf("read secret id: %w", err)
+			}
+			secretId = strings.TrimSpace(string(data))
+		}
+		var err error
+		login, err = client.LoginAppRole(ctx, vaultAuthMount(auth, vaultAuthAppRole), auth.RoleId, secretId)
+		if err != nil {
+			return fmt.Errorf("approle login: %w", err)
+		}
+	case vaultAuthKubernetes:
+		if auth.Role == "" {
+			return errors.New("kubernetes auth requires role")
+		}
+		jwtFile := auth.JwtFile
+		if jwtFile == "" {
+			jwtFile = defaultVaultKubernetesJwt
+		}
+		jwt, err := os.ReadFile(jwtFile)
+		if err != nil {
+			return fmt.Errorf("read service account token: %w", err)
+		}
+		login, err = client.LoginKubernetes(ctx, vaultAuthMount(auth, vaultAuthKubernetes), auth.Role, strings.TrimSpace(string(jwt)))
+		if err != nil {
+			return fmt.Errorf("kubernetes login: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported Vault auth method %q", auth.Method)
+	}
+
+	token := vaultToken{token: login.ClientToken}
+	if ttl := login.TTL(); ttl > 0 {
+		token.expires = time.Now().Add(ttl)
+	}
+	vaultTokens.Lock()
+	vaultTokens.byKey[key] = token
+	vaultTokens.Unlock()
+	return nil
+}
+
+// vaultTokenFromFile reads a token from path, or from $VAULT_TOKEN or
+// ~/.vault-token when path is empty.
+func vaultTokenFromFile(path string) (string, error) {
+	if path == "" {
+		if token := strings.TrimSpace(os.Getenv("VAULT_TOKEN")); token != "" {
+			return token, nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.New("no Vault token file configured")
+		}
+		path = filepath.Join(home, ".vault-token")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func vaultAuthMount(auth config.VaultAuth, method string) string {
+	if auth.Mount != "" {
+		return auth.Mount
+	}
+	return method
+}
+
+// vaultTokenKey identifies the login a cached token came from.
+func vaultTokenKey(target *config.VaultTarget) string {
+	auth := target.Auth
+	return strings.Join([]string{target.Address, target.Namespace, strings.ToLower(auth.Method), auth.Mount, auth.RoleId, auth.SecretIdFile, auth.Role, auth.JwtFile}, "\x00")
+}
+
+// forgetVaultToken drops a cached token Vault no longer accepts, so the next
+// poll logs in again.
+func forgetVaultToken(target *config.VaultTarget) {
+	vaultTokens.Lock()
+	delete(vaultTokens.byKey, vaultTokenKey(target))
+	vaultTokens.Unlock()
+}
+
+// vaultSecretNeedsUpdate reports whether the secret is missing, lacks the
+// key, or holds a certificate other than the latest one.
+func vaultSecretNeedsUpdate(secret *vaultapi.KVSecret, fields vaultFields, latestSha1 string) bool {
+	if secret == nil || latestSha1 == "" {
+		return true
+	}
+	if key, _ := secret.Data[fields.key].(string); key == "" {
+		return true
+	}
+	certPem, _ := secret.Data[fields.certificate].(string)
+	actual, err := utils.GetCertificateSha1FromPem([]byte(certPem))
+	if err != nil {
+		return true
+	}
+	return !strings.EqualFold(actual, latestSha1)
+}
+
+// writeVaultSecret writes a new version of the secret, keeping fields the
+// agent does not manage. The write is check-and-set against the version that
+// was read, so a concurrent change is not overwritten.
+func writeVaultSecret(ctx context.Context, client *vaultapi.Client, mount string, path string, fields vaultFields, existing *vaultapi.KVSecret, response *api.FetchCertificateResponse) error {
+	leafPem, chainPem, err := splitLeafAndChain(response.CertificatePem)
+	if err != nil {
+		return fmt.Errorf("split certificate pem: %w", err)
+	}
+
+	data := make(map[string]any)
+	version := 0
+	if existing != nil {
+		for key, value := range existing.Data {
+			data[key] = value
+		}
+		version = existing.Version
+	}
+	data[fields.certificate] = leafPem
+	data[fields.key] = response.KeyPem
+	if chainPem != "" {
+		data[fields.chain] = chainPem
+	} else {
+		delete(data, fields.chain)
+	}
+
+	log.Printf("Writing Vault secret %s/%s", mount, path)
+	_, err = client.WriteKV(ctx, mount, path, data, &version)
+	return err
+}
@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+// fakeVault serves AppRole and Kubernetes logins and the KV v2 data
+// endpoints of the engine mounted at "secret". A path with a version but no
+// secret was soft-deleted.
+type fakeVault struct {
+	mu       sync.Mutex
+	secrets  map[string]map[string]any
+	versions map[string]int
+	logins   []map[string]string
+	writes   int
+}
+
+func startFakeVault(t *testing.T) (*fakeVault, string) {
+	t.Helper()
+	fake := &fakeVault{secrets: make(map[string]map[string]any), versions: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func (f *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fail := func(code int, message string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {message}})
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v1/auth/") && strings.HasSuffix(r.URL.Path, "/login") {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		body["mount"] = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/auth/"), "/login")
+		f.logins = append(f.logins, body)
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": "s.agent", "lease_duration": 3600}})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != "s.agent" {
+		fail(http.StatusForbidden, "permission denied")
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/v1/secret/data/")
+	if !ok {
+		fail(http.StatusNotFound, "no handler for route")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := f.secrets[path]
+		if !ok && f.versions[path] > 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data":     nil,
+				"metadata": map[string]any{"version": f.versions[path], "deletion_time": "2026-01-01T00:00:00Z"},
+			}})
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"data":     data,
+			"metadata": map[string]any{"version": f.versions[path]},
+		}})
+	case http.MethodPost:
+		var body struct {
+			Data    map[string]any `json:"data"`
+			Options struct {
+				Cas *int `json:"cas"`
+			} `json:"options"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Options.Cas != nil && *body.Options.Cas != f.versions[path] {
+			fail(http.StatusBadRequest, "check-and-set parameter did not match the current version")
+			return
+		}
+		f.writes++
+		f.versions[path]++
+		f.secrets[path] = body.Data
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"version": f.versions[path]}})
+	}
+}
+
+func TestSynchronizeVaultKV(t *testing.T) {
+	certPem, keyPem, sha1 := testCertificate(t, "web.example.com")
+	fetches := fakeCertkitAPI(t, certPem, keyPem)
+	fake, address := startFakeVault(t)
+	secretIdFile := filepath.Join(t.TempDir(), "secret-id")
+	if err := os.WriteFile(secretIdFile, []byte("sid\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.secrets["apps/web/tls"] = map[string]any{"owner": "web-team", "tls_cert": "old"}
+	fake.versions["apps/web/tls"] = 3
+
+	cfg := config.CertificateConfiguration{
+		Id:                    "cfg1",
+		CertificateId:         "cert1",
+		ConfigType:            configTypeVaultKV,
+		LatestCertificateSha1: sha1,
+		Vault: &config.VaultTarget{
+			Address:          address,
+			Path:             "apps/web/tls",
+			CertificateField: "tls_cert",
+			KeyField:         "tls_key",
+			Auth:             config.VaultAuth{Method: "approle", RoleId: "rid", SecretIdFile: secretIdFile},
+		},
+	}
+
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("first sync = %+v", status)
+	}
+	secret := fake.secrets["apps/web/tls"]
+	leaf, _, _ := strings.Cut(certPem, "-----END CERTIFICATE-----\n")
+	leaf += "-----END CERTIFICATE-----\n"
+	if secret["tls_cert"] != leaf || secret["tls_key"] != keyPem || secret["owner"] != "web-team" {
+		t.Fatalf("secret = %v", secret)
+	}
+	if chain, _ := secret["ca_chain"].(string); chain == "" || !strings.Contains(certPem, chain) {
+		t.Errorf("ca_chain = %q", secret["ca_chain"])
+	}
+	if want, _ := utils.GetCertificateNotAfterFromPem([]byte(certPem)); !deployedExpiryIs(cfg, want) {
+		t.Errorf("deployed expiry not recorded")
+	}
+	if len(fake.logins) != 1 || fake.logins[0]["role_id"] != "rid" || fake.logins[0]["secret_id"] != "sid" || fake.logins[0]["mount"] != "approle" {
+		t.Errorf("logins = %v", fake.logins)
+	}
+
+	// The stored certificate is the latest one: nothing is fetched or
+	// written, and the cached token is reused.
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("second sync = %+v", status)
+	}
+	if fetches.Load() != 1 || fake.writes != 1 || len(fake.logins) != 1 {
+		t.Errorf("fetches = %d, writes = %d, logins = %d", fetches.Load(), fake.writes, len(fake.logins))
+	}
+
+	// Someone replaced the certificate; it is fetched and written back.
+	secret["tls_cert"] = "stale"
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("third sync = %+v", status)
+	}
+	if fetches.Load() != 2 || fake.secrets["apps/web/tls"]["tls_cert"] != leaf || fake.versions["apps/web/tls"] != 5 {
+		t.Errorf("fetches = %d, secret = %v, version = %d", fetches.Load(), fake.secrets["apps/web/tls"], fake.versions["apps/web/tls"])
+	}
+
+	// "vault kv delete" keeps the version history; the secret is restored
+	// check-and-set against the deleted version.
+	delete(fake.secrets, "apps/web/tls")
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("sync after delete = %+v", status)
+	}
+	if fake.secrets["apps/web/tls"]["tls_cert"] != leaf || fake.versions["apps/web/tls"] != 6 {
+		t.Errorf("secret = %v, version = %d", fake.secrets["apps/web/tls"], fake.versions["apps/web/tls"])
+	}
+}
+
+func TestSynchronizeVaultKVKubernetesAuth(t *testing.T) {
+	certPem, keyPem, sha1 := testCertificate(t, "api.example.com")
+	fakeCertkitAPI(t, certPem, keyPem)
+	fake, address := startFakeVault(t)
+	jwtFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwtFile, []byte("jwt-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.CertificateConfiguration{
+		Id:                    "cfg2",
+		CertificateId:         "cert2",
+		ConfigType:            configTypeVaultKV,
+		LatestCertificateSha1: sha1,
+		Vault: &config.VaultTarget{
+			Address: address,
+			Path:    "api",
+			Auth:    config.VaultAuth{Method: "kubernetes", Mount: "k8s-prod", Role: "certkit", JwtFile: jwtFile},
+		},
+	}
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusSynced {
+		t.Fatalf("sync = %+v", status)
+	}
+	if len(fake.logins) != 1 || fake.logins[0]["jwt"] != "jwt-token" || fake.logins[0]["role"] != "certkit" || fake.logins[0]["mount"] != "k8s-prod" {
+		t.Errorf("logins = %v", fake.logins)
+	}
+	if secret := fake.secrets["api"]; secret[defaultVaultCertificateField] == nil || secret[defaultVaultKeyField] != keyPem {
+		t.Errorf("secret = %v", secret)
+	}
+
+	// An unusable token file is reported without fetching anything.
+	cfg.Vault.Auth = config.VaultAuth{Method: "token", TokenFile: filepath.Join(t.TempDir(), "missing")}
+	if status := synchronizeCertificate(context.Background(), cfg, false); status.Status != statusErrorGeneral || !strings.Contains(status.Message, "read token") {
+		t.Errorf("status = %+v", status)
+	}
+}
@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+const (
+	caddyDefaultAdmin = "http://localhost:2019"
+	caddyTimeout      = 30 * time.Second
+	caddyLoadPemPath  = "apps/tls/certificates/load_pem"
+)
+
+// deployCaddy loads the written certificate into Caddy's running config as a
+// load_pem entry tagged with an @id, so later renewals replace it in place.
+func deployCaddy(ctx context.Context, cfg config.CertificateConfiguration) (string, error) {
+	certPem, keyPem, err := readDeployedPem(cfg)
+	if err != nil {
+		return "", err
+	}
+	admin := caddyAdminFor(cfg)
+	ctx, cancel := context.WithTimeout(ctx, caddyTimeout)
+	defer cancel()
+
+	id := caddyCertificateId(cfg)
+	entry := map[string]any{
+		"@id":         id,
+		"certificate": certPem,
+		"key":         keyPem,
+		"tags":        []string{"certkit", cfg.Id},
+	}
+
+	err = admin.request(ctx, http.MethodPatch, "/id/"+id, entry, nil)
+	if err == nil {
+		log.Printf("Replaced certificate %s in Caddy", id)
+		return fmt.Sprintf("Replaced certificate %s in Caddy\n", id), nil
+	}
+	if !isCaddyNotFound(err) {
+		return "", err
+	}
+
+	if err := admin.add(ctx, caddyLoadPemPath, entry); err != nil {
+		return "", err
+	}
+	log.Printf("Loaded certificate %s into Caddy", id)
+	return fmt.Sprintf("Loaded certificate %s into Caddy\n", id), nil
+}
+
+// verifyCaddy loads the certificate again if Caddy no longer has it. Config
+// added through the admin API is lost when Caddy restarts or reloads its
+// Caddyfile.
+func verifyCaddy(ctx context.Context, cfg config.CertificateConfiguration) (string, error) {
+	id := caddyCertificateId(cfg)
+	checkCtx, cancel := context.WithTimeout(ctx, caddyTimeout)
+	err := caddyAdminFor(cfg).request(checkCtx, http.MethodGet, "/id/"+id, nil, nil)
+	cancel()
+	if err == nil {
+		return "", nil
+	}
+	if !isCaddyNotFound(err) {
+		return "", err
+	}
+	log.Printf("Certificate %s is missing from Caddy's config; loading it again", id)
+	return deployCaddy(ctx, cfg)
+}
+
+func caddyCertificateId(cfg config.CertificateConfiguration) string {
+	return "certkit-" + cfg.Id
+}
+
+func caddyAdminFor(cfg config.CertificateConfiguration) *caddyAdmin {
+	if cfg.Caddy != nil {
+		return newCaddyAdmin(cfg.Caddy.AdminAddress)
+	}
+	return newCaddyAdmin("")
+}
+
+type caddyAdmin struct {
+	baseURL string
+	http    *http.Client
+}
+
+func newCaddyAdmin(address string) *caddyAdmin {
+	if address == "" {
+		address = caddyDefaultAdmin
+	}
+	client := &http.Client{Timeout: caddyTimeout}
+	if socket, ok := strings.CutPrefix(address, "unix/"); ok {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		address = "http://localhost"
+	} else if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &caddyAdmin{baseURL: strings.TrimSuffix(address, "/"), http: client}
+}
+
+// caddyError is a non-2xx response from the admin API.
+type caddyError struct {
+	statusCode int
+	message    string
+}
+
+func (e *caddyError) Error() string {
+	return fmt.Sprintf("caddy admin api: status=%d: %s", e.statusCode, e.message)
+}
+
+// isCaddyNotFound reports whether err means the @id or config path does not
+// exist. Caddy answers unknown ids with 404, and traversals through missing
+// objects with a 400 naming the path; any other 400 is a real rejection.
+func isCaddyNotFound(err error) bool {
+	var caddyErr *caddyError
+	if !errors.As(err, &caddyErr) {
+		return false
+	}
+	switch caddyErr.statusCode {
+	case http.StatusNotFound:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(caddyErr.message, "unknown object ID") ||
+			strings.Contains(caddyErr.message, "invalid traversal path")
+	}
+	return false
+}
+
+// add appends entry to the array at path, creating the array and any
+// missing objects above it.
+func (a *caddyAdmin) add(ctx context.Context, path string, entry any) error {
+	var existing json.RawMessage
+	if err := a.request(ctx, http.MethodGet, "/config/"+path, nil, &existing); err != nil && !isCaddyNotFound(err) {
+		return err
+	}
+	if len(existing) > 0 && string(bytes.TrimSpace(existing)) != "null" {
+		return a.request(ctx, http.MethodPost, "/config/"+path, entry, nil)
+	}
+
+	// Walk up until an existing parent is found, nesting the value as we go.
+	var value any = []any{entry}
+	for {
+		slash := strings.LastIndex(path, "/")
+		parent, key := "", path
+		if slash >= 0 {
+			parent, key = path[:slash], path[slash+1:]
+		}
+		var current json.RawMessage
+		err := a.request(ctx, http.MethodGet, "/config/"+parent, nil, &current)
+		if err != nil && !isCaddyNotFound(err) {
+			return err
+		}
+		if parent == "" || (err == nil && string(bytes.TrimSpace(current)) != "null") {
+			return a.request(ctx, http.MethodPost, "/config/"+path, value, nil)
+		}
+		value = map[string]any{key: value}
+		path = parent
+	}
+}
+
+func (a *caddyAdmin) request(ctx context.Context, method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			message = apiErr.Error
+		}
+		return &caddyError{statusCode: resp.StatusCode, message: message}
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const traefikDynamicDir = "/etc/traefik/dynamic"
+
+// deployTraefik writes a Traefik dynamic configuration file listing the
+// written certificate files. Traefik's file provider watches the dynamic
+// configuration, not the certificate files, and only loads them again when
+// the configuration changes, so the file names the certificate's fingerprint:
+// a renewal rewrites it and Traefik picks up the new certificate without a
+// reload. Otherwise the file is left alone.
+func deployTraefik(cfg config.CertificateConfiguration) (string, error) {
+	certPem, err := os.ReadFile(cfg.PemDestination)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := utils.GetCertificateSha1FromPem(certPem)
+	if err != nil {
+		return "", fmt.Errorf("read certificate %s: %w", cfg.PemDestination, err)
+	}
+	path := traefikConfigPath(cfg)
+	content := renderTraefikConfig(cfg, fingerprint, strings.EqualFold(filepath.Ext(path), ".toml"))
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return fmt.Sprintf("Traefik dynamic configuration %s is up to date\n", path), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	log.Printf("Writing Traefik dynamic configuration to %s", path)
+	if err := utils.WriteFileAtomic(path, content, 0o644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Wrote Traefik dynamic configuration %s\n", path), nil
+}
+
+func traefikConfigPath(cfg config.CertificateConfiguration) string {
+	if cfg.Traefik != nil && cfg.Traefik.DynamicConfigPath != "" {
+		return cfg.Traefik.DynamicConfigPath
+	}
+	return filepath.Join(traefikDynamicDir, "certkit-"+cfg.Id+".yml")
+}
+
+// renderTraefikConfig renders a tls.certificates entry in YAML, or TOML when
+// toml is set. certFile holds the full chain; validateTarget rejects a
+// separate chain file.
+func renderTraefikConfig(cfg config.CertificateConfiguration, fingerprint string, toml bool) []byte {
+	keyFile := cfg.KeyDestination
+	if cfg.AllInOne {
+		keyFile = cfg.PemDestination
+	}
+	var stores []string
+	if cfg.Traefik != nil {
+		stores = cfg.Traefik.Stores
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by certkit-agent for config %s. Changes will be overwritten.\n", cfg.Id)
+	fmt.Fprintf(&b, "# Certificate SHA-1: %s\n", fingerprint)
+	if toml {
+		b.WriteString("[[tls.certificates]]\n")
+		fmt.Fprintf(&b, "  certFile = %s\n", strconv.Quote(cfg.PemDestination))
+		fmt.Fprintf(&b, "  keyFile = %s\n", strconv.Quote(keyFile))
+		if len(stores) > 0 {
+			quoted := make([]string, len(stores))
+			for i, store := range stores {
+				quoted[i] = strconv.Quote(store)
+			}
+			fmt.Fprintf(&b, "  stores = [%s]\n", strings.Join(quoted, ", "))
+		}
+		return []byte(b.String())
+	}
+
+	b.WriteString("tls:\n  certificates:\n")
+	fmt.Fprintf(&b, "    - certFile: %s\n", strconv.Quote(cfg.PemDestination))
+	fmt.Fprintf(&b, "      keyFile: %s\n", strconv.Quote(keyFile))
+	if len(stores) > 0 {
+		b.WriteString("      stores:\n")
+		for _, store := range stores {
+			fmt.Fprintf(&b, "        - %s\n", strconv.Quote(store))
+		}
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+// Configuration types whose written files are then handed to a server by the
+// agent itself.
+const (
+	configTypeCaddy   = "caddy"
+	configTypeTraefik = "traefik"
+)
+
+// deployTarget hands the written certificate files to the server named by
+// cfg's type. Other types have nothing to deploy beyond the files.
+func deployTarget(ctx context.Context, cfg config.CertificateConfiguration) (string, error) {
+	switch strings.ToLower(cfg.ConfigType) {
+	case configTypeCaddy:
+		return deployCaddy(ctx, cfg)
+	case configTypeTraefik:
+		return deployTraefik(cfg)
+	}
+	return "", nil
+}
+
+// validateTarget rejects settings the server named by cfg's type cannot use.
+func validateTarget(cfg config.CertificateConfiguration) error {
+	switch strings.ToLower(cfg.ConfigType) {
+	case configTypeTraefik:
+		// Traefik only reads the chain from certFile, so with a separate
+		// chain file it would serve the leaf alone.
+		if strings.TrimSpace(cfg.ChainDestination) != "" {
+			return errors.New("chain_destination is not supported for traefik; the certificate file must hold the full chain")
+		}
+	}
+	return nil
+}
+
+// verifyTarget deploys again to a server that has lost the certificate
+// deployed to it, returning what was done, or "" when nothing was needed. It
+// runs on polls that otherwise have nothing to do.
+func verifyTarget(ctx context.Context, cfg config.CertificateConfiguration) (string, error) {
+	switch strings.ToLower(cfg.ConfigType) {
+	case configTypeCaddy:
+		return verifyCaddy(ctx, cfg)
+	}
+	return "", nil
+}
+
+// readDeployedPem returns the written certificate (with its chain) and key.
+func readDeployedPem(cfg config.CertificateConfiguration) (string, string, error) {
+	certData, err := os.ReadFile(cfg.PemDestination)
+	if err != nil {
+		return "", "", err
+	}
+	certPem := strings.TrimSpace(string(certData)) + "\n"
+	if chain := strings.TrimSpace(cfg.ChainDestination); chain != "" {
+		chainData, err := os.ReadFile(chain)
+		if err != nil {
+			return "", "", err
+		}
+		if text := strings.TrimSpace(string(chainData)); text != "" {
+			certPem += text + "\n"
+		}
+	}
+
+	keyPem := certPem
+	if !cfg.AllInOne {
+		keyData, err := os.ReadFile(cfg.KeyDestination)
+		if err != nil {
+			return "", "", err
+		}
+		keyPem = string(keyData)
+	}
+	return certPem, keyPem, nil
+}
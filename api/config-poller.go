@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,7 +29,7 @@ type ConfigurationPollResponse struct {
 	UpdatedCertificateConfigurations []config.CertificateConfiguration `json:"updated_certificate_configurations"`
 }
 
-func PollForConfiguration() (*ConfigurationPollResponse, error) {
+func PollForConfiguration(ctx context.Context) (*ConfigurationPollResponse, error) {
 	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
 		return nil, fmt.Errorf("missing agent id")
 	}
@@ -60,7 +61,8 @@ func PollForConfiguration() (*ConfigurationPollResponse, error) {
 		return nil, fmt.Errorf("marshal json: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/poll-config", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
 		bytes.NewReader(requestBody),
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,7 +25,7 @@ type FetchCertificateResponse struct {
 	CertificateSha1 string `json:"certificate_sha1,omitempty"`
 }
 
-func FetchCertificate(configurationId string, certificateId string) (*FetchCertificateResponse, error) {
+func FetchCertificate(ctx context.Context, configurationId string, certificateId string) (*FetchCertificateResponse, error) {
 	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
 		return nil, fmt.Errorf("missing agent id")
 	}
@@ -42,7 +43,8 @@ func FetchCertificate(configurationId string, certificateId string) (*FetchCerti
 		return nil, fmt.Errorf("marshal json: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/fetch-certificate", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
 		bytes.NewReader(requestBody),
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchCertificateCancelled(t *testing.T) {
+	var requests atomic.Int32
+	useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	response, err := FetchCertificate(ctx, "cfg1", "cert1")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if response != nil {
+		t.Errorf("response = %+v, want nil", response)
+	}
+	if requests.Load() != 0 {
+		t.Errorf("server received %d requests after cancellation", requests.Load())
+	}
+}
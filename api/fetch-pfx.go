@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,7 +19,7 @@ type FetchPfxResponse struct {
 	Password string
 }
 
-func FetchPfx(configurationId string, certificateId string) (*FetchPfxResponse, error) {
+func FetchPfx(ctx context.Context, configurationId string, certificateId string) (*FetchPfxResponse, error) {
 	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
 		return nil, fmt.Errorf("missing agent id")
 	}
@@ -36,7 +37,8 @@ func FetchPfx(configurationId string, certificateId string) (*FetchPfxResponse,
 		return nil, fmt.Errorf("marshal json: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/fetch-pfx", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
 		bytes.NewReader(requestBody),
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,7 +29,7 @@ type RegisterAgentResponse struct {
 	AgentId string `json:"agent_id"`
 }
 
-func RegisterAgent() (*RegisterAgentResponse, error) {
+func RegisterAgent(ctx context.Context) (*RegisterAgentResponse, error) {
 
 	hostname, _ := os.Hostname()
 	machineId, _ := utils.GetStableMachineID()
@@ -48,7 +49,8 @@ func RegisterAgent() (*RegisterAgentResponse, error) {
 	}
 
 	// Build request with raw bytes
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		config.CurrentConfig.ApiBase+"/api/agent/v1/register-agent",
 		bytes.NewReader(requestBody),
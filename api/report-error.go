@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,7 +19,7 @@ type AgentErrorReport struct {
 	CertificateId string `json:"certificate_id,omitempty"`
 }
 
-func ReportAgentError(message string, configId string, certificateId string) error {
+func ReportAgentError(ctx context.Context, message string, configId string, certificateId string) error {
 	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
 		return fmt.Errorf("missing agent id")
 	}
@@ -37,7 +38,8 @@ func ReportAgentError(message string, configId string, certificateId string) err
 		return fmt.Errorf("marshal json: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/report-error", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
 		bytes.NewReader(requestBody),
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,7 +13,7 @@ import (
 	"github.com/certkit-io/certkit-agent/config"
 )
 
-func UnregisterAgent(cfg config.Config) error {
+func UnregisterAgent(ctx context.Context, cfg config.Config) error {
 	if strings.TrimSpace(cfg.ApiBase) == "" {
 		return fmt.Errorf("missing api base")
 	}
@@ -25,7 +26,8 @@ func UnregisterAgent(cfg config.Config) error {
 
 	requestBody := []byte("{}")
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/unregister", cfg.ApiBase, cfg.Agent.AgentId),
 		bytes.NewReader(requestBody),
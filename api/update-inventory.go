@@ -2,11 +2,15 @@ package api
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/certkit-io/certkit-agent/auth"
@@ -14,52 +18,195 @@ import (
 )
 
 type InventoryItem struct {
-	Server          string `json:"server"`
-	ConfigPath      string `json:"config_path"`
-	CertificatePath string `json:"certificate_path"`
-	KeyPath         string `json:"key_path"`
-	ChainPath       string `json:"chain_path,omitempty"`
-	Domains         string `json:"domains,omitempty"`
+	Server          string            `json:"server"`
+	ConfigPath      string            `json:"config_path"`
+	CertificatePath string            `json:"certificate_path"`
+	KeyPath         string            `json:"key_path"`
+	ChainPath       string            `json:"chain_path,omitempty"`
+	Domains         []InventoryDomain `json:"domain_entries,omitempty"`
+
+	Certificate *InventoryCertificate `json:"certificate,omitempty"`
+	Endpoint    *InventoryEndpoint    `json:"endpoint,omitempty"`
+	Container   *InventoryContainer   `json:"container,omitempty"`
+	ServedOn    []string              `json:"served_on,omitempty"`
+}
+
+// MarshalJSON also sends the domain names under "domains" as the
+// comma-separated string servers have always read there.
+func (item InventoryItem) MarshalJSON() ([]byte, error) {
+	type inventoryItem InventoryItem
+	names := make([]string, 0, len(item.Domains))
+	for _, domain := range item.Domains {
+		names = append(names, domain.Name)
+	}
+	return json.Marshal(struct {
+		inventoryItem
+		DomainNames string `json:"domains,omitempty"`
+	}{inventoryItem(item), strings.Join(names, ",")})
+}
+
+// InventoryContainer identifies the container a certificate file is mounted
+// into. CertificatePath is where the container sees the file.
+type InventoryContainer struct {
+	Id              string `json:"id"`
+	Name            string `json:"name"`
+	Image           string `json:"image,omitempty"`
+	ComposeProject  string `json:"compose_project,omitempty"`
+	CertificatePath string `json:"certificate_path,omitempty"`
+	KeyPath         string `json:"key_path,omitempty"`
+}
+
+// Domain types classify the names found in server configuration.
+const (
+	DomainTypeDNS      = "dns"
+	DomainTypeWildcard = "wildcard"
+	DomainTypeIPv4     = "ipv4"
+	DomainTypeIPv6     = "ipv6"
+)
+
+// InventoryDomain is a name a server answers to. Internationalized names are
+// reported in punycode, with the original form in Unicode.
+type InventoryDomain struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Unicode string `json:"unicode,omitempty"`
+}
+
+// InventoryEndpoint describes a listening socket that answered a TLS
+// handshake. CertificatePaths lists the inventoried files holding the
+// certificate it served.
+type InventoryEndpoint struct {
+	Address          string   `json:"address"`
+	ServerName       string   `json:"server_name,omitempty"`
+	PID              int      `json:"pid,omitempty"`
+	ProcessName      string   `json:"process_name,omitempty"`
+	ProcessExe       string   `json:"process_exe,omitempty"`
+	CertificatePaths []string `json:"certificate_paths,omitempty"`
+}
+
+// Certificate statuses describe whether an inventoried certificate file could
+// be read and parsed.
+const (
+	CertificateStatusOK         = "ok"
+	CertificateStatusMissing    = "missing"
+	CertificateStatusUnreadable = "unreadable"
+	CertificateStatusInvalid    = "invalid"
+	CertificateStatusLocked     = "locked"
+)
+
+// Key match results compare the certificate's public key with the private key
+// the server is configured to use.
+const (
+	KeyMatchMatch      = "match"
+	KeyMatchMismatch   = "mismatch"
+	KeyMatchMissing    = "missing"
+	KeyMatchUnreadable = "unreadable"
+	KeyMatchUnknown    = "unknown"
+)
+
+// InventoryCertificate is what the agent found on disk at an inventory item's
+// certificate path. Only Status and Error are set when the file could not be
+// parsed.
+type InventoryCertificate struct {
+	Status            string     `json:"status"`
+	Error             string     `json:"error,omitempty"`
+	Format            string     `json:"format,omitempty"`
+	Subject           string     `json:"subject,omitempty"`
+	SANs              []string   `json:"sans,omitempty"`
+	Issuer            string     `json:"issuer,omitempty"`
+	Serial            string     `json:"serial,omitempty"`
+	NotBefore         *time.Time `json:"not_before,omitempty"`
+	NotAfter          *time.Time `json:"not_after,omitempty"`
+	KeyAlgorithm      string     `json:"key_algorithm,omitempty"`
+	KeySize           int        `json:"key_size,omitempty"`
+	FingerprintSHA1   string     `json:"fingerprint_sha1,omitempty"`
+	FingerprintSHA256 string     `json:"fingerprint_sha256,omitempty"`
+	KeyMatch          string     `json:"key_match,omitempty"`
+	ChainLength       int        `json:"chain_length,omitempty"`
+	ChainComplete     *bool      `json:"chain_complete,omitempty"`
+}
+
+// InventoryError is a problem a provider hit while collecting inventory.
+// Path is set when the problem is with a specific file.
+type InventoryError struct {
+	Provider string `json:"provider"`
+	Path     string `json:"path,omitempty"`
+	Error    string `json:"error"`
 }
 
 type InventoryUpdate struct {
-	Items []InventoryItem `json:"items"`
+	Items  []InventoryItem  `json:"items"`
+	Errors []InventoryError `json:"errors,omitempty"`
 }
 
-func UpdateInventory(items []InventoryItem) error {
+// inventoryGzipThreshold is the JSON size above which inventory uploads are
+// gzip-compressed. The request signature covers the compressed body.
+const inventoryGzipThreshold = 8 * 1024
+
+// inventoryGzipRejected is set once the server has refused a compressed
+// upload, so later uploads go uncompressed straight away.
+var inventoryGzipRejected atomic.Bool
+
+func UpdateInventory(ctx context.Context, payload InventoryUpdate) error {
 	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
 		return fmt.Errorf("missing agent id")
 	}
 
-	payload := InventoryUpdate{
-		Items: items,
-	}
-
-	log.Printf("Auto-discovered software: %v", payload)
+	log.Printf("Sending inventory: %d item(s), %d error(s)", len(payload.Items), len(payload.Errors))
 
 	requestBody, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("marshal json: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	if len(requestBody) > inventoryGzipThreshold && !inventoryGzipRejected.Load() {
+		compressed, err := gzipBytes(requestBody)
+		if err != nil {
+			return fmt.Errorf("compress inventory: %w", err)
+		}
+		status, body, err := postInventory(ctx, compressed, true)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusUnsupportedMediaType {
+			return inventoryResponseError(status, body)
+		}
+		log.Printf("Server does not accept compressed inventory; sending it uncompressed")
+		inventoryGzipRejected.Store(true)
+	}
+
+	status, body, err := postInventory(ctx, requestBody, false)
+	if err != nil {
+		return err
+	}
+	return inventoryResponseError(status, body)
+}
+
+// postInventory sends one signed inventory upload and returns the response
+// status and body.
+func postInventory(ctx context.Context, requestBody []byte, gzipped bool) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/update-inventory", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
 		bytes.NewReader(requestBody),
 	)
 	if err != nil {
-		return fmt.Errorf("new request: %w", err)
+		return 0, nil, fmt.Errorf("new request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 
 	privKey, err := config.CurrentConfig.Auth.KeyPair.DecodePrivateKey()
 	if err != nil {
-		return fmt.Errorf("decode private key: %w", err)
+		return 0, nil, fmt.Errorf("decode private key: %w", err)
 	}
 
 	if err := auth.SignRequest(req, config.CurrentConfig.Agent.AgentId, config.CurrentConfig.Version.Version, privKey, time.Now()); err != nil {
-		return fmt.Errorf("sign request: %w", err)
+		return 0, nil, fmt.Errorf("sign request: %w", err)
 	}
 
 	client := &http.Client{
@@ -68,18 +215,32 @@ func UpdateInventory(items []InventoryItem) error {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("http do: %w", err)
+		return 0, nil, fmt.Errorf("http do: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
-		return nil
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("read response: %w", err)
+		return 0, nil, fmt.Errorf("read response: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+func inventoryResponseError(status int, body []byte) error {
+	if status == http.StatusOK || status == http.StatusNoContent {
+		return nil
 	}
+	return fmt.Errorf("update inventory failed: status=%d body=%s", status, body)
+}
 
-	return fmt.Errorf("update inventory failed: status=%d body=%s", resp.StatusCode, body)
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInventoryItemDomainsJSON(t *testing.T) {
+	item := InventoryItem{
+		Server: "nginx",
+		Domains: []InventoryDomain{
+			{Name: "example.com", Type: DomainTypeDNS},
+			{Name: "*.example.com", Type: DomainTypeWildcard},
+			{Name: "xn--bcher-kva.example", Type: DomainTypeDNS, Unicode: "bücher.example"},
+		},
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire map[string]any
+	if err := json.Unmarshal(data, &wire); err != nil {
+		t.Fatal(err)
+	}
+	if wire["domains"] != "example.com,*.example.com,xn--bcher-kva.example" {
+		t.Errorf("domains = %v", wire["domains"])
+	}
+	if entries, _ := wire["domain_entries"].([]any); len(entries) != 3 {
+		t.Errorf("domain_entries = %v", wire["domain_entries"])
+	}
+
+	// Items read back, as the control socket does, keep the typed list.
+	var decoded InventoryItem
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, item) {
+		t.Errorf("decoded = %+v", decoded)
+	}
+
+	if data, _ := json.Marshal(InventoryItem{Server: "nginx"}); string(data) != `{"server":"nginx","config_path":"","certificate_path":"","key_path":""}` {
+		t.Errorf("empty item = %s", data)
+	}
+}
+
+func largeInventory() InventoryUpdate {
+	update := InventoryUpdate{}
+	for i := range 200 {
+		update.Items = append(update.Items, InventoryItem{
+			Server:          "nginx",
+			ConfigPath:      "/etc/nginx/nginx.conf",
+			CertificatePath: fmt.Sprintf("/etc/ssl/site-%d.pem", i),
+		})
+	}
+	return update
+}
+
+func TestUpdateInventoryCompressesLargeUploads(t *testing.T) {
+	var received InventoryUpdate
+	useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(raw)
+		if r.Header.Get("X-Agent-Content-SHA256") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			http.Error(w, "signature does not cover the body as sent", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			http.Error(w, "expected gzip", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(zr).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	update := largeInventory()
+	if err := UpdateInventory(t.Context(), update); err != nil {
+		t.Fatal(err)
+	}
+	if len(received.Items) != len(update.Items) || received.Items[199].CertificatePath != "/etc/ssl/site-199.pem" {
+		t.Errorf("server received %d items", len(received.Items))
+	}
+}
+
+func TestUpdateInventoryFallsBackWhenCompressionRejected(t *testing.T) {
+	t.Cleanup(func() { inventoryGzipRejected.Store(false) })
+	var encodings []string
+	useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for range 2 {
+		if err := UpdateInventory(t.Context(), largeInventory()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(encodings, ",") != "gzip,," {
+		t.Errorf("content encodings sent = %q, want gzip then plain twice", encodings)
+	}
+}
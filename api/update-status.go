@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,7 +25,7 @@ type AgentConfigStatusUpdateBatch struct {
 	Updates []AgentConfigStatusUpdate `json:"updates"`
 }
 
-func UpdateConfigStatus(updates []AgentConfigStatusUpdate) error {
+func UpdateConfigStatus(ctx context.Context, updates []AgentConfigStatusUpdate) error {
 	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
 		return fmt.Errorf("missing agent id")
 	}
@@ -41,7 +42,8 @@ func UpdateConfigStatus(updates []AgentConfigStatusUpdate) error {
 		return fmt.Errorf("marshal json: %w", err)
 	}
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
 		fmt.Sprintf("%s/api/agent/v1/%s/update-status", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
 		bytes.NewReader(requestBody),
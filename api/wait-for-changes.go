@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/auth"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+// ErrChangeNotificationsUnsupported is returned when the CertKit server does
+// not offer the long-poll change notification endpoint.
+var ErrChangeNotificationsUnsupported = errors.New("change notifications not supported by server")
+
+type WaitForChangesRequest struct {
+	WaitSeconds int `json:"wait_seconds"`
+}
+
+type WaitForChangesResponse struct {
+	ConfigChanged bool `json:"config_changed"`
+}
+
+// WaitForChanges holds a signed long-poll request open until the server
+// reports a configuration change or waitFor elapses. A nil response means the
+// wait ended without a change.
+func WaitForChanges(ctx context.Context, waitFor time.Duration) (*WaitForChangesResponse, error) {
+	if config.CurrentConfig.Agent == nil || config.CurrentConfig.Agent.AgentId == "" {
+		return nil, fmt.Errorf("missing agent id")
+	}
+
+	payload := WaitForChangesRequest{
+		WaitSeconds: int(waitFor / time.Second),
+	}
+
+	requestBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal json: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/api/agent/v1/%s/wait-for-changes", config.CurrentConfig.ApiBase, config.CurrentConfig.Agent.AgentId),
+		bytes.NewReader(requestBody),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	privKey, err := config.CurrentConfig.Auth.KeyPair.DecodePrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("decode private key: %w", err)
+	}
+
+	if err := auth.SignRequest(req, config.CurrentConfig.Agent.AgentId, config.CurrentConfig.Version.Version, privKey, time.Now()); err != nil {
+		return nil, fmt.Errorf("sign request: %w", err)
+	}
+
+	// The server holds the request for up to waitFor; leave headroom for the reply.
+	client := &http.Client{
+		Timeout: waitFor + 15*time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrChangeNotificationsUnsupported
+	case http.StatusForbidden:
+		utils.MarkAgentUnauthorized()
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wait for changes failed: status=%d body=%s", resp.StatusCode, body)
+	}
+
+	var waitResp WaitForChangesResponse
+	if err := json.Unmarshal(body, &waitResp); err != nil {
+		return nil, fmt.Errorf("decode wait response: %w", err)
+	}
+
+	return &waitResp, nil
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	agentCrypto "github.com/certkit-io/certkit-agent/crypto"
+)
+
+func useTestServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	saved := config.CurrentConfig
+	t.Cleanup(func() { config.CurrentConfig = saved })
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+}
+
+func TestWaitForChanges(t *testing.T) {
+	var request WaitForChangesRequest
+	useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/agent/v1/agent1/wait-for-changes" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"config_changed":true}`))
+	})
+
+	response, err := WaitForChanges(t.Context(), 45*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response == nil || !response.ConfigChanged {
+		t.Fatalf("response = %+v, want config_changed", response)
+	}
+	if request.WaitSeconds != 45 {
+		t.Errorf("wait_seconds = %d, want 45", request.WaitSeconds)
+	}
+}
+
+func TestWaitForChangesStatus(t *testing.T) {
+	tests := []struct {
+		status      int
+		unsupported bool
+		fails       bool
+	}{
+		{status: http.StatusNoContent},
+		{status: http.StatusNotFound, unsupported: true},
+		{status: http.StatusMethodNotAllowed, unsupported: true},
+		{status: http.StatusNotImplemented, unsupported: true},
+		{status: http.StatusInternalServerError, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			})
+
+			response, err := WaitForChanges(t.Context(), time.Second)
+			if response != nil {
+				t.Errorf("response = %+v, want nil", response)
+			}
+			if got := errors.Is(err, ErrChangeNotificationsUnsupported); got != tt.unsupported {
+				t.Errorf("unsupported = %v, want %v (err %v)", got, tt.unsupported, err)
+			}
+			if got := err != nil && !tt.unsupported; got != tt.fails {
+				t.Errorf("err = %v, want failure %v", err, tt.fails)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -45,7 +46,7 @@ func doRegister(configPath string, key string) error {
 		return nil
 	}
 
-	agent.DoRegistration()
+	agent.DoRegistration(context.Background())
 	if agent.NeedsRegistration() {
 		return fmt.Errorf("agent registration did not complete")
 	}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/agent"
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/control"
+)
+
+const controlCallTimeout = 10 * time.Minute
+
+// startControlServer serves the control socket for the running agent. Status
+// is answered directly from published state; sync and inventory requests are
+// handed to the run loop through jobs so they never race a scheduled poll.
+func startControlServer(ctx context.Context, socketPath string, jobs chan<- func()) {
+	listener, err := control.Listen(socketPath)
+	if err != nil {
+		log.Printf("Error starting control socket: %v", err)
+		return
+	}
+	log.Printf("Control socket listening on %s", socketPath)
+
+	runOnLoop := func(fn func()) error {
+		done := make(chan struct{})
+		select {
+		case jobs <- func() { defer close(done); fn() }:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-done
+		return nil
+	}
+
+	agentId := ""
+	if config.CurrentConfig.Agent != nil {
+		agentId = config.CurrentConfig.Agent.AgentId
+	}
+
+	go control.Serve(ctx, listener, func(ctx context.Context, req control.Request) control.Response {
+		var resp control.Response
+		var callErr error
+		switch req.Command {
+		case control.CommandStatus:
+			status := agent.Status(agentId, version)
+			resp.Status = &status
+		case control.CommandSync:
+			log.Printf("Sync requested via control socket (config_id=%s)", req.ConfigId)
+			err := runOnLoop(func() {
+				resp.Statuses, callErr = agent.ForceSync(ctx, req.ConfigId)
+			})
+			if err != nil {
+				callErr = err
+			}
+		case control.CommandInventory:
+			log.Printf("Inventory requested via control socket (send=%t)", req.Send)
+			err := runOnLoop(func() {
+				var update api.InventoryUpdate
+				update, callErr = agent.CollectInventory(ctx, req.Send)
+				resp.Inventory, resp.InventoryErrors = update.Items, update.Errors
+			})
+			if err != nil {
+				callErr = err
+			}
+		default:
+			callErr = fmt.Errorf("unknown command %q", req.Command)
+		}
+		if callErr != nil {
+			resp.Error = callErr.Error()
+		}
+		return resp
+	})
+}
+
+func controlSocketFlags(fs *flag.FlagSet) (*string, *string) {
+	configPath := fs.String("config", defaultConfigPath, "path to config.json (used to locate the control socket)")
+	socketPath := fs.String("socket", "", "path to the agent control socket (default: next to config.json)")
+	return configPath, socketPath
+}
+
+func resolveSocketPath(configPath, socketPath string) string {
+	if strings.TrimSpace(socketPath) != "" {
+		return socketPath
+	}
+	return control.DefaultSocketPath(configPath)
+}
+
+func statusCmd(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath, socketPath := controlSocketFlags(fs)
+	fs.Parse(args)
+
+	resp, err := control.Call(resolveSocketPath(*configPath, *socketPath), control.Request{Command: control.CommandStatus}, 30*time.Second)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	status := resp.Status
+	if status == nil {
+		fmt.Fprintln(os.Stderr, "agent returned no status")
+		os.Exit(1)
+	}
+
+	fmt.Printf("agent id:             %s\n", valueOr(status.AgentId, "(not registered)"))
+	fmt.Printf("version:              %s\n", status.Version)
+	fmt.Printf("authorized:           %t\n", status.Authorized)
+	fmt.Printf("last poll:            %s\n", formatTimePtr(status.LastPoll))
+	fmt.Printf("last successful poll: %s\n", formatTimePtr(status.LastSuccessfulPoll))
+	if status.LastPollError != "" {
+		fmt.Printf("last poll error:      %s\n", status.LastPollError)
+	}
+	fmt.Printf("last inventory sent:  %s\n", formatTimePtr(status.LastInventorySent))
+	fmt.Println()
+
+	if len(status.Configs) == 0 {
+		fmt.Println("no certificate configurations")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG ID\tNAME\tTYPE\tSTATUS\tDEPLOYED EXPIRY")
+	for _, cfg := range status.Configs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			cfg.ConfigId,
+			valueOr(cfg.Name, "-"),
+			valueOr(cfg.ConfigType, "-"),
+			valueOr(cfg.LastStatus, "-"),
+			formatTimePtr(cfg.DeployedExpiry),
+		)
+	}
+	w.Flush()
+}
+
+func syncCmd(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	configPath, socketPath := controlSocketFlags(fs)
+	configId := fs.String("config-id", "", "only synchronize this certificate configuration")
+	fs.Parse(args)
+
+	resp, err := control.Call(resolveSocketPath(*configPath, *socketPath), control.Request{
+		Command:  control.CommandSync,
+		ConfigId: strings.TrimSpace(*configId),
+	}, controlCallTimeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if len(resp.Statuses) == 0 {
+		fmt.Println("sync complete; no configurations synchronized")
+		return
+	}
+	failed := false
+	for _, status := range resp.Statuses {
+		fmt.Printf("%s: %s\n", status.ConfigId, status.Status)
+		if status.Status != "SYNCED" {
+			failed = true
+			if status.Message != "" {
+				fmt.Printf("  %s\n", strings.ReplaceAll(strings.TrimSpace(status.Message), "\n", "\n  "))
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func inventoryCmd(args []string) {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	configPath, socketPath := controlSocketFlags(fs)
+	send := fs.Bool("send", false, "upload the collected inventory to CertKit")
+	fs.Parse(args)
+
+	resp, err := control.Call(resolveSocketPath(*configPath, *socketPath), control.Request{
+		Command: control.CommandInventory,
+		Send:    *send,
+	}, controlCallTimeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVER\tCERTIFICATE\tKEY\tEXPIRES\tCONFIG")
+	for _, item := range resp.Inventory {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Server, item.CertificatePath, valueOr(item.KeyPath, "-"), inventoryExpiry(item), item.ConfigPath)
+	}
+	w.Flush()
+	for _, invErr := range resp.InventoryErrors {
+		if invErr.Path != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", invErr.Provider, invErr.Path, invErr.Error)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", invErr.Provider, invErr.Error)
+		}
+	}
+	if *send {
+		fmt.Printf("sent %d inventory item(s)\n", len(resp.Inventory))
+	}
+}
+
+// inventoryExpiry shows when an inventoried certificate expires, or why that
+// could not be determined.
+func inventoryExpiry(item api.InventoryItem) string {
+	if item.Certificate == nil {
+		return "-"
+	}
+	if item.Certificate.NotAfter == nil {
+		return item.Certificate.Status
+	}
+	return formatTimePtr(item.Certificate.NotAfter)
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
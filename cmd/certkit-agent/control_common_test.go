@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/control"
+	agentCrypto "github.com/certkit-io/certkit-agent/crypto"
+)
+
+// startTestControlServer runs the control server against a stand-in CertKit
+// API whose poll reports no change, with jobs run as the run loop would.
+func startTestControlServer(t *testing.T) string {
+	t.Helper()
+	keyPair, err := agentCrypto.CreateNewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/poll-config") {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	saved, savedPath := config.CurrentConfig, config.CurrentPath
+	t.Cleanup(func() { config.CurrentConfig, config.CurrentPath = saved, savedPath })
+	config.CurrentPath = filepath.Join(dir, "config.json")
+	config.CurrentConfig.ApiBase = server.URL
+	config.CurrentConfig.Agent = &config.AgentCreds{AgentId: "agent1"}
+	config.CurrentConfig.Auth = &config.AuthCreds{KeyPair: keyPair}
+	config.CurrentConfig.CertificateConfigurations = []config.CertificateConfiguration{
+		{Id: "cfg1", CertificateId: "cert1"},
+		{Id: "cfg2", CertificateId: "cert2"},
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	jobs := make(chan func())
+	go func() {
+		for {
+			select {
+			case job := <-jobs:
+				job()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	socketPath := filepath.Join(dir, control.DefaultSocketName)
+	startControlServer(ctx, socketPath, jobs)
+	return socketPath
+}
+
+func TestControlServerStatus(t *testing.T) {
+	socketPath := startTestControlServer(t)
+
+	resp, err := control.Call(socketPath, control.Request{Command: control.CommandStatus}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status == nil || resp.Status.AgentId != "agent1" || resp.Status.Version != version {
+		t.Fatalf("status = %+v", resp.Status)
+	}
+}
+
+func TestControlServerSyncConfig(t *testing.T) {
+	socketPath := startTestControlServer(t)
+
+	resp, err := control.Call(socketPath, control.Request{Command: control.CommandSync, ConfigId: "cfg2"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Statuses) != 1 || resp.Statuses[0].ConfigId != "cfg2" {
+		t.Errorf("statuses = %+v, want only cfg2", resp.Statuses)
+	}
+
+	if _, err := control.Call(socketPath, control.Request{Command: control.CommandSync, ConfigId: "missing"}, 5*time.Second); err == nil {
+		t.Error("sync of an unknown configuration expected error")
+	}
+}
+
+func TestControlServerInventory(t *testing.T) {
+	socketPath := startTestControlServer(t)
+	dir := t.TempDir()
+	nginxConf := filepath.Join(dir, "nginx.conf")
+	certPath := filepath.Join(dir, "site.crt")
+	conf := "http { server { listen 443 ssl; server_name www.example.com; ssl_certificate " + certPath + "; ssl_certificate_key " + filepath.Join(dir, "site.key") + "; } }\n"
+	if err := os.WriteFile(nginxConf, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config.CurrentConfig.Inventory = &config.InventoryConfig{
+		DisabledProviders: []string{"apache", "litespeed", "haproxy", "caddy", "traefik", "kubernetes", "docker", "docker-engine", "tls-probe", "filesystem", "iis", "rras"},
+		ExtraConfigPaths:  map[string][]string{"nginx": {nginxConf}},
+	}
+
+	resp, err := control.Call(socketPath, control.Request{Command: control.CommandInventory}, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range resp.Inventory {
+		if item.CertificatePath == certPath {
+			return
+		}
+	}
+	t.Errorf("inventory = %+v, want an item for %s", resp.Inventory, certPath)
+}
+
+func TestControlServerUnknownCommand(t *testing.T) {
+	socketPath := startTestControlServer(t)
+
+	if _, err := control.Call(socketPath, control.Request{Command: "reboot"}, 5*time.Second); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("error = %v, want unknown command", err)
+	}
+}
@@ -7,6 +7,9 @@
 //	certkit-agent run
 //	certkit-agent register
 //	certkit-agent validate
+//	certkit-agent status
+//	certkit-agent sync
+//	certkit-agent inventory
 //	certkit-agent version
 //
 // Build:
@@ -65,6 +68,12 @@ func main() {
 		registerCmd(os.Args[2:])
 	case "validate":
 		validateCmd(os.Args[2:])
+	case "status":
+		statusCmd(os.Args[2:])
+	case "sync":
+		syncCmd(os.Args[2:])
+	case "inventory":
+		inventoryCmd(os.Args[2:])
 	case "version":
 		versionCmd()
 	default:
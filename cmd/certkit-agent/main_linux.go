@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -25,9 +26,12 @@ func usageAndExit() {
 Usage:
   certkit-agent install    [--service-name NAME] [--config PATH] [--key REGISTRATION_KEY]
   certkit-agent uninstall  [--service-name NAME] [--config PATH]
-  certkit-agent run        [--config PATH] [--once] [--key REGISTRATION_KEY]
+  certkit-agent run        [--config PATH] [--once] [--key REGISTRATION_KEY] [--socket PATH]
   certkit-agent register   REGISTRATION_KEY [--config PATH]
   certkit-agent validate   [--config PATH]
+  certkit-agent status     [--config PATH] [--socket PATH]
+  certkit-agent sync       [--config-id ID] [--config PATH] [--socket PATH]
+  certkit-agent inventory  [--send] [--config PATH] [--socket PATH]
   certkit-agent version
 `, version)
 	os.Exit(2)
@@ -46,34 +50,26 @@ func runCmd(args []string) {
 	configPath := fs.String("config", defaultConfigPath, "path to config.json")
 	runOnce := fs.Bool("once", false, "run register/poll/sync once and exit")
 	key := fs.String("key", "", "registration key used when creating a new config")
+	socketPath := fs.String("socket", "", "path to the control socket (default: next to config.json)")
 	fs.Parse(args)
 
-	if *runOnce {
-		runAgent(runOptions{
-			configPath:  *configPath,
-			stopCh:      nil,
-			runOnce:     true,
-			key:         *key,
-			serviceName: defaultServiceName,
-		})
-		return
-	}
-
-	stopCh := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	sigCh := make(chan os.Signal, 2)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigCh
 		log.Printf("received signal %s, shutting down", sig)
-		close(stopCh)
+		cancel()
 	}()
 
 	runAgent(runOptions{
 		configPath:  *configPath,
-		stopCh:      stopCh,
-		runOnce:     false,
+		ctx:         ctx,
+		runOnce:     *runOnce,
 		key:         *key,
 		serviceName: defaultServiceName,
+		socketPath:  *socketPath,
 	})
 }
 
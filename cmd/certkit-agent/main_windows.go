@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -26,9 +27,12 @@ func usageAndExit() {
 Usage:
   certkit-agent install    [--service-name NAME] [--config PATH] [--key REGISTRATION_KEY]
   certkit-agent uninstall  [--service-name NAME] [--config PATH]
-  certkit-agent run        [--config PATH] [--once] [--key REGISTRATION_KEY]
+  certkit-agent run        [--config PATH] [--once] [--key REGISTRATION_KEY] [--socket PATH]
   certkit-agent register   REGISTRATION_KEY [--config PATH]
   certkit-agent validate   [--config PATH]
+  certkit-agent status     [--config PATH] [--socket PATH]
+  certkit-agent sync       [--config-id ID] [--config PATH] [--socket PATH]
+  certkit-agent inventory  [--send] [--config PATH] [--socket PATH]
   certkit-agent version
 `, version)
 	os.Exit(2)
@@ -52,6 +56,7 @@ func runCmd(args []string) {
 	forceService := fs.Bool("service", false, "force service mode (used by SCM)")
 	runOnce := fs.Bool("once", false, "run register/poll/sync once and exit")
 	key := fs.String("key", "", "registration key used when creating a new config")
+	socketPath := fs.String("socket", "", "path to the control socket (default: next to config.json)")
 	fs.Parse(args)
 
 	isService, err := svc.IsWindowsService()
@@ -60,12 +65,15 @@ func runCmd(args []string) {
 			log.Fatal("--once cannot be used in service mode")
 		}
 		mustBeAdmin()
+		ctx, cancel := interruptContext()
+		defer cancel()
 		runAgent(runOptions{
 			configPath:  *configPath,
-			stopCh:      nil,
+			ctx:         ctx,
 			runOnce:     true,
 			key:         *key,
 			serviceName: *serviceName,
+			socketPath:  *socketPath,
 		})
 		return
 	}
@@ -78,24 +86,38 @@ func runCmd(args []string) {
 
 	mustBeAdmin()
 
-	stopCh := make(chan struct{})
-	sigCh := make(chan os.Signal, 2)
-	signal.Notify(sigCh, os.Interrupt)
-	go func() {
-		sig := <-sigCh
-		log.Printf("received signal %s, shutting down", sig)
-		close(stopCh)
-	}()
-
+	ctx, cancel := interruptContext()
+	defer cancel()
 	runAgent(runOptions{
 		configPath:  *configPath,
-		stopCh:      stopCh,
+		ctx:         ctx,
 		runOnce:     false,
 		key:         *key,
 		serviceName: *serviceName,
+		socketPath:  *socketPath,
 	})
 }
 
+// interruptContext returns a context that is cancelled on Ctrl+C, so a
+// foreground run stops between deployments rather than midway through one.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			log.Printf("received signal %s, shutting down", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigCh)
+		cancel()
+	}
+}
+
 func registerCmd(args []string) {
 	fs := flag.NewFlagSet("register", flag.ExitOnError)
 	configPath := fs.String("config", defaultConfigPath, "path to config.json")
@@ -150,12 +172,13 @@ func (s *windowsService) Execute(_ []string, r <-chan svc.ChangeRequest, changes
 
 	initServiceLogging(s.configPath)
 
-	stopCh := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	done := make(chan struct{})
 	go func() {
 		runAgent(runOptions{
 			configPath:  s.configPath,
-			stopCh:      stopCh,
+			ctx:         ctx,
 			runOnce:     false,
 			key:         "",
 			serviceName: s.serviceName,
@@ -171,7 +194,7 @@ func (s *windowsService) Execute(_ []string, r <-chan svc.ChangeRequest, changes
 			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
 			changes <- svc.Status{State: svc.StopPending}
-			close(stopCh)
+			cancel()
 			<-done
 			changes <- svc.Status{State: svc.Stopped}
 			return false, 0
@@ -180,7 +203,7 @@ func (s *windowsService) Execute(_ []string, r <-chan svc.ChangeRequest, changes
 	}
 
 	changes <- svc.Status{State: svc.StopPending}
-	close(stopCh)
+	cancel()
 	<-done
 	changes <- svc.Status{State: svc.Stopped}
 	return false, 0
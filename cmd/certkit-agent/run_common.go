@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -11,14 +12,16 @@ import (
 
 	"github.com/certkit-io/certkit-agent/agent"
 	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/metrics"
 )
 
 type runOptions struct {
 	configPath  string
-	stopCh      <-chan struct{}
+	ctx         context.Context
 	runOnce     bool
 	key         string
 	serviceName string
+	socketPath  string
 }
 
 func runAgent(opts runOptions) {
@@ -39,13 +42,19 @@ func runAgent(opts runOptions) {
 
 	log.Printf("API Base: %s", config.CurrentConfig.ApiBase)
 
+	metrics.SetVersion(version)
+	if !opts.runOnce && config.CurrentConfig.Metrics != nil && strings.TrimSpace(config.CurrentConfig.Metrics.ListenAddress) != "" {
+		startMetricsServer(opts.ctx, config.CurrentConfig.Metrics.ListenAddress)
+	}
+	agent.RefreshConfigState()
+
 	registeredOnStartup := false
 	if agent.NeedsRegistration() {
 		if config.CurrentConfig.Bootstrap == nil || strings.TrimSpace(config.CurrentConfig.Bootstrap.RegistrationKey) == "" {
 			log.Fatal(fmt.Errorf("agent is not registered and no registration key is configured"))
 		}
 
-		agent.DoRegistration()
+		agent.DoRegistration(opts.ctx)
 		if agent.NeedsRegistration() {
 			log.Fatal(fmt.Errorf("agent registration did not complete"))
 		}
@@ -53,31 +62,63 @@ func runAgent(opts runOptions) {
 	}
 
 	if opts.runOnce {
-		agent.PollAndSync(true)
+		agent.PollAndSync(opts.ctx, true)
 		log.Printf("certkit-agent single run complete")
 		return
 	}
 
 	if !opts.runOnce && !registeredOnStartup {
-		agent.SendInventory()
+		agent.SendInventory(opts.ctx)
 	}
 
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	agent.PollAndSync(true)
+	inventoryTicker := time.NewTicker(agent.InventoryInterval)
+	defer inventoryTicker.Stop()
+
+	changedCh := make(chan struct{}, 1)
+	if !config.CurrentConfig.DisableChangeNotifications {
+		go agent.WatchForChanges(opts.ctx, changedCh)
+	}
+
+	jobs := make(chan func())
+	startControlServer(opts.ctx, resolveSocketPath(opts.configPath, opts.socketPath), jobs)
+
+	agent.PollAndSync(opts.ctx, true)
 
 	for {
 		select {
-		case <-opts.stopCh:
+		case <-opts.ctx.Done():
 			log.Printf("received stop signal, shutting down")
 			return
 		case <-ticker.C:
-			agent.PollAndSync(false)
+			agent.PollAndSync(opts.ctx, false)
+		case <-inventoryTicker.C:
+			agent.SendInventory(opts.ctx)
+		case <-changedCh:
+			log.Printf("Received configuration change notification")
+			agent.PollAndSync(opts.ctx, true)
+		case job := <-jobs:
+			job()
 		}
 	}
 }
 
+func startMetricsServer(ctx context.Context, address string) {
+	listener, err := metrics.Listen(address)
+	if err != nil {
+		log.Printf("Error starting metrics endpoint: %v", err)
+		return
+	}
+	log.Printf("Serving metrics on %s", address)
+	go func() {
+		if err := metrics.Serve(ctx, listener); err != nil {
+			log.Printf("Metrics endpoint stopped: %v", err)
+		}
+	}()
+}
+
 func runCmdLogged(name string, args ...string) error {
 	cmd := exec.Command(name, args...)
 	var out bytes.Buffer
@@ -19,13 +19,17 @@ var CurrentConfig Config
 var CurrentPath string
 
 type Config struct {
-	ApiBase                   string                     `json:"api_base"`
-	Bootstrap                 *BootstrapCreds            `json:"bootstrap,omitempty"`
-	Agent                     *AgentCreds                `json:"agent,omitempty"`
-	CertificateConfigurations []CertificateConfiguration `json:"certificate_configurations,omitempty"`
-	InventorySent             bool                       `json:"inventory_sent,omitempty"`
-	Auth                      *AuthCreds                 `json:"auth,omitempty"`
-	Version                   VersionInfo                `json:"-"`
+	ApiBase                    string                     `json:"api_base"`
+	Bootstrap                  *BootstrapCreds            `json:"bootstrap,omitempty"`
+	Agent                      *AgentCreds                `json:"agent,omitempty"`
+	CertificateConfigurations  []CertificateConfiguration `json:"certificate_configurations,omitempty"`
+	InventoryHash              string                     `json:"inventory_hash,omitempty"`
+	InventorySentAt            *time.Time                 `json:"inventory_sent_at,omitempty"`
+	DisableChangeNotifications bool                       `json:"disable_change_notifications,omitempty"`
+	Metrics                    *MetricsConfig             `json:"metrics,omitempty"`
+	Inventory                  *InventoryConfig           `json:"inventory,omitempty"`
+	Auth                       *AuthCreds                 `json:"auth,omitempty"`
+	Version                    VersionInfo                `json:"-"`
 }
 
 type BootstrapCreds struct {
@@ -42,23 +46,205 @@ type AuthCreds struct {
 }
 
 type CertificateConfiguration struct {
-	Id                          string     `json:"config_id"`
-	CertificateId               string     `json:"certificate_id,omitempty"`
-	LastConfigurationUpdateDate *time.Time `json:"last_configuration_update_date,omitempty"`
-	LastCertificateUpdateDate   *time.Time `json:"last_certificate_update_date,omitempty"`
-	LatestCertificateSha1       string     `json:"latest_certificate_sha1,omitempty"`
-	LastStatus                  string     `json:"last_status,omitempty"`
-	PemDestination              string     `json:"pem_destination,omitempty"`
-	KeyDestination              string     `json:"key_destination,omitempty"`
-	ChainDestination            string     `json:"chain_destination,omitempty"`
-	OwnerUser                   string     `json:"owner_user,omitempty"`
-	OwnerGroup                  string     `json:"owner_group,omitempty"`
-	FilePermissions             string     `json:"file_permissions,omitempty"`
-	UpdateCmd                   string     `json:"update_cmd,omitempty"`
-	Name                        string     `json:"name,omitempty"`
-	AllInOne                    bool       `json:"all_in_one,omitempty"`
-	IsPfx                       bool       `json:"is_pfx"`
-	ConfigType                  string     `json:"config_type"`
+	Id                          string            `json:"config_id"`
+	CertificateId               string            `json:"certificate_id,omitempty"`
+	LastConfigurationUpdateDate *time.Time        `json:"last_configuration_update_date,omitempty"`
+	LastCertificateUpdateDate   *time.Time        `json:"last_certificate_update_date,omitempty"`
+	LatestCertificateSha1       string            `json:"latest_certificate_sha1,omitempty"`
+	LastStatus                  string            `json:"last_status,omitempty"`
+	PemDestination              string            `json:"pem_destination,omitempty"`
+	KeyDestination              string            `json:"key_destination,omitempty"`
+	ChainDestination            string            `json:"chain_destination,omitempty"`
+	OwnerUser                   string            `json:"owner_user,omitempty"`
+	OwnerGroup                  string            `json:"owner_group,omitempty"`
+	FilePermissions             string            `json:"file_permissions,omitempty"`
+	UpdateCmd                   string            `json:"update_cmd,omitempty"`
+	UpdateAction                *UpdateAction     `json:"update_action,omitempty"`
+	ConfigTest                  *ConfigTest       `json:"config_test,omitempty"`
+	Caddy                       *CaddyTarget      `json:"caddy,omitempty"`
+	Traefik                     *TraefikTarget    `json:"traefik,omitempty"`
+	Kubernetes                  *KubernetesTarget `json:"kubernetes,omitempty"`
+	Vault                       *VaultTarget      `json:"vault,omitempty"`
+	SSH                         *SSHTarget        `json:"ssh,omitempty"`
+	Name                        string            `json:"name,omitempty"`
+	AllInOne                    bool              `json:"all_in_one,omitempty"`
+	IsPfx                       bool              `json:"is_pfx"`
+	ConfigType                  string            `json:"config_type"`
+}
+
+// UpdateAction is a built-in alternative to UpdateCmd for reloading whatever
+// uses the certificate. Type selects the action; the remaining fields apply to
+// the types that use them.
+//
+// The docker-* types act on the container named by Container, or otherwise on
+// every running container labelled Label (default
+// "io.certkit.reload=<config_id>").
+//
+// The signal type sends Signal to the process found by Pidfile, by exact
+// ProcessName, or by Executable path.
+//
+// The systemd type runs Operation ("reload", "restart" or
+// "try-reload-or-restart") on Unit over the system D-Bus.
+//
+// The haproxy-runtime type loads the written PEM into HAProxy through the
+// stats (or, with Master, master) CLI at Socket, adding certificates HAProxy
+// does not know yet to CrtList. If it fails, UpdateCmd runs instead.
+type UpdateAction struct {
+	Type           string   `json:"type"`
+	Container      string   `json:"container,omitempty"`
+	Label          string   `json:"label,omitempty"`
+	Pidfile        string   `json:"pidfile,omitempty"`
+	ProcessName    string   `json:"process_name,omitempty"`
+	Executable     string   `json:"executable,omitempty"`
+	Signal         string   `json:"signal,omitempty"`
+	Command        []string `json:"command,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+	DockerSocket   string   `json:"docker_socket,omitempty"`
+	Unit           string   `json:"unit,omitempty"`
+	Operation      string   `json:"operation,omitempty"`
+	Socket         string   `json:"socket,omitempty"`
+	Master         bool     `json:"master,omitempty"`
+	CrtList        string   `json:"crt_list,omitempty"`
+}
+
+// ConfigTest runs the server's own config check after new certificate files
+// are written and before the update runs; if it fails the files are rolled
+// back. Server ("nginx", "apache", "haproxy", "litespeed") defaults to the
+// configuration's ConfigType and selects the built-in test command. Binary and
+// Args override that command.
+type ConfigTest struct {
+	Server         string   `json:"server,omitempty"`
+	Binary         string   `json:"binary,omitempty"`
+	Args           []string `json:"args,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+}
+
+// CaddyTarget applies to configurations of type "caddy": after the files are
+// written the certificate is loaded into Caddy through its admin API at
+// AdminAddress (default "http://localhost:2019", or "unix//path/to.sock").
+type CaddyTarget struct {
+	AdminAddress string `json:"admin_address,omitempty"`
+}
+
+// TraefikTarget applies to configurations of type "traefik": the agent
+// renders a dynamic configuration file at DynamicConfigPath (default
+// "/etc/traefik/dynamic/certkit-<config_id>.yml") listing the written files,
+// for Traefik's file provider to pick up. Stores names the TLS stores the
+// certificate is added to.
+type TraefikTarget struct {
+	DynamicConfigPath string   `json:"dynamic_config_path,omitempty"`
+	Stores            []string `json:"stores,omitempty"`
+}
+
+// KubernetesTarget applies to configurations of type "k8s-secret": the
+// certificate is kept in a kubernetes.io/tls Secret named SecretName in
+// Namespace (default: the namespace of the service account or kubeconfig
+// context) instead of in files. IncludeCA adds the chain as ca.crt. The API
+// server is reached with the in-cluster service account, or with Kubeconfig
+// and Context when set.
+type KubernetesTarget struct {
+	Namespace  string            `json:"namespace,omitempty"`
+	SecretName string            `json:"secret_name"`
+	IncludeCA  bool              `json:"include_ca,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Kubeconfig string            `json:"kubeconfig,omitempty"`
+	Context    string            `json:"context,omitempty"`
+}
+
+// VaultTarget applies to configurations of type "vault-kv": the certificate,
+// key and chain are written to Path in the KV version 2 engine mounted at
+// Mount (default "secret"). The field names default to "certificate",
+// "private_key" and "ca_chain"; other fields in the secret are kept. Address
+// and CACert default to $VAULT_ADDR and $VAULT_CACERT.
+type VaultTarget struct {
+	Address          string    `json:"address,omitempty"`
+	Namespace        string    `json:"namespace,omitempty"`
+	CACert           string    `json:"ca_cert,omitempty"`
+	Mount            string    `json:"mount,omitempty"`
+	Path             string    `json:"path"`
+	CertificateField string    `json:"certificate_field,omitempty"`
+	KeyField         string    `json:"key_field,omitempty"`
+	ChainField       string    `json:"chain_field,omitempty"`
+	Auth             VaultAuth `json:"auth"`
+}
+
+// VaultAuth is how the agent logs in to Vault. Method "token" reads a token
+// from TokenFile (default $VAULT_TOKEN, then ~/.vault-token). "approle" logs
+// in with RoleId and the secret id in SecretIdFile. "kubernetes" logs in as
+// Role with the service account token in JwtFile (default the pod's). Mount
+// is where the auth method is enabled (default "approle" or "kubernetes").
+type VaultAuth struct {
+	Method       string `json:"method"`
+	TokenFile    string `json:"token_file,omitempty"`
+	Mount        string `json:"mount,omitempty"`
+	RoleId       string `json:"role_id,omitempty"`
+	SecretIdFile string `json:"secret_id_file,omitempty"`
+	Role         string `json:"role,omitempty"`
+	JwtFile      string `json:"jwt_file,omitempty"`
+}
+
+// SSHTarget applies to configurations of type "ssh": the agent pushes the
+// certificate over SFTP to Host ("host" or "host:port") as User, and
+// PemDestination, KeyDestination, ChainDestination and UpdateCmd refer to
+// that host. IdentityFile is the private key to log in with. HostKey pins
+// the server's key, either as an authorized_keys line ("ssh-ed25519 AAAA...")
+// or as a "SHA256:..." fingerprint.
+type SSHTarget struct {
+	Host           string `json:"host"`
+	User           string `json:"user"`
+	IdentityFile   string `json:"identity_file"`
+	HostKey        string `json:"host_key"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+// MetricsConfig enables the local Prometheus endpoint. ListenAddress is a
+// loopback "host:port" or "unix:/path/to.sock".
+type MetricsConfig struct {
+	ListenAddress string `json:"listen_address"`
+}
+
+// InventoryConfig tunes inventory discovery. ExtraConfigPaths maps a provider
+// name ("nginx", "apache", "haproxy", "litespeed", "caddy", "traefik") to
+// additional config file globs it should read. DockerMounts adds mount points
+// to the ones the docker and docker-engine providers scan. DockerSocket
+// overrides the Docker/Podman Engine API socket the docker-engine provider
+// uses. ExcludePaths are globs; matching files and anything below matching
+// directories are skipped.
+type InventoryConfig struct {
+	DisabledProviders []string              `json:"disabled_providers,omitempty"`
+	ExtraConfigPaths  map[string][]string   `json:"extra_config_paths,omitempty"`
+	DockerMounts      []string              `json:"docker_mounts,omitempty"`
+	DockerSocket      string                `json:"docker_socket,omitempty"`
+	ExcludePaths      []string              `json:"exclude_paths,omitempty"`
+	Filesystem        *FilesystemScanConfig `json:"filesystem,omitempty"`
+	Kubernetes        *KubernetesScanConfig `json:"kubernetes,omitempty"`
+	TLSProbe          *TLSProbeScanConfig   `json:"tls_probe,omitempty"`
+}
+
+// FilesystemScanConfig controls the directory scan for certificate files.
+// Roots are globs added to the default roots; zero limits use the defaults.
+type FilesystemScanConfig struct {
+	Roots       []string `json:"roots,omitempty"`
+	MaxDepth    int      `json:"max_depth,omitempty"`
+	MaxFileSize int64    `json:"max_file_size,omitempty"`
+	MaxFiles    int      `json:"max_files,omitempty"`
+}
+
+// KubernetesScanConfig controls the Kubernetes provider. Namespaces limits it
+// to the listed namespaces (default: all); ExcludeNamespaces are skipped.
+// Kubeconfig and Context select the cluster; without Kubeconfig only the
+// service account of the pod the agent runs in is used.
+type KubernetesScanConfig struct {
+	Namespaces        []string `json:"namespaces,omitempty"`
+	ExcludeNamespaces []string `json:"exclude_namespaces,omitempty"`
+	Kubeconfig        string   `json:"kubeconfig,omitempty"`
+	Context           string   `json:"context,omitempty"`
+}
+
+// TLSProbeScanConfig controls the TLS probe of listening sockets. SkipPorts
+// are not probed, in addition to the ports of well-known non-TLS services.
+type TLSProbeScanConfig struct {
+	SkipPorts []int `json:"skip_ports,omitempty"`
 }
 
 type VersionInfo struct {
@@ -0,0 +1,161 @@
+// Package control implements the local control socket the running agent
+// serves, and the client used by the status/sync/inventory subcommands.
+//
+// Each connection carries one JSON request and one JSON response. Access is
+// governed by the socket file's permissions, which are restricted to the
+// owner (normally root) when the socket is created.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const (
+	CommandStatus    = "status"
+	CommandSync      = "sync"
+	CommandInventory = "inventory"
+
+	DefaultSocketName = "control.sock"
+)
+
+type Request struct {
+	Command  string `json:"command"`
+	ConfigId string `json:"config_id,omitempty"`
+	Send     bool   `json:"send,omitempty"`
+}
+
+type Response struct {
+	Error     string                        `json:"error,omitempty"`
+	Status    *StatusReport                 `json:"status,omitempty"`
+	Statuses  []api.AgentConfigStatusUpdate `json:"statuses,omitempty"`
+	Inventory []api.InventoryItem           `json:"inventory,omitempty"`
+
+	InventoryErrors []api.InventoryError `json:"inventory_errors,omitempty"`
+}
+
+type StatusReport struct {
+	AgentId            string         `json:"agent_id"`
+	Version            string         `json:"version"`
+	Authorized         bool           `json:"authorized"`
+	LastPoll           *time.Time     `json:"last_poll,omitempty"`
+	LastSuccessfulPoll *time.Time     `json:"last_successful_poll,omitempty"`
+	LastPollError      string         `json:"last_poll_error,omitempty"`
+	LastInventorySent  *time.Time     `json:"last_inventory_sent,omitempty"`
+	Configs            []ConfigStatus `json:"configs"`
+}
+
+type ConfigStatus struct {
+	ConfigId       string     `json:"config_id"`
+	Name           string     `json:"name,omitempty"`
+	ConfigType     string     `json:"config_type,omitempty"`
+	CertificateId  string     `json:"certificate_id,omitempty"`
+	LastStatus     string     `json:"last_status,omitempty"`
+	DeployedExpiry *time.Time `json:"deployed_expiry,omitempty"`
+}
+
+// Handler answers a single control request.
+type Handler func(ctx context.Context, req Request) Response
+
+// DefaultSocketPath places the control socket next to the agent's config file
+// so each agent instance gets its own socket.
+func DefaultSocketPath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), DefaultSocketName)
+}
+
+// Listen creates the control socket, replacing a stale one left behind by a
+// previous run, and restricts it to the owning user.
+func Listen(socketPath string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
+		return nil, err
+	}
+	if err := utils.RemoveStaleSocket(socketPath); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// On Windows the socket inherits the config directory's ACL instead.
+	if runtime.GOOS == "windows" {
+		return listener, nil
+	}
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("chmod %s: %w", socketPath, err)
+	}
+	return listener, nil
+}
+
+// Serve accepts control connections until ctx is cancelled.
+func Serve(ctx context.Context, listener net.Listener, handler Handler) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Control socket accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go serveConn(ctx, conn, handler)
+	}
+}
+
+func serveConn(ctx context.Context, conn net.Conn, handler Handler) {
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		_ = json.NewEncoder(conn).Encode(Response{Error: fmt.Sprintf("decode request: %v", err)})
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	resp := handler(ctx, req)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("Control socket write error: %v", err)
+	}
+}
+
+// Call sends req to the agent listening on socketPath and waits for its reply.
+// Sync and inventory requests can take as long as the work they trigger.
+func Call(socketPath string, req Request, timeout time.Duration) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("connect to agent control socket %s (is the agent running?): %w", socketPath, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
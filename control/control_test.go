@@ -0,0 +1,92 @@
+package control
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestListenRestrictsSocketToOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket permissions come from the directory ACL on Windows")
+	}
+	socketPath := filepath.Join(t.TempDir(), "run", DefaultSocketName)
+
+	// A socket left behind by a previous run is replaced.
+	for range 2 {
+		listener, err := Listen(socketPath)
+		if err != nil {
+			t.Fatalf("Listen() error: %v", err)
+		}
+		info, err := os.Stat(socketPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+			t.Errorf("socket mode = %v, want 0600 socket", info.Mode())
+		}
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		listener.Close()
+	}
+}
+
+func TestListenKeepsOtherFiles(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(socketPath, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(socketPath); err == nil {
+		t.Fatal("Listen() on a regular file expected error")
+	}
+	if data, err := os.ReadFile(socketPath); err != nil || string(data) != "{}" {
+		t.Fatalf("regular file was modified: %q, %v", data, err)
+	}
+}
+
+func TestServeAndCall(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), DefaultSocketName)
+	listener, err := Listen(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan struct{})
+	go func() {
+		Serve(ctx, listener, func(_ context.Context, req Request) Response {
+			switch req.Command {
+			case CommandStatus:
+				return Response{Status: &StatusReport{AgentId: "agent1"}}
+			case CommandSync:
+				return Response{Error: "sync " + req.ConfigId + " failed"}
+			}
+			return Response{Error: "unknown command"}
+		})
+		close(served)
+	}()
+
+	resp, err := Call(socketPath, Request{Command: CommandStatus}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Call(status) error: %v", err)
+	}
+	if resp.Status == nil || resp.Status.AgentId != "agent1" {
+		t.Errorf("status = %+v, want agent1", resp.Status)
+	}
+
+	if _, err := Call(socketPath, Request{Command: CommandSync, ConfigId: "cfg1"}, 5*time.Second); err == nil || err.Error() != "sync cfg1 failed" {
+		t.Errorf("Call(sync) error = %v, want the handler's error", err)
+	}
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after cancellation")
+	}
+	if _, err := Call(socketPath, Request{Command: CommandStatus}, time.Second); err == nil {
+		t.Error("Call() after shutdown expected error")
+	}
+}
@@ -0,0 +1,380 @@
+// Package dbus is a minimal D-Bus client: enough of the wire protocol to call
+// methods, receive signals and serve simple method calls, without cgo or
+// external libraries.
+package dbus
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	BusName      = "org.freedesktop.DBus"
+	BusPath      = ObjectPath("/org/freedesktop/DBus")
+	BusInterface = "org.freedesktop.DBus"
+
+	PropertiesInterface = "org.freedesktop.DBus.Properties"
+
+	defaultSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+	signalBuffer            = 64
+)
+
+var ErrClosed = errors.New("dbus: connection closed")
+
+// MethodHandler answers a method call addressed to this connection. The
+// returned values form the reply body; an *Error is sent as an error reply.
+type MethodHandler func(call *Message) ([]any, error)
+
+type Conn struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	serial  uint32
+
+	mu      sync.Mutex
+	pending map[uint32]chan *Message
+	handler MethodHandler
+	closed  bool
+	err     error
+
+	signals chan *Message
+	done    chan struct{}
+	name    string
+}
+
+// SystemBus connects to the system bus named by DBUS_SYSTEM_BUS_ADDRESS, or
+// the standard socket.
+func SystemBus() (*Conn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = defaultSystemBusAddress
+	}
+	return Dial(address)
+}
+
+// Dial connects to a bus address such as "unix:path=/run/dbus/system_bus_socket",
+// authenticates, and registers with the bus.
+func Dial(address string) (*Conn, error) {
+	network, target, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	netConn, err := net.Dial(network, target)
+	if err != nil {
+		return nil, err
+	}
+	if err := authenticate(netConn); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:    netConn,
+		pending: make(map[uint32]chan *Message),
+		signals: make(chan *Message, signalBuffer),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+
+	reply, err := c.Call(context.Background(), BusName, BusPath, BusInterface, "Hello")
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("dbus: hello: %w", err)
+	}
+	if len(reply) > 0 {
+		c.name, _ = reply[0].(string)
+	}
+	return c, nil
+}
+
+// parseAddress picks the first unix transport from a server address list.
+func parseAddress(address string) (string, string, error) {
+	for _, entry := range strings.Split(address, ";") {
+		transport, params, ok := strings.Cut(entry, ":")
+		if !ok || transport != "unix" {
+			continue
+		}
+		for _, param := range strings.Split(params, ",") {
+			key, value, _ := strings.Cut(param, "=")
+			switch key {
+			case "path":
+				return "unix", unescapeAddress(value), nil
+			case "abstract":
+				return "unix", "@" + unescapeAddress(value), nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("dbus: unsupported address %q", address)
+}
+
+func unescapeAddress(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '%' && i+2 < len(value) {
+			if n, err := strconv.ParseUint(value[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// authenticate performs the SASL EXTERNAL handshake with the caller's uid.
+func authenticate(conn net.Conn) error {
+	uid := strconv.Itoa(os.Getuid())
+	if _, err := fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", hex.EncodeToString([]byte(uid))); err != nil {
+		return err
+	}
+	line, err := readLine(conn)
+	if err != nil {
+		return fmt.Errorf("dbus: auth: %w", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("dbus: auth rejected: %s", line)
+	}
+	_, err = io.WriteString(conn, "BEGIN\r\n")
+	return err
+}
+
+// readLine reads one CRLF-terminated line byte by byte, so nothing after it
+// is consumed from the connection.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := r.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+		if len(line) > 4096 {
+			return "", errors.New("auth line too long")
+		}
+	}
+}
+
+// UniqueName is the name the bus assigned to this connection.
+func (c *Conn) UniqueName() string {
+	return c.name
+}
+
+// Signals delivers signals matched by AddMatch rules. Signals are dropped if
+// the channel is not drained.
+func (c *Conn) Signals() <-chan *Message {
+	return c.signals
+}
+
+// Handle sets the handler for method calls addressed to this connection.
+func (c *Conn) Handle(handler MethodHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handler = handler
+}
+
+// Call invokes a method and waits for its reply.
+func (c *Conn) Call(ctx context.Context, destination string, path ObjectPath, iface string, member string, args ...any) ([]any, error) {
+	msg := &Message{
+		Type:        TypeMethodCall,
+		Path:        path,
+		Interface:   iface,
+		Member:      member,
+		Destination: destination,
+		Body:        args,
+	}
+	reply := make(chan *Message, 1)
+	serial, err := c.send(msg, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, serial)
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.closeErr()
+	case response := <-reply:
+		if response.Type == TypeError {
+			dbusErr := &Error{Name: response.ErrorName}
+			if len(response.Body) > 0 {
+				dbusErr.Message, _ = response.Body[0].(string)
+			}
+			return nil, dbusErr
+		}
+		return response.Body, nil
+	}
+}
+
+// AddMatch asks the bus to route matching messages, typically signals, to
+// this connection.
+func (c *Conn) AddMatch(ctx context.Context, rule string) error {
+	_, err := c.Call(ctx, BusName, BusPath, BusInterface, "AddMatch", rule)
+	return err
+}
+
+// RequestName claims a well-known bus name.
+func (c *Conn) RequestName(ctx context.Context, name string) error {
+	reply, err := c.Call(ctx, BusName, BusPath, BusInterface, "RequestName", name, uint32(0))
+	if err != nil {
+		return err
+	}
+	// 1 = primary owner, 4 = already owner.
+	if len(reply) == 0 || (reply[0] != uint32(1) && reply[0] != uint32(4)) {
+		return fmt.Errorf("dbus: could not acquire name %s", name)
+	}
+	return nil
+}
+
+// Emit broadcasts a signal.
+func (c *Conn) Emit(path ObjectPath, iface string, member string, args ...any) error {
+	_, err := c.send(&Message{Type: TypeSignal, Flags: flagNoReplyExpected, Path: path, Interface: iface, Member: member, Body: args}, nil)
+	return err
+}
+
+// GetProperty reads a property through org.freedesktop.DBus.Properties and
+// unwraps the variant.
+func (c *Conn) GetProperty(ctx context.Context, destination string, path ObjectPath, iface string, property string) (any, error) {
+	reply, err := c.Call(ctx, destination, path, PropertiesInterface, "Get", iface, property)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) == 0 {
+		return nil, errors.New("dbus: empty property reply")
+	}
+	variant, ok := reply[0].(Variant)
+	if !ok {
+		return nil, fmt.Errorf("dbus: property reply is %T, not a variant", reply[0])
+	}
+	return variant.Value, nil
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *Conn) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Conn) send(msg *Message, reply chan *Message) (uint32, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.serial++
+	serial := c.serial
+	data, err := msg.encode(serial)
+	if err != nil {
+		return 0, err
+	}
+	if reply != nil {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, c.err
+		}
+		c.pending[serial] = reply
+		c.mu.Unlock()
+	}
+	if _, err := c.conn.Write(data); err != nil {
+		c.mu.Lock()
+		delete(c.pending, serial)
+		c.mu.Unlock()
+		return 0, err
+	}
+	return serial, nil
+}
+
+func (c *Conn) readLoop() {
+	reader := bufio.NewReader(c.conn)
+	defer close(c.done)
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = err
+			}
+			c.closed = true
+			c.mu.Unlock()
+			c.conn.Close()
+			return
+		}
+
+		switch msg.Type {
+		case TypeMethodReturn, TypeError:
+			c.mu.Lock()
+			reply, ok := c.pending[msg.ReplySerial]
+			delete(c.pending, msg.ReplySerial)
+			c.mu.Unlock()
+			if ok {
+				reply <- msg
+			}
+		case TypeSignal:
+			select {
+			case c.signals <- msg:
+			default:
+			}
+		case TypeMethodCall:
+			go c.serve(msg)
+		}
+	}
+}
+
+func (c *Conn) serve(call *Message) {
+	c.mu.Lock()
+	handler := c.handler
+	c.mu.Unlock()
+
+	var body []any
+	err := error(&Error{Name: "org.freedesktop.DBus.Error.UnknownMethod", Message: "no handler"})
+	if handler != nil {
+		body, err = handler(call)
+	}
+	if call.Flags&flagNoReplyExpected != 0 {
+		return
+	}
+
+	reply := &Message{Type: TypeMethodReturn, ReplySerial: call.Serial, Destination: call.Sender, Body: body}
+	if err != nil {
+		var dbusErr *Error
+		if !errors.As(err, &dbusErr) {
+			dbusErr = &Error{Name: "org.freedesktop.DBus.Error.Failed", Message: err.Error()}
+		}
+		reply = &Message{
+			Type:        TypeError,
+			ErrorName:   dbusErr.Name,
+			ReplySerial: call.Serial,
+			Destination: call.Sender,
+			Body:        []any{dbusErr.Message},
+		}
+	}
+	_, _ = c.send(reply, nil)
+}
@@ -0,0 +1,622 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Message types.
+const (
+	TypeMethodCall   byte = 1
+	TypeMethodReturn byte = 2
+	TypeError        byte = 3
+	TypeSignal       byte = 4
+)
+
+const (
+	flagNoReplyExpected byte = 0x1
+
+	fieldPath        byte = 1
+	fieldInterface   byte = 2
+	fieldMember      byte = 3
+	fieldErrorName   byte = 4
+	fieldReplySerial byte = 5
+	fieldDestination byte = 6
+	fieldSender      byte = 7
+	fieldSignature   byte = 8
+
+	maxMessageSize = 128 * 1024 * 1024
+)
+
+// ObjectPath is a D-Bus object path ("o").
+type ObjectPath string
+
+// Signature is a D-Bus type signature ("g").
+type Signature string
+
+// Variant is a value tagged with its signature ("v").
+type Variant struct {
+	Signature Signature
+	Value     any
+}
+
+// MakeVariant wraps a value whose signature can be inferred.
+func MakeVariant(value any) Variant {
+	sig, _ := signatureOf(value)
+	return Variant{Signature: sig, Value: value}
+}
+
+// Message is one D-Bus message. Body values use byte, bool, int16, uint16,
+// int32, uint32, int64, uint64, float64, string, ObjectPath, Signature and
+// Variant; arrays decode as []any (or []string when built), and structs as
+// []any.
+type Message struct {
+	Type        byte
+	Flags       byte
+	Serial      uint32
+	Path        ObjectPath
+	Interface   string
+	Member      string
+	ErrorName   string
+	ReplySerial uint32
+	Destination string
+	Sender      string
+	Body        []any
+}
+
+// Error is an error reply.
+type Error struct {
+	Name    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+func signatureOf(value any) (Signature, error) {
+	switch v := value.(type) {
+	case byte:
+		return "y", nil
+	case bool:
+		return "b", nil
+	case int16:
+		return "n", nil
+	case uint16:
+		return "q", nil
+	case int32:
+		return "i", nil
+	case uint32:
+		return "u", nil
+	case int64:
+		return "x", nil
+	case uint64:
+		return "t", nil
+	case float64:
+		return "d", nil
+	case string:
+		return "s", nil
+	case ObjectPath:
+		return "o", nil
+	case Signature:
+		return "g", nil
+	case Variant:
+		return "v", nil
+	case []string:
+		return "as", nil
+	case []ObjectPath:
+		return "ao", nil
+	default:
+		return "", fmt.Errorf("dbus: unsupported type %T", v)
+	}
+}
+
+// encoder writes values at offsets relative to the start of the message, as
+// alignment requires.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	_ = binary.Write(&e.buf, binary.LittleEndian, v)
+}
+
+func (e *encoder) value(sig string, value any) (string, error) {
+	if sig == "" {
+		return "", errors.New("dbus: empty signature")
+	}
+	switch sig[0] {
+	case 'y':
+		v, ok := value.(byte)
+		if !ok {
+			return "", typeError(sig, value)
+		}
+		e.buf.WriteByte(v)
+	case 'b':
+		v, ok := value.(bool)
+		if !ok {
+			return "", typeError(sig, value)
+		}
+		var n uint32
+		if v {
+			n = 1
+		}
+		e.uint32(n)
+	case 'n', 'q':
+		e.align(2)
+		switch v := value.(type) {
+		case int16:
+			_ = binary.Write(&e.buf, binary.LittleEndian, v)
+		case uint16:
+			_ = binary.Write(&e.buf, binary.LittleEndian, v)
+		default:
+			return "", typeError(sig, value)
+		}
+	case 'i', 'u':
+		e.align(4)
+		switch v := value.(type) {
+		case int32:
+			_ = binary.Write(&e.buf, binary.LittleEndian, v)
+		case uint32:
+			_ = binary.Write(&e.buf, binary.LittleEndian, v)
+		default:
+			return "", typeError(sig, value)
+		}
+	case 'x', 't', 'd':
+		e.align(8)
+		switch v := value.(type) {
+		case int64, uint64, float64:
+			_ = binary.Write(&e.buf, binary.LittleEndian, v)
+		default:
+			return "", typeError(sig, value)
+		}
+	case 's', 'o':
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case ObjectPath:
+			s = string(v)
+		default:
+			return "", typeError(sig, value)
+		}
+		e.uint32(uint32(len(s)))
+		e.buf.WriteString(s)
+		e.buf.WriteByte(0)
+	case 'g':
+		v, ok := value.(Signature)
+		if !ok {
+			return "", typeError(sig, value)
+		}
+		e.buf.WriteByte(byte(len(v)))
+		e.buf.WriteString(string(v))
+		e.buf.WriteByte(0)
+	case 'v':
+		v, ok := value.(Variant)
+		if !ok {
+			return "", typeError(sig, value)
+		}
+		if _, err := e.value("g", v.Signature); err != nil {
+			return "", err
+		}
+		if _, err := e.value(string(v.Signature), v.Value); err != nil {
+			return "", err
+		}
+	case 'a':
+		elemSig, err := nextSignature(sig[1:])
+		if err != nil {
+			return "", err
+		}
+		var items []any
+		switch v := value.(type) {
+		case []any:
+			items = v
+		case []string:
+			for _, s := range v {
+				items = append(items, s)
+			}
+		case []ObjectPath:
+			for _, s := range v {
+				items = append(items, s)
+			}
+		default:
+			return "", typeError(sig, value)
+		}
+		e.uint32(0)
+		lengthAt := e.buf.Len() - 4
+		e.align(alignment(elemSig[0]))
+		start := e.buf.Len()
+		for _, item := range items {
+			if _, err := e.value(elemSig, item); err != nil {
+				return "", err
+			}
+		}
+		binary.LittleEndian.PutUint32(e.buf.Bytes()[lengthAt:], uint32(e.buf.Len()-start))
+		return sig[1+len(elemSig):], nil
+	case '(', '{':
+		fields, ok := value.([]any)
+		if !ok {
+			return "", typeError(sig, value)
+		}
+		e.align(8)
+		rest := sig[1:]
+		for _, field := range fields {
+			fieldSig, err := nextSignature(rest)
+			if err != nil {
+				return "", err
+			}
+			if _, err := e.value(fieldSig, field); err != nil {
+				return "", err
+			}
+			rest = rest[len(fieldSig):]
+		}
+		if rest == "" || (rest[0] != ')' && rest[0] != '}') {
+			return "", fmt.Errorf("dbus: struct fields do not match %q", sig)
+		}
+		return rest[1:], nil
+	default:
+		return "", fmt.Errorf("dbus: unsupported signature %q", sig)
+	}
+	return sig[1:], nil
+}
+
+func typeError(sig string, value any) error {
+	return fmt.Errorf("dbus: cannot encode %T as %q", value, sig[:1])
+}
+
+func alignment(code byte) int {
+	switch code {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 1
+}
+
+// nextSignature returns the first complete type in sig.
+func nextSignature(sig string) (string, error) {
+	if sig == "" {
+		return "", errors.New("dbus: incomplete signature")
+	}
+	switch sig[0] {
+	case 'a':
+		elem, err := nextSignature(sig[1:])
+		if err != nil {
+			return "", err
+		}
+		return sig[:1+len(elem)], nil
+	case '(', '{':
+		closing := byte(')')
+		if sig[0] == '{' {
+			closing = '}'
+		}
+		i := 1
+		for i < len(sig) && sig[i] != closing {
+			elem, err := nextSignature(sig[i:])
+			if err != nil {
+				return "", err
+			}
+			i += len(elem)
+		}
+		if i >= len(sig) {
+			return "", fmt.Errorf("dbus: unterminated signature %q", sig)
+		}
+		return sig[:i+1], nil
+	}
+	return sig[:1], nil
+}
+
+// encode serializes m with the given serial.
+func (m *Message) encode(serial uint32) ([]byte, error) {
+	var sig Signature
+	for _, value := range m.Body {
+		valueSig, err := signatureOf(value)
+		if err != nil {
+			if fields, ok := value.([]any); ok {
+				return nil, fmt.Errorf("dbus: cannot infer signature of struct %v", fields)
+			}
+			return nil, err
+		}
+		sig += valueSig
+	}
+
+	var body encoder
+	rest := string(sig)
+	for _, value := range m.Body {
+		var err error
+		if rest, err = body.value(rest, value); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := make([]any, 0, 8)
+	addField := func(code byte, value any) {
+		fields = append(fields, []any{code, MakeVariant(value)})
+	}
+	if m.Path != "" {
+		addField(fieldPath, m.Path)
+	}
+	if m.Interface != "" {
+		addField(fieldInterface, m.Interface)
+	}
+	if m.Member != "" {
+		addField(fieldMember, m.Member)
+	}
+	if m.ErrorName != "" {
+		addField(fieldErrorName, m.ErrorName)
+	}
+	if m.ReplySerial != 0 {
+		addField(fieldReplySerial, m.ReplySerial)
+	}
+	if m.Destination != "" {
+		addField(fieldDestination, m.Destination)
+	}
+	if m.Sender != "" {
+		addField(fieldSender, m.Sender)
+	}
+	if sig != "" {
+		addField(fieldSignature, sig)
+	}
+
+	var header encoder
+	header.buf.Write([]byte{'l', m.Type, m.Flags, 1})
+	header.uint32(uint32(body.buf.Len()))
+	header.uint32(serial)
+	if _, err := header.value("a(yv)", fields); err != nil {
+		return nil, err
+	}
+	header.align(8)
+	return append(header.buf.Bytes(), body.buf.Bytes()...), nil
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *decoder) align(n int) error {
+	for d.pos%n != 0 {
+		d.pos++
+	}
+	if d.pos > len(d.data) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *decoder) value(sig string) (any, string, error) {
+	if sig == "" {
+		return nil, "", errors.New("dbus: empty signature")
+	}
+	code := sig[0]
+	if err := d.align(alignment(code)); err != nil {
+		return nil, "", err
+	}
+	switch code {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, "", err
+		}
+		return b[0], sig[1:], nil
+	case 'b':
+		v, err := d.uint32()
+		return v != 0, sig[1:], err
+	case 'n', 'q':
+		b, err := d.take(2)
+		if err != nil {
+			return nil, "", err
+		}
+		if code == 'n' {
+			return int16(d.order.Uint16(b)), sig[1:], nil
+		}
+		return d.order.Uint16(b), sig[1:], nil
+	case 'i':
+		v, err := d.uint32()
+		return int32(v), sig[1:], err
+	case 'u':
+		v, err := d.uint32()
+		return v, sig[1:], err
+	case 'x', 't', 'd':
+		b, err := d.take(8)
+		if err != nil {
+			return nil, "", err
+		}
+		v := d.order.Uint64(b)
+		switch code {
+		case 'x':
+			return int64(v), sig[1:], nil
+		case 't':
+			return v, sig[1:], nil
+		}
+		var f float64
+		_ = binary.Read(bytes.NewReader(b), d.order, &f)
+		return f, sig[1:], nil
+	case 's', 'o':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := d.take(int(n) + 1)
+		if err != nil {
+			return nil, "", err
+		}
+		if code == 'o' {
+			return ObjectPath(b[:n]), sig[1:], nil
+		}
+		return string(b[:n]), sig[1:], nil
+	case 'g':
+		n, err := d.take(1)
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := d.take(int(n[0]) + 1)
+		if err != nil {
+			return nil, "", err
+		}
+		return Signature(b[:n[0]]), sig[1:], nil
+	case 'v':
+		inner, _, err := d.value("g")
+		if err != nil {
+			return nil, "", err
+		}
+		innerSig := inner.(Signature)
+		value, _, err := d.value(string(innerSig))
+		if err != nil {
+			return nil, "", err
+		}
+		return Variant{Signature: innerSig, Value: value}, sig[1:], nil
+	case 'a':
+		elemSig, err := nextSignature(sig[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		n, err := d.uint32()
+		if err != nil {
+			return nil, "", err
+		}
+		if err := d.align(alignment(elemSig[0])); err != nil {
+			return nil, "", err
+		}
+		end := d.pos + int(n)
+		if end > len(d.data) {
+			return nil, "", io.ErrUnexpectedEOF
+		}
+		items := make([]any, 0)
+		for d.pos < end {
+			item, _, err := d.value(elemSig)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+		}
+		return items, sig[1+len(elemSig):], nil
+	case '(', '{':
+		structSig, err := nextSignature(sig)
+		if err != nil {
+			return nil, "", err
+		}
+		rest := structSig[1 : len(structSig)-1]
+		fields := make([]any, 0)
+		for rest != "" {
+			var field any
+			if field, rest, err = d.value(rest); err != nil {
+				return nil, "", err
+			}
+			fields = append(fields, field)
+		}
+		return fields, sig[len(structSig):], nil
+	}
+	return nil, "", fmt.Errorf("dbus: unsupported signature %q", sig)
+}
+
+// readMessage reads one message from r.
+func readMessage(r io.Reader) (*Message, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("dbus: invalid endianness %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:])
+	fieldsLen := order.Uint32(fixed[12:])
+	headerLen := 16 + int(fieldsLen)
+	padded := (headerLen + 7) &^ 7
+	total := padded + int(bodyLen)
+	if fieldsLen > maxMessageSize || bodyLen > maxMessageSize || total > maxMessageSize {
+		return nil, errors.New("dbus: message too large")
+	}
+
+	data := make([]byte, total)
+	copy(data, fixed)
+	if _, err := io.ReadFull(r, data[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &Message{Type: fixed[1], Flags: fixed[2], Serial: order.Uint32(fixed[8:])}
+	header := &decoder{data: data[:headerLen], pos: 12, order: order}
+	fieldsValue, _, err := header.value("a(yv)")
+	if err != nil {
+		return nil, fmt.Errorf("dbus: header: %w", err)
+	}
+	var sig Signature
+	for _, raw := range fieldsValue.([]any) {
+		field := raw.([]any)
+		variant := field[1].(Variant)
+		switch field[0].(byte) {
+		case fieldPath:
+			m.Path, _ = variant.Value.(ObjectPath)
+		case fieldInterface:
+			m.Interface, _ = variant.Value.(string)
+		case fieldMember:
+			m.Member, _ = variant.Value.(string)
+		case fieldErrorName:
+			m.ErrorName, _ = variant.Value.(string)
+		case fieldReplySerial:
+			m.ReplySerial, _ = variant.Value.(uint32)
+		case fieldDestination:
+			m.Destination, _ = variant.Value.(string)
+		case fieldSender:
+			m.Sender, _ = variant.Value.(string)
+		case fieldSignature:
+			sig, _ = variant.Value.(Signature)
+		}
+	}
+
+	// Body offsets are relative to the start of the body, which is 8-byte
+	// aligned, so decoding from the padded header keeps alignment correct.
+	body := &decoder{data: data, pos: padded, order: order}
+	rest := string(sig)
+	for rest != "" {
+		var value any
+		if value, rest, err = body.value(rest); err != nil {
+			return nil, fmt.Errorf("dbus: body: %w", err)
+		}
+		m.Body = append(m.Body, value)
+	}
+	return m, nil
+}
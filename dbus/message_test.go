@@ -0,0 +1,62 @@
+package dbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := &Message{
+		Type:        TypeSignal,
+		Path:        "/org/freedesktop/systemd1",
+		Interface:   "org.freedesktop.systemd1.Manager",
+		Member:      "JobRemoved",
+		Destination: ":1.7",
+		Body: []any{
+			uint32(42),
+			ObjectPath("/org/freedesktop/systemd1/job/42"),
+			"nginx.service",
+			"done",
+			true,
+			MakeVariant("active"),
+			[]string{"a", "b"},
+		},
+	}
+	data, err := msg.encode(9)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got, err := readMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readMessage: %v", err)
+	}
+	if got.Serial != 9 || got.Type != TypeSignal || got.Member != "JobRemoved" || got.Path != msg.Path || got.Destination != ":1.7" {
+		t.Fatalf("header = %+v", got)
+	}
+	want := []any{
+		uint32(42),
+		ObjectPath("/org/freedesktop/systemd1/job/42"),
+		"nginx.service",
+		"done",
+		true,
+		Variant{Signature: "s", Value: "active"},
+		[]any{"a", "b"},
+	}
+	if !reflect.DeepEqual(got.Body, want) {
+		t.Errorf("body = %#v, want %#v", got.Body, want)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	network, target, err := parseAddress("tcp:host=x;unix:path=/run/dbus/system%5fbus_socket")
+	if err != nil || network != "unix" || target != "/run/dbus/system_bus_socket" {
+		t.Errorf("parseAddress = %q, %q, %v", network, target, err)
+	}
+	if _, target, _ := parseAddress("unix:abstract=/tmp/dbus-x,guid=1"); target != "@/tmp/dbus-x" {
+		t.Errorf("abstract target = %q", target)
+	}
+	if _, _, err := parseAddress("tcp:host=localhost,port=1"); err == nil {
+		t.Error("expected error for tcp address")
+	}
+}
@@ -0,0 +1,195 @@
+// Package dockerapi is a minimal client for the Docker Engine API, which
+// Podman also serves, over its local unix socket.
+package dockerapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultSocketPaths are tried in order when no socket is configured.
+var DefaultSocketPaths = []string{
+	"/var/run/docker.sock",
+	"/run/podman/podman.sock",
+}
+
+// ComposeProjectLabels name the compose project a container belongs to, for
+// Docker Compose and podman-compose respectively.
+var ComposeProjectLabels = []string{
+	"com.docker.compose.project",
+	"io.podman.compose.project",
+}
+
+type Client struct {
+	socketPath string
+	http       *http.Client
+}
+
+// NewClient returns a client for the engine at socketPath. Requests have no
+// timeout of their own; restarts and execs can run for as long as the
+// caller's context allows.
+func NewClient(socketPath string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &Client{
+		socketPath: socketPath,
+		http:       &http.Client{Transport: transport},
+	}
+}
+
+// FindSocket returns the configured socket path if set, otherwise the unix
+// socket named by DOCKER_HOST, otherwise the first default socket that
+// exists. It returns "" when there is no socket to talk to.
+func FindSocket(configured string) string {
+	if configured != "" {
+		return strings.TrimPrefix(configured, "unix://")
+	}
+	if host, ok := strings.CutPrefix(os.Getenv("DOCKER_HOST"), "unix://"); ok && host != "" {
+		return host
+	}
+	for _, path := range DefaultSocketPaths {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return path
+		}
+	}
+	return ""
+}
+
+func (c *Client) SocketPath() string {
+	return c.socketPath
+}
+
+// Error is a non-2xx response from the engine.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("docker api: status=%d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the engine, such as for an
+// unknown container.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+type Container struct {
+	Id     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+	Mounts []Mount           `json:"Mounts"`
+}
+
+// Name returns the container's primary name without the leading slash.
+func (c Container) Name() string {
+	if len(c.Names) == 0 {
+		return shortId(c.Id)
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// ComposeProject returns the compose project label, if any.
+func (c Container) ComposeProject() string {
+	for _, label := range ComposeProjectLabels {
+		if project := c.Labels[label]; project != "" {
+			return project
+		}
+	}
+	return ""
+}
+
+type Mount struct {
+	Type        string `json:"Type"`
+	Name        string `json:"Name,omitempty"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	RW          bool   `json:"RW"`
+}
+
+// ListContainers returns running containers matching filters, which use the
+// engine's filter syntax (for example {"label": {"io.certkit.reload=abc"}}).
+func (c *Client) ListContainers(ctx context.Context, filters map[string][]string) ([]Container, error) {
+	query := url.Values{}
+	if len(filters) > 0 {
+		encoded, err := json.Marshal(filters)
+		if err != nil {
+			return nil, fmt.Errorf("marshal filters: %w", err)
+		}
+		query.Set("filters", string(encoded))
+	}
+
+	var containers []Container
+	if err := c.do(ctx, http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body io.Reader, out any) error {
+	target := "http://docker" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+		message = apiErr.Message
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: message}
+}
+
+func shortId(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,47 @@
+package dockerapi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestListContainersFiltersAndErrors(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotFilters string
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotFilters = r.URL.Query().Get("filters")
+		if gotFilters == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"message":"engine unavailable"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"Id":"abc","Names":["/proxy"],"Labels":{"io.podman.compose.project":"edge"}}]`))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := NewClient(socket)
+	containers, err := client.ListContainers(context.Background(), map[string][]string{"label": {"io.certkit.reload=cfg1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotFilters != `{"label":["io.certkit.reload=cfg1"]}` {
+		t.Errorf("filters = %s", gotFilters)
+	}
+	if len(containers) != 1 || containers[0].Name() != "proxy" || containers[0].ComposeProject() != "edge" {
+		t.Errorf("containers = %+v", containers)
+	}
+
+	_, err = client.ListContainers(context.Background(), nil)
+	apiErr, ok := err.(*Error)
+	if !ok || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Message != "engine unavailable" {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package dockerapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContainerDetails is the subset of a container inspect response the agent
+// uses.
+type ContainerDetails struct {
+	Id    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+		Pid     int  `json:"Pid"`
+	} `json:"State"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// InspectContainer looks a container up by exact name or id.
+func (c *Client) InspectContainer(ctx context.Context, nameOrId string) (*ContainerDetails, error) {
+	var details ContainerDetails
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(nameOrId)+"/json", nil, nil, &details); err != nil {
+		return nil, err
+	}
+	details.Name = strings.TrimPrefix(details.Name, "/")
+	return &details, nil
+}
+
+// KillContainer sends signal (for example "SIGHUP") to the container's main
+// process.
+func (c *Client) KillContainer(ctx context.Context, id string, signal string) error {
+	query := url.Values{}
+	if signal != "" {
+		query.Set("signal", signal)
+	}
+	return c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/kill", query, nil, nil)
+}
+
+// RestartContainer restarts the container, giving it timeout to stop before
+// it is killed.
+func (c *Client) RestartContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{}
+	query.Set("t", strconv.Itoa(int(timeout/time.Second)))
+	return c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/restart", query, nil, nil)
+}
+
+// ExecResult is the combined output and exit code of a command run in a
+// container.
+type ExecResult struct {
+	Output   string
+	ExitCode int
+}
+
+// Exec runs command inside the container and waits for it to finish.
+func (c *Client) Exec(ctx context.Context, id string, command []string) (*ExecResult, error) {
+	createBody, err := json.Marshal(map[string]any{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          command,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal exec: %w", err)
+	}
+	var created struct {
+		Id string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", nil, bytes.NewReader(createBody), &created); err != nil {
+		return nil, err
+	}
+
+	startBody := []byte(`{"Detach":false,"Tty":false}`)
+	var output bytes.Buffer
+	if err := c.stream(ctx, http.MethodPost, "/exec/"+created.Id+"/start", bytes.NewReader(startBody), &output); err != nil {
+		return nil, err
+	}
+
+	var inspect struct {
+		ExitCode int `json:"ExitCode"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/exec/"+created.Id+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+	return &ExecResult{Output: output.String(), ExitCode: inspect.ExitCode}, nil
+}
+
+// stream copies a multiplexed stdout/stderr response body into out.
+func (c *Client) stream(ctx context.Context, method string, path string, body io.Reader, out io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	return demultiplex(resp.Body, out)
+}
+
+// demultiplex reads the engine's attach stream framing: an 8-byte header
+// (stream type, three zero bytes, big-endian payload length) per frame.
+func demultiplex(r io.Reader, out io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("read stream: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(out, r, size); err != nil {
+			return fmt.Errorf("read stream: %w", err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package install
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -16,7 +17,7 @@ func unregisterAgent(configPath string) {
 		return
 	}
 
-	if err := api.UnregisterAgent(cfg); err != nil {
+	if err := api.UnregisterAgent(context.Background(), cfg); err != nil {
 		log.Printf("Agent unregister failed for %s: %v", cfg.Agent.AgentId, err)
 		return
 	}
@@ -1,13 +1,13 @@
 package inventory
 
 import (
-	"log"
-	"regexp"
+	"context"
+	"errors"
+	"path/filepath"
 	"runtime"
 	"strings"
 
 	"github.com/certkit-io/certkit-agent/api"
-	"github.com/certkit-io/certkit-agent/utils"
 )
 
 type ApacheProvider struct{}
@@ -16,127 +16,167 @@ func (ApacheProvider) Name() string {
 	return "apache"
 }
 
-func (ApacheProvider) Collect() ([]api.InventoryItem, error) {
-	configFiles, err := expandConfigGlobs(apacheConfigGlobs())
+func (ApacheProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	mainFiles, err := expandConfigGlobs(append(apacheMainConfigs(), extraConfigGlobs("apache")...))
+	if err != nil {
+		return nil, err
+	}
+	fragmentFiles, err := expandConfigGlobs(apacheFragmentConfigs())
 	if err != nil {
 		return nil, err
 	}
 
+	visited := make(map[string]struct{})
 	items := make([]api.InventoryItem, 0)
-	for _, path := range configFiles {
-		certs, keys, chains, domains, err := parseApacheConfig(path)
-		if err != nil {
-			log.Printf("Inventory parse error for %s: %v", path, err)
-			continue
+	var errs []error
+	for _, path := range mainFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-
-		pairs := len(certs)
-		if len(keys) < pairs {
-			pairs = len(keys)
+		fileItems, fileErrs := collectApacheFile(path, visited)
+		items = append(items, fileItems...)
+		errs = append(errs, fileErrs...)
+	}
+	for _, path := range fragmentFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		for i := 0; i < pairs; i++ {
-			chainPath := ""
-			if i < len(chains) {
-				chainPath = chains[i]
-			}
-			items = append(items, api.InventoryItem{
-				Server:          "apache",
-				ConfigPath:      path,
-				CertificatePath: certs[i],
-				KeyPath:         keys[i],
-				ChainPath:       chainPath,
-				Domains:         joinDomains(domains),
-			})
+		if _, ok := visited[canonicalPath(path)]; ok {
+			continue
 		}
+		fileItems, fileErrs := collectApacheFile(path, visited)
+		items = append(items, fileItems...)
+		errs = append(errs, fileErrs...)
 	}
 
-	return items, nil
+	return items, errors.Join(errs...)
 }
 
-func apacheConfigGlobs() []string {
+// apacheMainConfigs are top-level httpd configuration files; they are walked
+// with their includes.
+func apacheMainConfigs() []string {
 	if runtime.GOOS == "windows" {
 		return []string{
 			`C:\Apache24\conf\httpd.conf`,
+			`C:\Apache2\conf\httpd.conf`,
+			`C:\Apache\conf\httpd.conf`,
+			`C:\Program Files\Apache Group\Apache2\conf\httpd.conf`,
+			`C:\Program Files\Apache24\conf\httpd.conf`,
+			`C:\httpd\conf\httpd.conf`,
+		}
+	}
+
+	return []string{
+		"/etc/apache2/apache2.conf",
+		"/etc/httpd/conf/httpd.conf",
+		"/usr/local/etc/apache24/httpd.conf",
+	}
+}
+
+// apacheFragmentConfigs are files normally pulled in by Include. Any that the
+// main configs did not already include (such as sites that are available but
+// not enabled) are walked on their own.
+func apacheFragmentConfigs() []string {
+	if runtime.GOOS == "windows" {
+		return []string{
 			`C:\Apache24\conf\extra\*.conf`,
 			`C:\Apache24\conf\sites-enabled\*`,
-			`C:\Apache2\conf\httpd.conf`,
 			`C:\Apache2\conf\extra\*.conf`,
 			`C:\Apache2\conf\sites-enabled\*`,
-			`C:\Apache\conf\httpd.conf`,
 			`C:\Apache\conf\extra\*.conf`,
-			`C:\Program Files\Apache Group\Apache2\conf\httpd.conf`,
 			`C:\Program Files\Apache Group\Apache2\conf\extra\*.conf`,
-			`C:\Program Files\Apache24\conf\httpd.conf`,
 			`C:\Program Files\Apache24\conf\extra\*.conf`,
-			`C:\httpd\conf\httpd.conf`,
 			`C:\httpd\conf\extra\*.conf`,
 		}
 	}
 
 	return []string{
-		"/etc/apache2/apache2.conf",
 		"/etc/apache2/conf-enabled/*.conf",
 		"/etc/apache2/sites-enabled/*",
 		"/etc/apache2/sites-available/*",
-		"/etc/httpd/conf/httpd.conf",
 		"/etc/httpd/conf.d/*.conf",
-		"/usr/local/etc/apache24/httpd.conf",
 	}
 }
 
-func parseApacheConfig(path string) ([]string, []string, []string, []string, error) {
-	data, err := utils.ReadFileBytes(path)
+func collectApacheFile(path string, visited map[string]struct{}) ([]api.InventoryItem, []error) {
+	walker := newApacheWalker(apacheServerRootFor(path), visited)
+	err := walker.walk(path)
+	errs := make([]error, 0, len(walker.errors)+1)
+	for _, includeErr := range walker.errors {
+		errs = append(errs, &FileError{Path: path, Err: includeErr})
+	}
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, append(errs, &FileError{Path: path, Err: err})
 	}
+	return apacheVhostItems(walker), errs
+}
 
-	reCert := regexp.MustCompile(`(?i)^\s*SSLCertificateFile\s+(.+)$`)
-	reKey := regexp.MustCompile(`(?i)^\s*SSLCertificateKeyFile\s+(.+)$`)
-	reChain := regexp.MustCompile(`(?i)^\s*SSLCertificateChainFile\s+(.+)$`)
-	reServerName := regexp.MustCompile(`(?i)^\s*ServerName\s+(.+)$`)
-	reServerAlias := regexp.MustCompile(`(?i)^\s*ServerAlias\s+(.+)$`)
-
-	var certs []string
-	var keys []string
-	var chains []string
-	var domains []string
-
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		trimmed := stripApacheComment(line)
-		if trimmed == "" {
-			continue
-		}
-		if match := reCert.FindStringSubmatch(trimmed); len(match) == 2 {
-			certs = append(certs, normalizeApachePath(cleanConfigValue(match[1])))
-			continue
+// apacheVhostItems emits one inventory item per certificate configured in each
+// virtual host. Certificates set at the main server level are inherited by
+// virtual hosts that enable SSL without configuring their own.
+func apacheVhostItems(walker *apacheWalker) []api.InventoryItem {
+	global := walker.global
+	items := make([]api.InventoryItem, 0)
+	inherited := false
+	for _, vhost := range walker.vhosts {
+		ssl := *vhost
+		if len(ssl.certs) == 0 {
+			if len(global.certs) == 0 || !ssl.sslEngine {
+				continue
+			}
+			ssl.certs = global.certs
+			ssl.keys = global.keys
+			inherited = true
 		}
-		if match := reKey.FindStringSubmatch(trimmed); len(match) == 2 {
-			keys = append(keys, normalizeApachePath(cleanConfigValue(match[1])))
-			continue
+		if ssl.chain == "" {
+			ssl.chain = global.chain
 		}
-		if match := reChain.FindStringSubmatch(trimmed); len(match) == 2 {
-			chains = append(chains, normalizeApachePath(cleanConfigValue(match[1])))
-			continue
+		items = append(items, apacheItems(ssl)...)
+	}
+	if len(global.certs) > 0 && (global.sslEngine || !inherited) {
+		items = append(items, apacheItems(global)...)
+	}
+	return items
+}
+
+// apacheItems pairs the Nth certificate with the Nth key. Without a matching
+// SSLCertificateKeyFile Apache reads the key from the certificate file.
+func apacheItems(vhost apacheVhost) []api.InventoryItem {
+	items := make([]api.InventoryItem, 0, len(vhost.certs))
+	for i, certPath := range vhost.certs {
+		keyPath := certPath
+		if i < len(vhost.keys) {
+			keyPath = vhost.keys[i]
 		}
-		if match := reServerName.FindStringSubmatch(trimmed); len(match) == 2 {
-			for _, field := range strings.Fields(match[1]) {
-				if domain, ok := normalizeDomain(field); ok {
-					domains = append(domains, domain)
-				}
+		items = append(items, api.InventoryItem{
+			Server:          "apache",
+			ConfigPath:      vhost.file,
+			CertificatePath: certPath,
+			KeyPath:         keyPath,
+			ChainPath:       vhost.chain,
+			Domains:         uniqueDomains(vhost.domains),
+		})
+	}
+	return items
+}
+
+// apacheServerRootFor guesses the ServerRoot for a configuration file by
+// stepping out of the conventional conf, conf.d, sites-* and extra directories.
+// A ServerRoot directive in the configuration overrides the guess.
+func apacheServerRootFor(path string) string {
+	dir := filepath.Dir(path)
+	for {
+		switch strings.ToLower(filepath.Base(dir)) {
+		case "conf", "conf.d", "conf-enabled", "conf-available", "sites-enabled", "sites-available", "extra":
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return dir
 			}
+			dir = parent
 			continue
 		}
-		if match := reServerAlias.FindStringSubmatch(trimmed); len(match) == 2 {
-			for _, field := range strings.Fields(match[1]) {
-				if domain, ok := normalizeDomain(field); ok {
-					domains = append(domains, domain)
-				}
-			}
-		}
+		return dir
 	}
-
-	return certs, keys, chains, domains, nil
 }
 
 func normalizeApachePath(value string) string {
@@ -147,10 +187,3 @@ func normalizeApachePath(value string) string {
 	value = strings.ReplaceAll(value, `\\`, `\`)
 	return value
 }
-
-func stripApacheComment(line string) string {
-	if idx := strings.Index(line, "#"); idx >= 0 {
-		line = line[:idx]
-	}
-	return strings.TrimSpace(line)
-}
@@ -0,0 +1,423 @@
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const maxApacheIncludeDepth = 16
+
+var apacheVarRegex = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// apacheVhost collects the TLS directives of one <VirtualHost>, or of the main
+// server outside any virtual host.
+type apacheVhost struct {
+	file      string
+	certs     []string
+	keys      []string
+	chain     string
+	domains   []api.InventoryDomain
+	sslEngine bool
+}
+
+type apacheSection struct {
+	name   string
+	active bool
+}
+
+// apacheWalker walks Apache configuration the way httpd reads it: in order,
+// following Include/IncludeOptional relative to ServerRoot, expanding Define
+// and environment variables, and honouring <IfModule>/<IfDefine>/<IfFile>.
+// Directives are attributed to the <VirtualHost> they appear in.
+type apacheWalker struct {
+	serverRoot string
+	defines    map[string]string
+	env        map[string]string
+	modules    map[string]struct{}
+	visited    map[string]struct{}
+	errors     []error
+	global     apacheVhost
+	vhosts     []*apacheVhost
+}
+
+func newApacheWalker(serverRoot string, visited map[string]struct{}) *apacheWalker {
+	if visited == nil {
+		visited = make(map[string]struct{})
+	}
+	w := &apacheWalker{
+		serverRoot: serverRoot,
+		defines:    make(map[string]string),
+		env:        make(map[string]string),
+		modules:    make(map[string]struct{}),
+		visited:    visited,
+	}
+	w.loadEnvVars(filepath.Join(serverRoot, "envvars"))
+	return w
+}
+
+// loadEnvVars reads the simple VAR=value assignments Debian keeps in
+// /etc/apache2/envvars; apache2ctl sources this file before starting httpd.
+func (w *apacheWalker) loadEnvVars(path string) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.ContainsAny(name, " \t$") {
+			continue
+		}
+		value = cleanConfigValue(value)
+		value = os.Expand(value, func(key string) string {
+			if v, ok := w.env[key]; ok {
+				return v
+			}
+			return ""
+		})
+		w.env[name] = value
+	}
+}
+
+func (w *apacheWalker) walk(path string) error {
+	return w.walkFile(path, 0, nil)
+}
+
+func (w *apacheWalker) walkFile(path string, depth int, vhost *apacheVhost) error {
+	if depth > maxApacheIncludeDepth {
+		return fmt.Errorf("%s: include depth exceeds %d", path, maxApacheIncludeDepth)
+	}
+	key := canonicalPath(path)
+	if _, ok := w.visited[key]; ok {
+		return nil
+	}
+	w.visited[key] = struct{}{}
+
+	lines, err := readApacheLines(path)
+	if err != nil {
+		return err
+	}
+
+	stack := make([]apacheSection, 0)
+	active := func() bool {
+		return len(stack) == 0 || stack[len(stack)-1].active
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line.text, "</") {
+			name := strings.ToLower(strings.Trim(line.text[2:], "> \t"))
+			if len(stack) == 0 {
+				return fmt.Errorf("%s:%d: unexpected </%s>", path, line.number, name)
+			}
+			closing := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if closing.name == "virtualhost" && closing.active {
+				vhost = nil
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line.text, "<") {
+			name, args := splitApacheDirective(strings.TrimSuffix(strings.TrimPrefix(line.text, "<"), ">"))
+			name = strings.ToLower(name)
+			args = w.expandArgs(args)
+			section := apacheSection{name: name, active: active() && w.sectionApplies(name, args)}
+			if section.active && name == "virtualhost" {
+				vhost = &apacheVhost{file: path}
+				w.vhosts = append(w.vhosts, vhost)
+			}
+			stack = append(stack, section)
+			continue
+		}
+
+		if !active() {
+			continue
+		}
+		name, args := splitApacheDirective(line.text)
+		args = w.expandArgs(args)
+		w.directive(path, line.number, depth, vhost, name, args)
+	}
+
+	if len(stack) > 0 {
+		return fmt.Errorf("%s: <%s> section is not closed", path, stack[len(stack)-1].name)
+	}
+	return nil
+}
+
+func (w *apacheWalker) directive(path string, lineNumber int, depth int, vhost *apacheVhost, name string, args []string) {
+	scope := vhost
+	if scope == nil {
+		scope = &w.global
+		if scope.file == "" {
+			scope.file = path
+		}
+	}
+
+	switch strings.ToLower(name) {
+	case "define":
+		if len(args) > 0 {
+			value := ""
+			if len(args) > 1 {
+				value = args[1]
+			}
+			w.defines[args[0]] = value
+		}
+	case "undefine":
+		if len(args) > 0 {
+			delete(w.defines, args[0])
+		}
+	case "serverroot":
+		if len(args) > 0 {
+			w.serverRoot = normalizeApachePath(args[0])
+		}
+	case "loadmodule":
+		if len(args) > 0 {
+			w.modules[strings.ToLower(args[0])] = struct{}{}
+		}
+		if len(args) > 1 {
+			file := strings.ToLower(filepath.Base(args[1]))
+			w.modules[strings.TrimSuffix(file, filepath.Ext(file))+".c"] = struct{}{}
+		}
+	case "include", "includeoptional":
+		optional := strings.EqualFold(name, "includeoptional")
+		for _, pattern := range args {
+			w.include(path, lineNumber, depth, vhost, w.resolvePath(pattern), optional)
+		}
+	case "sslengine":
+		if len(args) > 0 && strings.EqualFold(args[0], "on") {
+			scope.sslEngine = true
+		}
+	case "sslcertificatefile":
+		if len(args) > 0 {
+			scope.certs = append(scope.certs, w.resolvePath(args[0]))
+		}
+	case "sslcertificatekeyfile":
+		if len(args) > 0 {
+			scope.keys = append(scope.keys, w.resolvePath(args[0]))
+		}
+	case "sslcertificatechainfile":
+		if len(args) > 0 {
+			scope.chain = w.resolvePath(args[0])
+		}
+	case "servername", "serveralias":
+		for _, field := range args {
+			if domain, ok := parseDomain(field); ok {
+				scope.domains = append(scope.domains, domain)
+			}
+		}
+	}
+}
+
+func (w *apacheWalker) include(path string, lineNumber int, depth int, vhost *apacheVhost, pattern string, optional bool) {
+	matches := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		matches, err = filepath.Glob(pattern)
+		if err != nil {
+			w.errors = append(w.errors, fmt.Errorf("%s:%d: include %q: %w", path, lineNumber, pattern, err))
+			return
+		}
+		sort.Strings(matches)
+	}
+	if len(matches) == 0 && !optional {
+		w.errors = append(w.errors, fmt.Errorf("%s:%d: include %q matched no files", path, lineNumber, pattern))
+		return
+	}
+
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			if !optional {
+				w.errors = append(w.errors, fmt.Errorf("%s:%d: include %q: %w", path, lineNumber, match, err))
+			}
+			continue
+		}
+		files := []string{match}
+		if info.IsDir() {
+			// Including a directory reads every file in it, in sorted order.
+			entries, err := os.ReadDir(match)
+			if err != nil {
+				w.errors = append(w.errors, err)
+				continue
+			}
+			files = files[:0]
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(match, entry.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			if isExcludedPath(file) {
+				continue
+			}
+			if err := w.walkFile(file, depth+1, vhost); err != nil {
+				w.errors = append(w.errors, err)
+			}
+		}
+	}
+}
+
+// sectionApplies evaluates conditional sections. Module state is only known
+// from LoadModule lines seen so far; when none have been seen (for example
+// when a site file is read on its own) <IfModule> is assumed to hold.
+func (w *apacheWalker) sectionApplies(name string, args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	condition := args[0]
+	negate := strings.HasPrefix(condition, "!")
+	condition = strings.TrimPrefix(condition, "!")
+
+	var result bool
+	switch name {
+	case "ifmodule":
+		if len(w.modules) == 0 {
+			return !negate
+		}
+		_, result = w.modules[strings.ToLower(condition)]
+	case "ifdefine":
+		_, result = w.defines[condition]
+	case "iffile":
+		exists, _ := utils.FileExists(w.resolvePath(condition))
+		result = exists
+	case "macro":
+		// Macro bodies are templates, not live configuration.
+		return false
+	default:
+		return true
+	}
+	if negate {
+		return !result
+	}
+	return result
+}
+
+func (w *apacheWalker) expandArgs(args []string) []string {
+	for i, arg := range args {
+		args[i] = apacheVarRegex.ReplaceAllStringFunc(arg, func(match string) string {
+			name := match[2 : len(match)-1]
+			if value, ok := w.defines[name]; ok {
+				return value
+			}
+			if value, ok := w.env[name]; ok {
+				return value
+			}
+			if value, ok := os.LookupEnv(name); ok {
+				return value
+			}
+			return match
+		})
+	}
+	return args
+}
+
+func (w *apacheWalker) resolvePath(value string) string {
+	value = normalizeApachePath(value)
+	if value == "" || filepath.IsAbs(value) || w.serverRoot == "" {
+		return value
+	}
+	return filepath.Join(w.serverRoot, value)
+}
+
+type apacheLine struct {
+	text   string
+	number int
+}
+
+// readApacheLines returns the non-comment lines of path with backslash line
+// continuations joined.
+func readApacheLines(path string) ([]apacheLine, error) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]apacheLine, 0)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var pending strings.Builder
+	pendingLine := 0
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if pending.Len() == 0 {
+			pendingLine = number
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+		}
+		if strings.HasSuffix(text, `\`) {
+			pending.WriteString(strings.TrimSuffix(text, `\`))
+			pending.WriteByte(' ')
+			continue
+		}
+		pending.WriteString(text)
+		lines = append(lines, apacheLine{text: strings.TrimSpace(pending.String()), number: pendingLine})
+		pending.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pending.Len() > 0 {
+		lines = append(lines, apacheLine{text: strings.TrimSpace(pending.String()), number: pendingLine})
+	}
+	return lines, nil
+}
+
+// splitApacheDirective splits a directive line into its name and arguments,
+// honouring double and single quotes.
+func splitApacheDirective(line string) (string, []string) {
+	fields := make([]string, 0)
+	var current strings.Builder
+	inField := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(line) && line[i+1] == quote {
+				current.WriteByte(quote)
+				i++
+				continue
+			}
+			if c == quote {
+				quote = 0
+				continue
+			}
+			current.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
@@ -0,0 +1,121 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestApacheWalkerScopesVirtualHosts(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "envvars"), `
+export APACHE_CERT_DIR=/certs
+`)
+	writeTestFile(t, filepath.Join(root, "apache2.conf"), `
+ServerRoot "`+root+`"
+LoadModule ssl_module modules/mod_ssl.so
+Define SITE_DOMAIN a.example.com
+IncludeOptional mods-enabled/*.load
+Include sites-enabled/
+<IfModule !mod_ssl.c>
+    SSLCertificateFile /certs/never.crt
+</IfModule>
+`)
+	writeTestFile(t, filepath.Join(root, "sites-enabled", "a.conf"), `
+<IfModule mod_ssl.c>
+<VirtualHost *:443>
+    ServerName ${SITE_DOMAIN}
+    ServerAlias www.${SITE_DOMAIN}
+    SSLEngine on
+    SSLCertificateFile ${APACHE_CERT_DIR}/a.crt
+    SSLCertificateKeyFile "${APACHE_CERT_DIR}/a.key"
+    SSLCertificateChainFile /certs/a-chain.pem
+    <Directory /var/www>
+        Require all granted
+    </Directory>
+</VirtualHost>
+</IfModule>
+`)
+	writeTestFile(t, filepath.Join(root, "sites-enabled", "b.conf"), `
+# b has no chain file and keeps its key in the certificate file
+<VirtualHost *:443>
+    ServerName b.example.com
+    SSLEngine on
+    SSLCertificateFile \
+        ssl/b.pem
+</VirtualHost>
+<IfDefine LEGACY>
+<VirtualHost *:443>
+    ServerName legacy.example.com
+    SSLCertificateFile /certs/legacy.crt
+</VirtualHost>
+</IfDefine>
+<VirtualHost *:80>
+    ServerName plain.example.com
+</VirtualHost>
+`)
+
+	visited := make(map[string]struct{})
+	walker := newApacheWalker(root, visited)
+	if err := walker.walk(filepath.Join(root, "apache2.conf")); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if len(walker.errors) > 0 {
+		t.Fatalf("include errors: %v", walker.errors)
+	}
+
+	items := apacheVhostItems(walker)
+	type want struct{ cert, key, chain, domains string }
+	wants := []want{
+		{"/certs/a.crt", "/certs/a.key", "/certs/a-chain.pem", "a.example.com,www.a.example.com"},
+		{filepath.Join(root, "ssl/b.pem"), filepath.Join(root, "ssl/b.pem"), "", "b.example.com"},
+	}
+	if len(items) != len(wants) {
+		t.Fatalf("got %d items, want %d: %+v", len(items), len(wants), items)
+	}
+	for i, w := range wants {
+		got := items[i]
+		if got.CertificatePath != w.cert || got.KeyPath != w.key || got.ChainPath != w.chain || domainNames(got.Domains) != w.domains {
+			t.Errorf("item %d = {%s %s %s %s}, want {%s %s %s %s}", i, got.CertificatePath, got.KeyPath, got.ChainPath, domainNames(got.Domains), w.cert, w.key, w.chain, w.domains)
+		}
+	}
+	if _, ok := visited[canonicalPath(filepath.Join(root, "sites-enabled", "b.conf"))]; !ok {
+		t.Errorf("included site was not marked visited")
+	}
+}
+
+func TestApacheVhostsInheritMainServerCertificate(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "httpd.conf"), `
+SSLCertificateFile /certs/default.crt
+SSLCertificateKeyFile /certs/default.key
+<VirtualHost *:443>
+    ServerName tls.example.com
+    SSLEngine on
+</VirtualHost>
+<VirtualHost *:80>
+    ServerName plain.example.com
+</VirtualHost>
+`)
+
+	walker := newApacheWalker(root, nil)
+	if err := walker.walk(filepath.Join(root, "httpd.conf")); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	items := apacheVhostItems(walker)
+	if len(items) != 1 {
+		t.Fatalf("got %d items, want 1: %+v", len(items), items)
+	}
+	if items[0].CertificatePath != "/certs/default.crt" || items[0].KeyPath != "/certs/default.key" || domainNames(items[0].Domains) != "tls.example.com" {
+		t.Errorf("unexpected item %+v", items[0])
+	}
+}
+
+func TestApacheWalkerReportsUnclosedSection(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "httpd.conf"), "<VirtualHost *:443>\nServerName a.example.com\n")
+
+	walker := newApacheWalker(root, nil)
+	if err := walker.walk(filepath.Join(root, "httpd.conf")); err == nil {
+		t.Fatal("expected error for unclosed <VirtualHost>")
+	}
+}
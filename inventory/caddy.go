@@ -0,0 +1,269 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+type CaddyProvider struct{}
+
+func (CaddyProvider) Name() string {
+	return "caddy"
+}
+
+// caddyConfigFiles are the Caddyfiles and JSON configs Caddy is commonly run
+// with, including the autosaved copy of the last config loaded through the
+// admin API.
+func caddyConfigFiles() []string {
+	return []string{
+		"/etc/caddy/Caddyfile",
+		"/etc/caddy/*.caddyfile",
+		"/etc/caddy/caddy.json",
+		"/var/lib/caddy/.config/caddy/autosave.json",
+		"/root/.config/caddy/autosave.json",
+	}
+}
+
+// caddyStorageRoots hold the certificates Caddy obtains itself, one
+// directory per issuer and name.
+func caddyStorageRoots() []string {
+	return []string{
+		"/var/lib/caddy/.local/share/caddy/certificates",
+		"/root/.local/share/caddy/certificates",
+	}
+}
+
+func (CaddyProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	configFiles, err := expandConfigGlobs(append(caddyConfigFiles(), extraConfigGlobs("caddy")...))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]api.InventoryItem, 0)
+	var errs []error
+	for _, path := range configFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var fileItems []api.InventoryItem
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			fileItems, err = parseCaddyJSON(path)
+		} else {
+			fileItems, err = parseCaddyfile(path)
+		}
+		if err != nil {
+			errs = append(errs, &FileError{Path: path, Err: err})
+			continue
+		}
+		items = append(items, fileItems...)
+	}
+
+	for _, root := range caddyStorageRoots() {
+		managed, err := caddyManagedCertificates(root)
+		if err != nil {
+			errs = append(errs, &FileError{Path: root, Err: err})
+			continue
+		}
+		items = append(items, managed...)
+	}
+	return items, errors.Join(errs...)
+}
+
+// parseCaddyfile reports the "tls <cert> <key>" directives of each site
+// block, with the block's addresses as domains. Snippets and the global
+// options block are skipped.
+func parseCaddyfile(path string) ([]api.InventoryItem, error) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]api.InventoryItem, 0)
+	depth := 0
+	var site []api.InventoryDomain
+	inSite := false
+	var pending []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = stripCaddyComment(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+
+		if depth == 0 {
+			pending = append(pending, fields...)
+			if fields[len(fields)-1] != "{" {
+				continue
+			}
+			addresses := pending[:len(pending)-1]
+			pending = nil
+			depth = 1
+			site = nil
+			// A bare "{" opens the global options; "(name)" opens a snippet.
+			inSite = len(addresses) > 0 && !strings.HasPrefix(addresses[0], "(")
+			if inSite {
+				site = caddySiteDomains(addresses)
+			}
+			continue
+		}
+
+		if inSite && depth == 1 && fields[0] == "tls" && len(fields) >= 3 && fields[2] != "{" {
+			items = append(items, api.InventoryItem{
+				Server:          "caddy",
+				ConfigPath:      path,
+				CertificatePath: cleanConfigValue(fields[1]),
+				KeyPath:         cleanConfigValue(fields[2]),
+				Domains:         site,
+			})
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+		if depth <= 0 {
+			depth = 0
+			inSite = false
+		}
+	}
+	return items, nil
+}
+
+// caddySiteDomains parses site addresses such as "example.com",
+// "https://www.example.com:8443" or "*.example.com/api".
+func caddySiteDomains(addresses []string) []api.InventoryDomain {
+	var domains []api.InventoryDomain
+	for _, address := range addresses {
+		for _, part := range strings.Split(address, ",") {
+			if _, rest, ok := strings.Cut(part, "://"); ok {
+				part = rest
+			}
+			if slash := strings.Index(part, "/"); slash >= 0 {
+				part = part[:slash]
+			}
+			if domain, ok := parseDomain(part); ok {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return uniqueDomains(domains)
+}
+
+func stripCaddyComment(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "#") {
+		return ""
+	}
+	if idx := strings.Index(trimmed, " #"); idx >= 0 {
+		trimmed = trimmed[:idx]
+	}
+	return strings.TrimSpace(trimmed)
+}
+
+// caddyJSONConfig is the part of Caddy's native config that names
+// certificate files and the hosts routes match.
+type caddyJSONConfig struct {
+	Apps struct {
+		TLS struct {
+			Certificates struct {
+				LoadFiles []struct {
+					Certificate string `json:"certificate"`
+					Key         string `json:"key"`
+				} `json:"load_files"`
+			} `json:"certificates"`
+		} `json:"tls"`
+		HTTP struct {
+			Servers map[string]struct {
+				Routes []struct {
+					Match []struct {
+						Host []string `json:"host"`
+					} `json:"match"`
+				} `json:"routes"`
+			} `json:"servers"`
+		} `json:"http"`
+	} `json:"apps"`
+}
+
+// parseCaddyJSON reports load_files certificates, with the hosts routed by
+// the config's HTTP servers as domains. Certificates loaded inline with
+// load_pem have no file and are not reported.
+func parseCaddyJSON(path string) ([]api.InventoryItem, error) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed caddyJSONConfig
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+
+	var domains []api.InventoryDomain
+	serverNames := make([]string, 0, len(parsed.Apps.HTTP.Servers))
+	for name := range parsed.Apps.HTTP.Servers {
+		serverNames = append(serverNames, name)
+	}
+	sort.Strings(serverNames)
+	for _, name := range serverNames {
+		for _, route := range parsed.Apps.HTTP.Servers[name].Routes {
+			for _, match := range route.Match {
+				for _, host := range match.Host {
+					if domain, ok := parseDomain(host); ok {
+						domains = append(domains, domain)
+					}
+				}
+			}
+		}
+	}
+	domains = uniqueDomains(domains)
+
+	items := make([]api.InventoryItem, 0)
+	for _, file := range parsed.Apps.TLS.Certificates.LoadFiles {
+		if file.Certificate == "" {
+			continue
+		}
+		items = append(items, api.InventoryItem{
+			Server:          "caddy",
+			ConfigPath:      path,
+			CertificatePath: file.Certificate,
+			KeyPath:         file.Key,
+			Domains:         domains,
+		})
+	}
+	return items, nil
+}
+
+// caddyManagedCertificates lists <root>/<issuer>/<name>/<name>.crt files
+// with their .key siblings.
+func caddyManagedCertificates(root string) ([]api.InventoryItem, error) {
+	matches, err := filepath.Glob(filepath.Join(root, "*", "*", "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	items := make([]api.InventoryItem, 0, len(matches))
+	for _, certPath := range matches {
+		if isExcludedPath(certPath) {
+			continue
+		}
+		name := filepath.Base(filepath.Dir(certPath))
+		if strings.TrimSuffix(filepath.Base(certPath), ".crt") != name {
+			continue
+		}
+		item := api.InventoryItem{
+			Server:          "caddy",
+			ConfigPath:      root,
+			CertificatePath: certPath,
+			KeyPath:         strings.TrimSuffix(certPath, ".crt") + ".key",
+		}
+		// Wildcard names are stored with the "*" replaced by "wildcard_".
+		if domain, ok := parseDomain(strings.Replace(name, "wildcard_", "*", 1)); ok {
+			item.Domains = []api.InventoryDomain{domain}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,394 @@
+package inventory
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+	"golang.org/x/crypto/pkcs12"
+)
+
+var errPrivateKeyEncrypted = errors.New("private key is encrypted")
+
+// enrichItems attaches certificate metadata to every item whose certificate
+// path is a file. Items from providers that point at certificate stores
+// (IIS, RRAS) rather than files are left as they are.
+func enrichItems(items []api.InventoryItem) {
+	for i := range items {
+		if !filepath.IsAbs(items[i].CertificatePath) {
+			continue
+		}
+		items[i].Certificate = describeCertificate(items[i])
+	}
+}
+
+// loadedCertificate is the parsed contents of a certificate file: its
+// certificates in file order and, for bundles that carry one, the private key.
+type loadedCertificate struct {
+	format       string
+	certificates []*x509.Certificate
+	key          crypto.PrivateKey
+	keyState     string
+}
+
+// describeCertificate opens the certificate an inventory item points at and
+// reports what is deployed. Missing, unreadable and unparseable files are
+// described rather than dropped.
+func describeCertificate(item api.InventoryItem) *api.InventoryCertificate {
+	data, err := utils.ReadFileBytes(item.CertificatePath)
+	if err != nil {
+		status := api.CertificateStatusUnreadable
+		if errors.Is(err, os.ErrNotExist) {
+			status = api.CertificateStatusMissing
+		}
+		return &api.InventoryCertificate{Status: status, Error: err.Error()}
+	}
+
+	loaded, err := loadCertificate(item.CertificatePath, data)
+	if err != nil {
+		status := api.CertificateStatusInvalid
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			status = api.CertificateStatusLocked
+		}
+		return &api.InventoryCertificate{Status: status, Format: formatOf(loaded), Error: err.Error()}
+	}
+
+	chain := loaded.certificates
+	if item.ChainPath != "" && item.ChainPath != item.CertificatePath {
+		extra, err := loadChainFile(item.ChainPath)
+		if err != nil {
+			return &api.InventoryCertificate{
+				Status: api.CertificateStatusUnreadable,
+				Format: loaded.format,
+				Error:  fmt.Sprintf("chain file %s: %v", item.ChainPath, err),
+			}
+		}
+		chain = append(chain, extra...)
+	}
+	description := describeChain(chain, loaded.format)
+	description.KeyMatch = keyMatch(item, orderChain(chain)[0], loaded)
+	return description
+}
+
+// describeChain summarizes a certificate chain read from a file or served
+// over TLS. The leaf is found wherever it sits in the chain.
+func describeChain(chain []*x509.Certificate, format string) *api.InventoryCertificate {
+	chain = orderChain(chain)
+	leaf := chain[0]
+
+	sha1Sum := sha1.Sum(leaf.Raw)
+	sha256Sum := sha256.Sum256(leaf.Raw)
+	notBefore := leaf.NotBefore.UTC()
+	notAfter := leaf.NotAfter.UTC()
+	complete := chainComplete(chain)
+	algorithm, size := publicKeyInfo(leaf.PublicKey)
+
+	return &api.InventoryCertificate{
+		Status:            api.CertificateStatusOK,
+		Format:            format,
+		Subject:           leaf.Subject.String(),
+		SANs:              certificateSANs(leaf),
+		Issuer:            leaf.Issuer.String(),
+		Serial:            hex.EncodeToString(leaf.SerialNumber.Bytes()),
+		NotBefore:         &notBefore,
+		NotAfter:          &notAfter,
+		KeyAlgorithm:      algorithm,
+		KeySize:           size,
+		FingerprintSHA1:   hex.EncodeToString(sha1Sum[:]),
+		FingerprintSHA256: hex.EncodeToString(sha256Sum[:]),
+		ChainLength:       len(chain),
+		ChainComplete:     &complete,
+	}
+}
+
+func formatOf(loaded *loadedCertificate) string {
+	if loaded == nil {
+		return ""
+	}
+	return loaded.format
+}
+
+// loadCertificate detects the file format from its contents: PEM, a Java
+// keystore, DER, or PKCS#12.
+func loadCertificate(path string, data []byte) (*loadedCertificate, error) {
+	switch {
+	case bytes.Contains(data, []byte("-----BEGIN ")):
+		return loadPEMCertificate(data)
+	case isJavaKeyStore(data):
+		return loadJavaKeyStore(data)
+	}
+
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return &loadedCertificate{format: "der", certificates: certs}, nil
+	}
+	return loadPfxCertificate(path, data)
+}
+
+func loadPEMCertificate(data []byte) (*loadedCertificate, error) {
+	loaded := &loadedCertificate{format: "pem"}
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch {
+		case block.Type == "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return loaded, fmt.Errorf("parse certificate: %w", err)
+			}
+			loaded.certificates = append(loaded.certificates, cert)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY") && loaded.key == nil:
+			key, err := parsePrivateKeyBlock(block)
+			if err != nil {
+				loaded.keyState = keyErrorState(err)
+				continue
+			}
+			loaded.key = key
+		}
+	}
+	if len(loaded.certificates) == 0 {
+		return loaded, errors.New("no certificate found in PEM file")
+	}
+	return loaded, nil
+}
+
+func loadJavaKeyStore(data []byte) (*loadedCertificate, error) {
+	loaded := &loadedCertificate{format: "jks", keyState: api.KeyMatchUnknown}
+	entries, err := parseJavaKeyStore(data)
+	if err != nil {
+		return loaded, err
+	}
+
+	// Prefer the first key entry: that is the certificate a server presents.
+	var chosen *jksEntry
+	for i := range entries {
+		if len(entries[i].certificates) == 0 {
+			continue
+		}
+		if chosen == nil || (entries[i].privateKey && !chosen.privateKey) {
+			chosen = &entries[i]
+		}
+	}
+	if chosen == nil {
+		return loaded, errors.New("no certificate found in keystore")
+	}
+	for _, der := range chosen.certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return loaded, fmt.Errorf("parse certificate %q: %w", chosen.alias, err)
+		}
+		loaded.certificates = append(loaded.certificates, cert)
+	}
+	return loaded, nil
+}
+
+// loadPfxCertificate tries the password the agent stores next to PFX files it
+// deploys, then an empty password.
+func loadPfxCertificate(path string, data []byte) (*loadedCertificate, error) {
+	loaded := &loadedCertificate{format: "pfx"}
+	passwords := make([]string, 0, 2)
+	if password, err := utils.ReadFileBytes(utils.PfxPasswordFilePath(path)); err == nil {
+		passwords = append(passwords, strings.TrimSpace(string(password)))
+	}
+	passwords = append(passwords, "")
+
+	var lastErr error
+	for _, password := range passwords {
+		blocks, err := pkcs12.ToPEM(data, password)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var pemData bytes.Buffer
+		for _, block := range blocks {
+			_ = pem.Encode(&pemData, block)
+		}
+		parsed, err := loadPEMCertificate(pemData.Bytes())
+		if err != nil {
+			return loaded, err
+		}
+		parsed.format = "pfx"
+		return parsed, nil
+	}
+	if errors.Is(lastErr, pkcs12.ErrIncorrectPassword) {
+		return loaded, fmt.Errorf("decode pfx: %w", lastErr)
+	}
+	return nil, fmt.Errorf("unrecognized certificate format: %w", lastErr)
+}
+
+func loadChainFile(path string) ([]*x509.Certificate, error) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		loaded, err := loadPEMCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		return loaded.certificates, nil
+	}
+	return x509.ParseCertificates(data)
+}
+
+// orderChain moves the leaf to the front. Files are normally leaf first, but
+// bundles written root first are common enough to handle.
+func orderChain(chain []*x509.Certificate) []*x509.Certificate {
+	for i, cert := range chain {
+		if !cert.IsCA {
+			if i == 0 {
+				return chain
+			}
+			ordered := make([]*x509.Certificate, 0, len(chain))
+			ordered = append(ordered, cert)
+			ordered = append(ordered, chain[:i]...)
+			return append(ordered, chain[i+1:]...)
+		}
+	}
+	return chain
+}
+
+// chainComplete reports whether each certificate is signed by the next and
+// the last one is a root, either included or trusted by the system.
+func chainComplete(chain []*x509.Certificate) bool {
+	for i := 0; i+1 < len(chain); i++ {
+		if chain[i].CheckSignatureFrom(chain[i+1]) != nil {
+			return false
+		}
+	}
+	last := chain[len(chain)-1]
+	if bytes.Equal(last.RawIssuer, last.RawSubject) && last.CheckSignatureFrom(last) == nil {
+		return true
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		return false
+	}
+	_, err = last.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: last.NotBefore.Add(time.Minute),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+func keyMatch(item api.InventoryItem, leaf *x509.Certificate, loaded *loadedCertificate) string {
+	var key crypto.PrivateKey
+	if item.KeyPath == "" || item.KeyPath == item.CertificatePath {
+		if loaded.key == nil {
+			if loaded.keyState != "" {
+				return loaded.keyState
+			}
+			return api.KeyMatchMissing
+		}
+		key = loaded.key
+	} else {
+		data, err := utils.ReadFileBytes(item.KeyPath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return api.KeyMatchMissing
+			}
+			return api.KeyMatchUnreadable
+		}
+		key, err = parsePrivateKeyPEM(data)
+		if err != nil {
+			return keyErrorState(err)
+		}
+	}
+	return keyMatchState(leaf, key)
+}
+
+// keyMatchState compares a private key with the certificate's public key.
+func keyMatchState(leaf *x509.Certificate, key crypto.PrivateKey) string {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return api.KeyMatchUnknown
+	}
+	public, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return api.KeyMatchUnknown
+	}
+	if public.Equal(signer.Public()) {
+		return api.KeyMatchMatch
+	}
+	return api.KeyMatchMismatch
+}
+
+func keyErrorState(err error) string {
+	if errors.Is(err, errPrivateKeyEncrypted) {
+		return api.KeyMatchUnknown
+	}
+	return api.KeyMatchUnreadable
+}
+
+func parsePrivateKeyPEM(data []byte) (crypto.PrivateKey, error) {
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return parsePrivateKeyBlock(block)
+		}
+	}
+	return nil, errors.New("no private key found in PEM file")
+}
+
+func parsePrivateKeyBlock(block *pem.Block) (crypto.PrivateKey, error) {
+	if block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "" {
+		return nil, errPrivateKeyEncrypted
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+}
+
+func publicKeyInfo(key crypto.PublicKey) (string, int) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return "unknown", 0
+}
+
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
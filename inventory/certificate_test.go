@@ -0,0 +1,202 @@
+package inventory
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCertificate(t *testing.T, name string, parent *testCertificate) testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{name}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCertificate{cert: cert, key: key}
+}
+
+func (c testCertificate) certPEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}))
+}
+
+func (c testCertificate) keyPEM(t *testing.T) string {
+	der, err := x509.MarshalPKCS8PrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestDescribeCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, "Test Root", nil)
+	leaf := newTestCertificate(t, "www.example.com", &ca)
+	other := newTestCertificate(t, "other.example.com", &ca)
+
+	writeTestFile(t, filepath.Join(dir, "leaf.crt"), leaf.certPEM())
+	writeTestFile(t, filepath.Join(dir, "leaf.key"), leaf.keyPEM(t))
+	writeTestFile(t, filepath.Join(dir, "other.key"), other.keyPEM(t))
+	writeTestFile(t, filepath.Join(dir, "chain.pem"), ca.certPEM())
+	writeTestFile(t, filepath.Join(dir, "bundle.pem"), leaf.keyPEM(t)+ca.certPEM()+leaf.certPEM())
+	writeTestFile(t, filepath.Join(dir, "leaf.der"), string(leaf.cert.Raw))
+
+	item := api.InventoryItem{
+		CertificatePath: filepath.Join(dir, "leaf.crt"),
+		KeyPath:         filepath.Join(dir, "leaf.key"),
+		ChainPath:       filepath.Join(dir, "chain.pem"),
+	}
+	got := describeCertificate(item)
+	if got.Status != api.CertificateStatusOK || got.Format != "pem" {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if got.Subject != "CN=www.example.com" || got.Issuer != "CN=Test Root" {
+		t.Errorf("subject/issuer = %q/%q", got.Subject, got.Issuer)
+	}
+	if len(got.SANs) != 1 || got.SANs[0] != "www.example.com" {
+		t.Errorf("sans = %v", got.SANs)
+	}
+	if got.KeyAlgorithm != "ECDSA" || got.KeySize != 256 {
+		t.Errorf("key = %s %d", got.KeyAlgorithm, got.KeySize)
+	}
+	if got.KeyMatch != api.KeyMatchMatch {
+		t.Errorf("key match = %s", got.KeyMatch)
+	}
+	if got.ChainLength != 2 || got.ChainComplete == nil || !*got.ChainComplete {
+		t.Errorf("chain = %d complete=%v", got.ChainLength, got.ChainComplete)
+	}
+	if len(got.FingerprintSHA256) != 64 || got.NotAfter == nil || !got.NotAfter.Equal(leaf.cert.NotAfter) {
+		t.Errorf("fingerprint/expiry = %s %v", got.FingerprintSHA256, got.NotAfter)
+	}
+
+	// Without the chain file the leaf's issuer is unknown.
+	item.ChainPath = ""
+	item.KeyPath = filepath.Join(dir, "other.key")
+	got = describeCertificate(item)
+	if got.KeyMatch != api.KeyMatchMismatch || *got.ChainComplete {
+		t.Errorf("key match = %s, chain complete = %v", got.KeyMatch, *got.ChainComplete)
+	}
+
+	bundle := filepath.Join(dir, "bundle.pem")
+	got = describeCertificate(api.InventoryItem{CertificatePath: bundle, KeyPath: bundle})
+	if got.Subject != "CN=www.example.com" || got.KeyMatch != api.KeyMatchMatch || !*got.ChainComplete {
+		t.Errorf("bundle = %+v", got)
+	}
+
+	got = describeCertificate(api.InventoryItem{CertificatePath: filepath.Join(dir, "leaf.der"), KeyPath: filepath.Join(dir, "missing.key")})
+	if got.Format != "der" || got.KeyMatch != api.KeyMatchMissing {
+		t.Errorf("der = %+v", got)
+	}
+
+	got = describeCertificate(api.InventoryItem{CertificatePath: filepath.Join(dir, "missing.crt")})
+	if got.Status != api.CertificateStatusMissing {
+		t.Errorf("missing = %+v", got)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "garbage.crt"), "not a certificate")
+	got = describeCertificate(api.InventoryItem{CertificatePath: filepath.Join(dir, "garbage.crt")})
+	if got.Status != api.CertificateStatusInvalid {
+		t.Errorf("garbage = %+v", got)
+	}
+}
+
+func TestDescribeCertificateJavaKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, "Test Root", nil)
+	leaf := newTestCertificate(t, "app.example.com", &ca)
+
+	var buf bytes.Buffer
+	write := func(v any) { _ = binary.Write(&buf, binary.BigEndian, v) }
+	writeUTF := func(s string) {
+		write(uint16(len(s)))
+		buf.WriteString(s)
+	}
+	writeCert := func(der []byte) {
+		writeUTF("X.509")
+		write(uint32(len(der)))
+		buf.Write(der)
+	}
+	write(uint32(jksMagic))
+	write(uint32(2))
+	write(uint32(2))
+	// Trusted certificate entry.
+	write(uint32(jksTrustedCertTag))
+	writeUTF("root")
+	write(int64(0))
+	writeCert(ca.cert.Raw)
+	// Private key entry; the key bytes are opaque.
+	write(uint32(jksPrivateKeyTag))
+	writeUTF("server")
+	write(int64(0))
+	write(uint32(3))
+	buf.Write([]byte{1, 2, 3})
+	write(uint32(2))
+	writeCert(leaf.cert.Raw)
+	writeCert(ca.cert.Raw)
+	buf.Write(make([]byte, 20))
+
+	path := filepath.Join(dir, "keystore.jks")
+	writeTestFile(t, path, buf.String())
+
+	got := describeCertificate(api.InventoryItem{CertificatePath: path, KeyPath: path})
+	if got.Status != api.CertificateStatusOK || got.Format != "jks" {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	if got.Subject != "CN=app.example.com" || got.ChainLength != 2 || !*got.ChainComplete {
+		t.Errorf("jks = %+v", got)
+	}
+	if got.KeyMatch != api.KeyMatchUnknown {
+		t.Errorf("key match = %s", got.KeyMatch)
+	}
+}
+
+func TestParseJavaKeyStoreRejectsOversizedCount(t *testing.T) {
+	data := []byte{0xFE, 0xED, 0xFE, 0xED, 0x00, 0x00, 0x00, 0x02, 0xFF, 0xFF, 0xFF, 0xF0}
+	if entries, err := parseJavaKeyStore(data); err == nil {
+		t.Fatalf("parseJavaKeyStore = %v, want error", entries)
+	}
+
+	path := filepath.Join(t.TempDir(), "truncated.jks")
+	writeTestFile(t, path, string(data))
+	if got := describeCertificate(api.InventoryItem{CertificatePath: path}); got.Status == api.CertificateStatusOK {
+		t.Errorf("unexpected result %+v", got)
+	}
+}
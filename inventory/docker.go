@@ -3,11 +3,9 @@
 package inventory
 
 import (
+	"context"
 	"fmt"
-	"io/fs"
-	"log"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/certkit-io/certkit-agent/api"
@@ -19,7 +17,7 @@ func (DockerProvider) Name() string {
 	return "docker"
 }
 
-func (DockerProvider) Collect() ([]api.InventoryItem, error) {
+func (DockerProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
 	if !isContainerEnvironment() {
 		return nil, nil
 	}
@@ -29,71 +27,18 @@ func (DockerProvider) Collect() ([]api.InventoryItem, error) {
 		return nil, err
 	}
 
-	items := make([]api.InventoryItem, 0)
+	scan := newCertificateScan(filesystemScanLimits())
 	for _, mount := range mounts {
-		mountItems, err := collectMountCerts(mount)
-		if err != nil {
-			log.Printf("Docker inventory scan error for %s: %v", mount, err)
-			continue
+		if err := scan.walk(ctx, mount); err != nil {
+			return nil, err
 		}
-		items = append(items, mountItems...)
 	}
 
-	return items, nil
-}
-
-func collectMountCerts(mount string) ([]api.InventoryItem, error) {
-	certByBase := make(map[string]string)
-	keyByBase := make(map[string]string)
-	standalone := make([]string, 0)
-
-	err := filepath.WalkDir(mount, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if d.IsDir() {
-			return nil
-		}
-		ext := strings.ToLower(filepath.Ext(d.Name()))
-		base := strings.TrimSuffix(path, filepath.Ext(path))
-		switch ext {
-		case ".crt", ".pem":
-			if _, ok := certByBase[base]; !ok {
-				certByBase[base] = path
-			}
-		case ".key":
-			if _, ok := keyByBase[base]; !ok {
-				keyByBase[base] = path
-			}
-		case ".pfx", ".p12", ".jks":
-			standalone = append(standalone, path)
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, err
+	items := scan.items()
+	for i := range items {
+		items[i].Server = fmt.Sprintf("docker:%s", items[i].ConfigPath)
 	}
-
-	server := fmt.Sprintf("docker:%s", mount)
-	items := make([]api.InventoryItem, 0, len(certByBase)+len(standalone))
-	for base, certPath := range certByBase {
-		items = append(items, api.InventoryItem{
-			Server:          server,
-			ConfigPath:      mount,
-			CertificatePath: certPath,
-			KeyPath:         keyByBase[base],
-		})
-	}
-	for _, path := range standalone {
-		items = append(items, api.InventoryItem{
-			Server:          server,
-			ConfigPath:      mount,
-			CertificatePath: path,
-			KeyPath:         "",
-		})
-	}
-
-	return items, nil
+	return items, scan.err()
 }
 
 func dockerMounts() ([]string, error) {
@@ -158,7 +103,9 @@ func isContainerEnvironment() bool {
 }
 
 func isDockerMountWhitelisted(mountPoint string) bool {
-	for _, prefix := range dockerMountWhitelist() {
+	prefixes := append(dockerMountWhitelist(), inventoryConfig().DockerMounts...)
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
 		if mountPoint == prefix || strings.HasPrefix(mountPoint, prefix+"/") {
 			return true
 		}
@@ -0,0 +1,127 @@
+//go:build linux
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/dockerapi"
+)
+
+// DockerEngineProvider asks the Docker (or Podman) Engine API on the host
+// which containers are running and scans their bind mounts and volumes for
+// certificate files. Only mounts whose destination is on the same whitelist
+// DockerProvider uses (plus docker_mounts) are scanned, so data volumes are
+// left alone. Unlike DockerProvider it runs on the host, not inside a
+// container.
+type DockerEngineProvider struct{}
+
+func (DockerEngineProvider) Name() string {
+	return "docker-engine"
+}
+
+func (DockerEngineProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	socket := dockerapi.FindSocket(inventoryConfig().DockerSocket)
+	if socket == "" {
+		return nil, nil
+	}
+
+	client := dockerapi.NewClient(socket)
+	containers, err := client.ListContainers(ctx, nil)
+	if err != nil {
+		return nil, &FileError{Path: socket, Err: err}
+	}
+
+	items := make([]api.InventoryItem, 0)
+	var errs []error
+	for _, container := range containers {
+		containerItems, err := collectContainerCerts(ctx, container)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			errs = append(errs, err)
+		}
+		items = append(items, containerItems...)
+	}
+	return items, errors.Join(errs...)
+}
+
+// collectContainerCerts scans one container's mounts together, so a key in
+// one mount is paired with its certificate in another.
+func collectContainerCerts(ctx context.Context, container dockerapi.Container) ([]api.InventoryItem, error) {
+	scan := newCertificateScan(filesystemScanLimits())
+	mounts := make([]dockerapi.Mount, 0, len(container.Mounts))
+	for _, mount := range container.Mounts {
+		if !isScannableMount(mount) {
+			continue
+		}
+		mount.Source = filepath.Clean(mount.Source)
+		// Sources that are not visible from here, for example when the agent
+		// itself runs in a container, are skipped.
+		if _, err := os.Stat(mount.Source); err != nil {
+			continue
+		}
+		if isExcludedPath(mount.Source) {
+			continue
+		}
+		mounts = append(mounts, mount)
+		if err := scan.walk(ctx, mount.Source); err != nil {
+			return nil, err
+		}
+	}
+
+	items := scan.items()
+	for i := range items {
+		items[i].Server = "docker:" + container.Name()
+		items[i].Container = &api.InventoryContainer{
+			Id:              container.Id,
+			Name:            container.Name(),
+			Image:           container.Image,
+			ComposeProject:  container.ComposeProject(),
+			CertificatePath: containerPath(mounts, items[i].CertificatePath),
+			KeyPath:         containerPath(mounts, items[i].KeyPath),
+		}
+	}
+	return items, scan.err()
+}
+
+func isScannableMount(mount dockerapi.Mount) bool {
+	if mount.Type != "bind" && mount.Type != "volume" {
+		return false
+	}
+	if !isDockerMountWhitelisted(filepath.Clean(mount.Destination)) {
+		return false
+	}
+	source := filepath.Clean(mount.Source)
+	if mount.Source == "" || source == "/" {
+		return false
+	}
+	for _, prefix := range []string{"/proc", "/sys", "/dev"} {
+		if source == prefix || strings.HasPrefix(source, prefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// containerPath maps a host path to where the container sees it.
+func containerPath(mounts []dockerapi.Mount, hostPath string) string {
+	if hostPath == "" {
+		return ""
+	}
+	for _, mount := range mounts {
+		if hostPath == mount.Source {
+			return mount.Destination
+		}
+		if rel, ok := strings.CutPrefix(hostPath, mount.Source+"/"); ok {
+			return filepath.Join(mount.Destination, rel)
+		}
+	}
+	return ""
+}
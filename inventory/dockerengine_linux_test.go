@@ -0,0 +1,81 @@
+//go:build linux
+
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/dockerapi"
+)
+
+func TestDockerEngineProviderScansMounts(t *testing.T) {
+	dir := t.TempDir()
+	certs := filepath.Join(dir, "certs")
+	keys := filepath.Join(dir, "keys")
+	ca := newTestCertificate(t, "Test Root", nil)
+	leaf := newTestCertificate(t, "www.example.com", &ca)
+	writeTestFile(t, filepath.Join(certs, "site.crt"), leaf.certPEM())
+	writeTestFile(t, filepath.Join(keys, "site.key"), leaf.keyPEM(t))
+	// Data volumes are not scanned unless listed in docker_mounts.
+	data := filepath.Join(dir, "data")
+	custom := filepath.Join(dir, "custom")
+	writeTestFile(t, filepath.Join(data, "uploaded.crt"), newTestCertificate(t, "upload.example.com", &ca).certPEM())
+	writeTestFile(t, filepath.Join(custom, "api.crt"), newTestCertificate(t, "api.example.com", &ca).certPEM())
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode([]dockerapi.Container{{
+			Id:     "0123456789abcdef",
+			Names:  []string{"/web-nginx-1"},
+			Image:  "nginx:1.27",
+			Labels: map[string]string{"com.docker.compose.project": "web"},
+			Mounts: []dockerapi.Mount{
+				{Type: "bind", Source: certs, Destination: "/etc/nginx/certs"},
+				{Type: "bind", Source: keys, Destination: "/etc/nginx/keys"},
+				{Type: "bind", Source: "/proc", Destination: "/host/proc"},
+				{Type: "volume", Source: data, Destination: "/var/lib/app/data"},
+				{Type: "volume", Source: custom, Destination: "/app/tls"},
+			},
+		}})
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	previous := config.CurrentConfig.Inventory
+	defer func() { config.CurrentConfig.Inventory = previous }()
+	config.CurrentConfig.Inventory = &config.InventoryConfig{DockerSocket: socket, DockerMounts: []string{"/app/tls/"}}
+
+	items, err := DockerEngineProvider{}.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2: %+v", len(items), items)
+	}
+	if items[1].CertificatePath != filepath.Join(custom, "api.crt") || items[1].Container.CertificatePath != "/app/tls/api.crt" {
+		t.Errorf("docker_mounts item = %+v", items[1])
+	}
+	item := items[0]
+	if item.Server != "docker:web-nginx-1" || item.CertificatePath != filepath.Join(certs, "site.crt") || item.KeyPath != filepath.Join(keys, "site.key") {
+		t.Errorf("item = %+v", item)
+	}
+	container := item.Container
+	if container == nil || container.ComposeProject != "web" || container.Image != "nginx:1.27" ||
+		container.CertificatePath != "/etc/nginx/certs/site.crt" || container.KeyPath != "/etc/nginx/keys/site.key" {
+		t.Errorf("container = %+v", container)
+	}
+}
@@ -1,54 +1,99 @@
 package inventory
 
 import (
+	"errors"
+	"math"
+	"net/netip"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/certkit-io/certkit-agent/api"
 )
 
 var fqdnRegex = regexp.MustCompile(`(?i)^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)+$`)
 
-func joinDomains(domains []string) string {
+// uniqueDomains drops repeated names, keeping the first occurrence.
+func uniqueDomains(domains []api.InventoryDomain) []api.InventoryDomain {
 	if len(domains) == 0 {
-		return ""
+		return nil
 	}
 	seen := make(map[string]struct{}, len(domains))
-	unique := make([]string, 0, len(domains))
+	unique := make([]api.InventoryDomain, 0, len(domains))
 	for _, domain := range domains {
-		if domain == "" {
+		if domain.Name == "" {
 			continue
 		}
-		if _, ok := seen[domain]; ok {
+		if _, ok := seen[domain.Name]; ok {
 			continue
 		}
-		seen[domain] = struct{}{}
+		seen[domain.Name] = struct{}{}
 		unique = append(unique, domain)
 	}
-	return strings.Join(unique, ",")
+	return unique
 }
 
-func normalizeDomain(token string) (string, bool) {
+// parseDomain classifies a name taken from server configuration: a DNS name,
+// a wildcard, or an IP address. Internationalized names are converted to
+// punycode. Ports, quotes and trailing punctuation are stripped; anything
+// else that is not a usable certificate name is rejected.
+func parseDomain(token string) (api.InventoryDomain, bool) {
 	token = strings.TrimSpace(token)
-	token = strings.Trim(token, "\"';")
+	token = strings.Trim(token, "\"';,")
+	token = stripPort(token)
+	token = strings.TrimSuffix(token, ".")
 	if token == "" {
-		return "", false
+		return api.InventoryDomain{}, false
 	}
-	token = strings.Trim(token, ",")
-	token = strings.TrimSuffix(token, ";")
-	token = stripPort(token)
+
+	if addr, err := netip.ParseAddr(token); err == nil {
+		addr = addr.Unmap()
+		if addr.IsUnspecified() || addr.Zone() != "" {
+			return api.InventoryDomain{}, false
+		}
+		domainType := api.DomainTypeIPv6
+		if addr.Is4() {
+			domainType = api.DomainTypeIPv4
+		}
+		return api.InventoryDomain{Name: addr.String(), Type: domainType}, true
+	}
+
+	domainType := api.DomainTypeDNS
+	prefix := ""
 	if after, ok := strings.CutPrefix(token, "*."); ok {
+		domainType = api.DomainTypeWildcard
+		prefix = "*."
 		token = after
 	}
-	if token == "" || strings.Contains(token, "*") {
-		return "", false
+	if strings.Contains(token, "*") {
+		return api.InventoryDomain{}, false
+	}
+
+	unicodeName := strings.ToLower(token)
+	asciiName, err := domainToASCII(unicodeName)
+	if err != nil || !fqdnRegex.MatchString(asciiName) {
+		return api.InventoryDomain{}, false
 	}
-	if !fqdnRegex.MatchString(token) {
-		return "", false
+	domain := api.InventoryDomain{Name: prefix + asciiName, Type: domainType}
+	if asciiName != unicodeName {
+		domain.Unicode = prefix + unicodeName
 	}
-	return strings.ToLower(token), true
+	return domain, true
 }
 
+// stripPort removes a trailing :port, including from bracketed IPv6
+// addresses. Bare IPv6 addresses are returned unchanged.
 func stripPort(value string) string {
+	if strings.HasPrefix(value, "[") {
+		if end := strings.Index(value, "]"); end > 0 {
+			return value[1:end]
+		}
+		return value
+	}
+	if strings.Count(value, ":") > 1 {
+		return value
+	}
 	host, port, found := strings.Cut(value, ":")
 	if !found {
 		return value
@@ -61,3 +106,129 @@ func stripPort(value string) string {
 	}
 	return host
 }
+
+// domainToASCII converts each non-ASCII label to its xn-- punycode form.
+// Names are lowercased by the caller; no further Unicode normalization is
+// applied.
+func domainToASCII(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if !utf8.ValidString(label) {
+			return "", errors.New("invalid UTF-8 in domain name")
+		}
+		encoded, err := punycodeEncode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Punycode parameters from RFC 3492 section 5.
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// punycodeEncode implements the encoding procedure of RFC 3492 section 6.3.
+func punycodeEncode(label string) (string, error) {
+	runes := []rune(label)
+	output := make([]byte, 0, len(label))
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+	basic := len(output)
+	handled := basic
+	if basic > 0 {
+		output = append(output, '-')
+	}
+
+	n := rune(punycodeInitialN)
+	delta := 0
+	bias := punycodeInitialBias
+	for handled < len(runes) {
+		next := rune(math.MaxInt32)
+		for _, r := range runes {
+			if r >= n && r < next {
+				next = r
+			}
+		}
+		if int(next-n) > (math.MaxInt32-delta)/(handled+1) {
+			return "", errors.New("punycode overflow")
+		}
+		delta += int(next-n) * (handled + 1)
+		n = next
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			output = append(output, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(output), nil
+}
+
+func punycodeAdapt(delta int, points int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
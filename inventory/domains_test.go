@@ -0,0 +1,44 @@
+package inventory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/api"
+)
+
+// domainNames joins names for compact comparisons in provider tests.
+func domainNames(domains []api.InventoryDomain) string {
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestParseDomain(t *testing.T) {
+	tests := []struct {
+		token string
+		want  api.InventoryDomain
+		ok    bool
+	}{
+		{token: "WWW.Example.com", want: api.InventoryDomain{Name: "www.example.com", Type: api.DomainTypeDNS}, ok: true},
+		{token: "example.com:443;", want: api.InventoryDomain{Name: "example.com", Type: api.DomainTypeDNS}, ok: true},
+		{token: "*.example.com", want: api.InventoryDomain{Name: "*.example.com", Type: api.DomainTypeWildcard}, ok: true},
+		{token: "192.0.2.10:8443", want: api.InventoryDomain{Name: "192.0.2.10", Type: api.DomainTypeIPv4}, ok: true},
+		{token: "[2001:db8::1]:443", want: api.InventoryDomain{Name: "2001:db8::1", Type: api.DomainTypeIPv6}, ok: true},
+		{token: "2001:DB8::2", want: api.InventoryDomain{Name: "2001:db8::2", Type: api.DomainTypeIPv6}, ok: true},
+		{token: "bücher.example", want: api.InventoryDomain{Name: "xn--bcher-kva.example", Type: api.DomainTypeDNS, Unicode: "bücher.example"}, ok: true},
+		{token: "*.München.de", want: api.InventoryDomain{Name: "*.xn--mnchen-3ya.de", Type: api.DomainTypeWildcard, Unicode: "*.münchen.de"}, ok: true},
+		{token: "0.0.0.0"},
+		{token: "www.*.example.com"},
+		{token: "_"},
+		{token: "localhost"},
+	}
+	for _, tt := range tests {
+		got, ok := parseDomain(tt.token)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseDomain(%q) = %+v, %t; want %+v, %t", tt.token, got, ok, tt.want, tt.ok)
+		}
+	}
+}
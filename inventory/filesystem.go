@@ -0,0 +1,331 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const (
+	defaultScanMaxDepth    = 6
+	defaultScanMaxFileSize = 256 * 1024
+	defaultScanMaxFiles    = 20000
+)
+
+// trustStoreNames are Java trust stores, which hold only CA certificates and
+// are usually protected by a password the agent does not know.
+var trustStoreNames = map[string]struct{}{
+	"cacerts":     {},
+	"jssecacerts": {},
+}
+
+// FilesystemProvider finds certificate files that no known server config
+// references by scanning common certificate directories. It runs after the
+// config-based providers so files they already reported are skipped.
+type FilesystemProvider struct{}
+
+func (FilesystemProvider) Name() string {
+	return "filesystem"
+}
+
+func (p FilesystemProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	return p.CollectKnown(ctx, nil)
+}
+
+func (FilesystemProvider) CollectKnown(ctx context.Context, known []api.InventoryItem) ([]api.InventoryItem, error) {
+	scanConfig := inventoryConfig().Filesystem
+	var extraRoots []string
+	if scanConfig != nil {
+		extraRoots = scanConfig.Roots
+	}
+	roots, err := expandConfigGlobs(append(filesystemRoots(), extraRoots...))
+	if err != nil {
+		return nil, err
+	}
+
+	scan := newCertificateScan(filesystemScanLimits())
+	for _, item := range known {
+		if filepath.IsAbs(item.CertificatePath) {
+			scan.skip[canonicalPath(item.CertificatePath)] = struct{}{}
+		}
+	}
+	for _, root := range roots {
+		if err := scan.walk(ctx, root); err != nil {
+			return nil, err
+		}
+	}
+
+	items := scan.items()
+	for i := range items {
+		items[i].Server = "filesystem"
+	}
+	enrichItems(items)
+	return items, scan.err()
+}
+
+func filesystemScanLimits() certificateScanLimits {
+	limits := certificateScanLimits{
+		maxDepth:    defaultScanMaxDepth,
+		maxFileSize: defaultScanMaxFileSize,
+		maxFiles:    defaultScanMaxFiles,
+	}
+	scanConfig := inventoryConfig().Filesystem
+	if scanConfig == nil {
+		return limits
+	}
+	if scanConfig.MaxDepth > 0 {
+		limits.maxDepth = scanConfig.MaxDepth
+	}
+	if scanConfig.MaxFileSize > 0 {
+		limits.maxFileSize = scanConfig.MaxFileSize
+	}
+	if scanConfig.MaxFiles > 0 {
+		limits.maxFiles = scanConfig.MaxFiles
+	}
+	return limits
+}
+
+type certificateScanLimits struct {
+	maxDepth    int
+	maxFileSize int64
+	maxFiles    int
+}
+
+type scannedCertificate struct {
+	root      string
+	path      string
+	symlink   bool
+	publicKey string
+	keyPath   string
+}
+
+// certificateScan walks directory trees looking for certificates and private
+// keys, recognising PEM, DER, PKCS#12 and JKS files by their contents. Keys
+// are paired with certificates by public key, across every root scanned.
+type certificateScan struct {
+	limits       certificateScanLimits
+	files        int
+	limitReached bool
+	skip         map[string]struct{}
+	certificates []*scannedCertificate
+	byCanonical  map[string]*scannedCertificate
+	keys         map[string]string
+	errors       []error
+}
+
+func newCertificateScan(limits certificateScanLimits) *certificateScan {
+	return &certificateScan{
+		limits:      limits,
+		skip:        make(map[string]struct{}),
+		byCanonical: make(map[string]*scannedCertificate),
+		keys:        make(map[string]string),
+	}
+}
+
+// walk scans root. It only returns an error when ctx is cancelled; problems
+// with individual files are collected for err.
+func (s *certificateScan) walk(ctx context.Context, root string) error {
+	root = filepath.Clean(root)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if path != root && !os.IsNotExist(err) {
+				s.errors = append(s.errors, &FileError{Path: path, Err: err})
+			}
+			return nil
+		}
+		if isExcludedPath(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && scanDepth(root, path) > s.limits.maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if s.limitReached {
+			return filepath.SkipAll
+		}
+		if _, ok := trustStoreNames[d.Name()]; ok {
+			return nil
+		}
+
+		symlink := d.Type()&fs.ModeSymlink != 0
+		if !symlink && !d.Type().IsRegular() {
+			return nil
+		}
+		// Symlinks are followed to files only, so links to directories
+		// cannot loop.
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() > s.limits.maxFileSize {
+			return nil
+		}
+
+		s.files++
+		if s.files > s.limits.maxFiles {
+			s.limitReached = true
+			s.errors = append(s.errors, &FileError{Path: root, Err: fmt.Errorf("scan stopped after %d files", s.limits.maxFiles)})
+			return filepath.SkipAll
+		}
+		s.scanFile(root, path, symlink)
+		return nil
+	})
+}
+
+func scanDepth(root string, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+func (s *certificateScan) scanFile(root string, path string, symlink bool) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		s.errors = append(s.errors, &FileError{Path: path, Err: err})
+		return
+	}
+	// The file may have grown since it was stat'ed.
+	if int64(len(data)) > s.limits.maxFileSize || !looksLikeCertificateData(data) {
+		return
+	}
+
+	if key, ok := parseKeyFile(data); ok {
+		if public := publicKeyID(key); public != "" {
+			if _, exists := s.keys[public]; !exists {
+				s.keys[public] = path
+			}
+		}
+		return
+	}
+
+	loaded, err := loadCertificate(path, data)
+	if err != nil {
+		// A PKCS#12 file we cannot open is still worth reporting; anything
+		// else that failed to parse was not a certificate.
+		if loaded == nil || loaded.format != "pfx" {
+			return
+		}
+	} else if isTrustBundle(loaded) {
+		return
+	}
+
+	canonical := canonicalPath(path)
+	if _, ok := s.skip[canonical]; ok {
+		return
+	}
+	if existing, ok := s.byCanonical[canonical]; ok {
+		// Prefer the symlinked name (letsencrypt's live/), which is what
+		// servers are configured with.
+		if symlink && !existing.symlink {
+			existing.root, existing.path, existing.symlink = root, path, true
+		}
+		return
+	}
+
+	scanned := &scannedCertificate{root: root, path: path, symlink: symlink}
+	if loaded.key != nil || loaded.format == "pfx" || loaded.format == "jks" {
+		scanned.keyPath = path
+	}
+	if len(loaded.certificates) > 0 {
+		scanned.publicKey = publicKeyID(orderChain(loaded.certificates)[0].PublicKey)
+	}
+	s.byCanonical[canonical] = scanned
+	s.certificates = append(s.certificates, scanned)
+}
+
+// items returns one item per certificate found, with ConfigPath set to the
+// root it was found under.
+func (s *certificateScan) items() []api.InventoryItem {
+	items := make([]api.InventoryItem, 0, len(s.certificates))
+	for _, cert := range s.certificates {
+		keyPath := cert.keyPath
+		if keyPath == "" && cert.publicKey != "" {
+			keyPath = s.keys[cert.publicKey]
+		}
+		items = append(items, api.InventoryItem{
+			ConfigPath:      cert.root,
+			CertificatePath: cert.path,
+			KeyPath:         keyPath,
+		})
+	}
+	return items
+}
+
+func (s *certificateScan) err() error {
+	return errors.Join(s.errors...)
+}
+
+// looksLikeCertificateData is a cheap filter run before parsing: PEM text, a
+// Java keystore header, or an ASN.1 SEQUENCE (DER certificates and keys,
+// PKCS#12).
+func looksLikeCertificateData(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	return data[0] == 0x30 || hasJavaKeyStoreHeader(data) || bytes.Contains(data, []byte("-----BEGIN "))
+}
+
+// parseKeyFile recognises files that hold a private key and no certificate.
+func parseKeyFile(data []byte) (crypto.PrivateKey, bool) {
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		if bytes.Contains(data, []byte("CERTIFICATE-----")) {
+			return nil, false
+		}
+		key, err := parsePrivateKeyPEM(data)
+		return key, err == nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		return key, true
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(data); err == nil {
+		return key, true
+	}
+	if key, err := x509.ParseECPrivateKey(data); err == nil {
+		return key, true
+	}
+	return nil, false
+}
+
+// isTrustBundle reports whether a file holds only CA certificates and no key,
+// as system and application trust stores do.
+func isTrustBundle(loaded *loadedCertificate) bool {
+	if loaded.key != nil {
+		return false
+	}
+	for _, cert := range loaded.certificates {
+		if !cert.IsCA {
+			return false
+		}
+	}
+	return true
+}
+
+// publicKeyID identifies a public key, or the public half of a private key,
+// by its PKIX encoding.
+func publicKeyID(key any) string {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	return string(der)
+}
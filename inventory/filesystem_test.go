@@ -0,0 +1,78 @@
+package inventory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/api"
+)
+
+func TestCertificateScanDetectsByContent(t *testing.T) {
+	root := t.TempDir()
+	ca := newTestCertificate(t, "Test Root", nil)
+	leaf := newTestCertificate(t, "www.example.com", &ca)
+	other := newTestCertificate(t, "api.example.com", &ca)
+	deep := newTestCertificate(t, "deep.example.com", &ca)
+
+	// Names give nothing away; pairing is by public key.
+	writeTestFile(t, filepath.Join(root, "app", "server.txt"), leaf.certPEM()+ca.certPEM())
+	writeTestFile(t, filepath.Join(root, "private", "secret"), leaf.keyPEM(t))
+	writeTestFile(t, filepath.Join(root, "app", "api.bin"), string(other.cert.Raw))
+	writeTestFile(t, filepath.Join(root, "trust", "ca-bundle.crt"), ca.certPEM())
+	writeTestFile(t, filepath.Join(root, "notes.pem"), "not a certificate")
+	// Keystore headers claiming more entries than the file holds, or an
+	// unknown version, are skipped.
+	writeTestFile(t, filepath.Join(root, "huge.jks"), "\xfe\xed\xfe\xed\x00\x00\x00\x02\xff\xff\xff\xf0")
+	writeTestFile(t, filepath.Join(root, "v9.jks"), "\xfe\xed\xfe\xed\x00\x00\x00\x09\x00\x00\x00\x01")
+	writeTestFile(t, filepath.Join(root, "a", "b", "c", "deep.pem"), deep.certPEM())
+	if err := os.Symlink(filepath.Join(root, "app", "server.txt"), filepath.Join(root, "current.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := newCertificateScan(certificateScanLimits{maxDepth: 2, maxFileSize: defaultScanMaxFileSize, maxFiles: 100})
+	if err := scan.walk(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+	if err := scan.err(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]api.InventoryItem)
+	for _, item := range scan.items() {
+		got[item.CertificatePath] = item
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d items, want 2: %+v", len(got), got)
+	}
+	server, ok := got[filepath.Join(root, "current.pem")]
+	if !ok {
+		t.Fatalf("symlinked certificate not preferred: %+v", got)
+	}
+	if server.KeyPath != filepath.Join(root, "private", "secret") || server.ConfigPath != root {
+		t.Errorf("server = %+v", server)
+	}
+	if der := got[filepath.Join(root, "app", "api.bin")]; der.KeyPath != "" {
+		t.Errorf("der key = %q, want none", der.KeyPath)
+	}
+}
+
+func TestCertificateScanFileLimit(t *testing.T) {
+	root := t.TempDir()
+	ca := newTestCertificate(t, "Test Root", nil)
+	for _, name := range []string{"a.pem", "b.pem", "c.pem"} {
+		writeTestFile(t, filepath.Join(root, name), newTestCertificate(t, name+".example.com", &ca).certPEM())
+	}
+
+	scan := newCertificateScan(certificateScanLimits{maxDepth: 1, maxFileSize: defaultScanMaxFileSize, maxFiles: 2})
+	if err := scan.walk(context.Background(), root); err != nil {
+		t.Fatal(err)
+	}
+	if len(scan.items()) != 2 {
+		t.Errorf("got %d items, want 2", len(scan.items()))
+	}
+	if errs := inventoryErrors("filesystem", scan.err()); len(errs) != 1 || errs[0].Path != root {
+		t.Errorf("errors = %+v", errs)
+	}
+}
@@ -1,7 +1,11 @@
 package inventory
 
 import (
-	"log"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/certkit-io/certkit-agent/api"
@@ -14,104 +18,314 @@ func (HaproxyProvider) Name() string {
 	return "haproxy"
 }
 
-func (HaproxyProvider) Collect() ([]api.InventoryItem, error) {
-	configFiles, err := expandConfigGlobs([]string{
-		"/etc/haproxy/haproxy.cfg",
-		"/etc/haproxy/conf.d/*.cfg",
-		"/etc/haproxy/conf.d/*.conf",
-		"/usr/local/etc/haproxy/haproxy.cfg",
-		"/usr/local/etc/haproxy/conf.d/*.cfg",
-		"/usr/local/etc/haproxy/conf.d/*.conf",
-	})
-	if err != nil {
-		return nil, err
+// haproxyConfigRoots are the directories HAProxy installs its configuration
+// in. haproxy.cfg is read first, then conf.d in lexical order, the same way
+// packaged service units pass them to haproxy -f.
+func haproxyConfigRoots() []string {
+	return []string{
+		"/etc/haproxy",
+		"/usr/local/etc/haproxy",
 	}
+}
 
+func (HaproxyProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
 	items := make([]api.InventoryItem, 0)
-	for _, path := range configFiles {
-		certs, keys, domains, err := parseHaproxyConfig(path)
+	var errs []error
+	configSets := make([][]string, 0)
+	for _, root := range haproxyConfigRoots() {
+		configFiles, err := haproxyConfigFiles(root)
 		if err != nil {
-			log.Printf("Inventory parse error for %s: %v", path, err)
-			continue
+			return nil, err
+		}
+		configSets = append(configSets, configFiles)
+	}
+	extraFiles, err := expandConfigGlobs(extraConfigGlobs("haproxy"))
+	if err != nil {
+		return nil, err
+	}
+	configSets = append(configSets, extraFiles)
+
+	for _, configFiles := range configSets {
+		parser := &haproxyParser{}
+		for _, path := range configFiles {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			fileItems, err := parser.parseFile(path)
+			if err != nil {
+				errs = append(errs, &FileError{Path: path, Err: err})
+				continue
+			}
+			items = append(items, fileItems...)
 		}
+		errs = append(errs, parser.errors...)
+	}
 
-		pairs := len(certs)
-		if len(keys) < pairs {
-			pairs = len(keys)
+	return items, errors.Join(errs...)
+}
+
+// HaproxyConfigFiles returns the configuration files HAProxy on this host
+// runs with, found the way the provider finds them: haproxy.cfg and its
+// conf.d fragments from the first install location that has one, otherwise
+// the extra config paths configured for haproxy.
+func HaproxyConfigFiles() ([]string, error) {
+	for _, root := range haproxyConfigRoots() {
+		configFiles, err := haproxyConfigFiles(root)
+		if err != nil {
+			return nil, err
 		}
-		for i := 0; i < pairs; i++ {
-			items = append(items, api.InventoryItem{
-				Server:          "haproxy",
-				ConfigPath:      path,
-				CertificatePath: certs[i],
-				KeyPath:         keys[i],
-				Domains:         joinDomains(domains),
-			})
+		if len(configFiles) > 0 && filepath.Base(configFiles[0]) == "haproxy.cfg" {
+			return configFiles, nil
 		}
 	}
+	return expandConfigGlobs(extraConfigGlobs("haproxy"))
+}
 
-	return items, nil
+func haproxyConfigFiles(root string) ([]string, error) {
+	files, err := expandConfigGlobs([]string{filepath.Join(root, "haproxy.cfg")})
+	if err != nil {
+		return nil, err
+	}
+	fragments, err := expandConfigGlobs([]string{
+		filepath.Join(root, "conf.d", "*.cfg"),
+		filepath.Join(root, "conf.d", "*.conf"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fragments)
+	return append(files, fragments...), nil
+}
+
+// haproxyParser carries global settings across the files of one HAProxy
+// configuration, since crt-base and key-base in the global section apply to
+// every bind line that follows, including those in later files.
+type haproxyParser struct {
+	crtBase string
+	keyBase string
+	errors  []error
+}
+
+// haproxyProxy is a frontend or listen section: the certificates its bind
+// lines load and the host names its ACLs route on.
+type haproxyProxy struct {
+	items   []api.InventoryItem
+	sniSets []bool
+	domains []api.InventoryDomain
+}
+
+var haproxySectionKeywords = map[string]struct{}{
+	"global":      {},
+	"defaults":    {},
+	"frontend":    {},
+	"backend":     {},
+	"listen":      {},
+	"resolvers":   {},
+	"peers":       {},
+	"userlist":    {},
+	"mailers":     {},
+	"program":     {},
+	"cache":       {},
+	"ring":        {},
+	"http-errors": {},
+	"crt-store":   {},
 }
 
-func parseHaproxyConfig(path string) ([]string, []string, []string, error) {
+func (p *haproxyParser) parseFile(path string) ([]api.InventoryItem, error) {
 	data, err := utils.ReadFileBytes(path)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
-	var certs []string
-	var keys []string
-	var domains []string
+	items := make([]api.InventoryItem, 0)
+	section := ""
+	var proxy *haproxyProxy
+	flush := func() {
+		if proxy == nil {
+			return
+		}
+		for i := range proxy.items {
+			// crt-list SNI filters are more precise than the proxy's ACLs.
+			if !proxy.sniSets[i] {
+				proxy.items[i].Domains = uniqueDomains(proxy.domains)
+			}
+		}
+		items = append(items, proxy.items...)
+		proxy = nil
+	}
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
+	for _, line := range strings.Split(string(data), "\n") {
 		trimmed := stripHaproxyComment(line)
 		if trimmed == "" {
 			continue
 		}
 		fields := strings.Fields(trimmed)
-		if len(fields) == 0 {
+		keyword := strings.ToLower(fields[0])
+
+		if _, ok := haproxySectionKeywords[keyword]; ok {
+			flush()
+			section = keyword
+			if section == "frontend" || section == "listen" {
+				proxy = &haproxyProxy{}
+			}
 			continue
 		}
-		for i := 0; i < len(fields)-1; i++ {
-			token := fields[i]
-			if strings.EqualFold(token, "crt") {
-				certPath := cleanConfigValue(fields[i+1])
-				if certPath != "" {
-					certs = append(certs, certPath)
-					keys = append(keys, certPath)
+
+		switch section {
+		case "global":
+			switch keyword {
+			case "crt-base":
+				if len(fields) > 1 {
+					p.crtBase = cleanConfigValue(fields[1])
+				}
+			case "key-base":
+				if len(fields) > 1 {
+					p.keyBase = cleanConfigValue(fields[1])
 				}
+			}
+		case "frontend", "listen":
+			if keyword == "bind" {
+				p.parseBind(path, fields[1:], proxy)
 				continue
 			}
-			if strings.EqualFold(token, "crt-list") {
-				listPath := cleanConfigValue(fields[i+1])
-				if listPath == "" {
-					continue
-				}
-				listCerts, err := parseHaproxyCrtList(listPath)
-				if err != nil {
-					return nil, nil, nil, err
-				}
-				for _, certPath := range listCerts {
-					certs = append(certs, certPath)
-					keys = append(keys, certPath)
+			proxy.domains = append(proxy.domains, parseHaproxyDomains(trimmed)...)
+		}
+	}
+	flush()
+
+	return items, nil
+}
+
+func (p *haproxyParser) parseBind(configPath string, args []string, proxy *haproxyProxy) {
+	for i := 0; i < len(args)-1; i++ {
+		switch strings.ToLower(args[i]) {
+		case "crt":
+			certPath := cleanConfigValue(args[i+1])
+			if certPath == "" {
+				continue
+			}
+			for _, item := range p.certificateItems(configPath, certPath) {
+				proxy.items = append(proxy.items, item)
+				proxy.sniSets = append(proxy.sniSets, false)
+			}
+		case "crt-list":
+			listPath := cleanConfigValue(args[i+1])
+			if listPath == "" {
+				continue
+			}
+			if !filepath.IsAbs(listPath) {
+				listPath = filepath.Join(filepath.Dir(configPath), listPath)
+			}
+			entries, err := parseHaproxyCrtList(listPath)
+			if err != nil {
+				p.errors = append(p.errors, &FileError{Path: listPath, Err: err})
+				continue
+			}
+			for _, entry := range entries {
+				for _, item := range p.certificateItems(configPath, entry.certPath) {
+					item.Domains = uniqueDomains(entry.domains)
+					proxy.items = append(proxy.items, item)
+					proxy.sniSets = append(proxy.sniSets, len(entry.domains) > 0)
 				}
 			}
 		}
+	}
+}
+
+// certificateItems resolves a crt argument against crt-base. A directory
+// loads every certificate file in it, skipping the sidecar files HAProxy reads
+// alongside each certificate.
+func (p *haproxyParser) certificateItems(configPath string, certPath string) []api.InventoryItem {
+	if !filepath.IsAbs(certPath) && p.crtBase != "" {
+		certPath = filepath.Join(p.crtBase, certPath)
+	}
+
+	paths := []string{certPath}
+	if info, err := os.Stat(certPath); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(certPath)
+		if err != nil {
+			p.errors = append(p.errors, &FileError{Path: certPath, Err: err})
+			return nil
+		}
+		paths = paths[:0]
+		for _, entry := range entries {
+			if entry.IsDir() || isHaproxySidecar(entry.Name()) || isExcludedPath(filepath.Join(certPath, entry.Name())) {
+				continue
+			}
+			paths = append(paths, filepath.Join(certPath, entry.Name()))
+		}
+	}
+
+	items := make([]api.InventoryItem, 0, len(paths))
+	for _, path := range paths {
+		item := api.InventoryItem{
+			Server:          "haproxy",
+			ConfigPath:      configPath,
+			CertificatePath: path,
+			KeyPath:         p.keyPathFor(path),
+		}
+		if exists, _ := utils.FileExists(path + ".issuer"); exists {
+			item.ChainPath = path + ".issuer"
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// keyPathFor finds the private key HAProxy would use for certPath: the PEM
+// itself when it bundles the key, otherwise a ".key" sidecar, looked up in
+// key-base first when one is configured. It returns "" when there is no key.
+func (p *haproxyParser) keyPathFor(certPath string) string {
+	if data, err := utils.ReadFileBytes(certPath); err == nil && strings.Contains(string(data), "PRIVATE KEY-----") {
+		return certPath
+	}
+
+	candidates := make([]string, 0, 3)
+	if p.keyBase != "" {
+		// key-base mirrors the layout below crt-base.
+		rel := filepath.Base(certPath)
+		if p.crtBase != "" {
+			if under, err := filepath.Rel(p.crtBase, certPath); err == nil && under != ".." && !strings.HasPrefix(under, ".."+string(filepath.Separator)) {
+				rel = under
+			}
+		}
+		candidates = append(candidates, filepath.Join(p.keyBase, rel+".key"))
+	}
+	candidates = append(candidates,
+		certPath+".key",
+		strings.TrimSuffix(certPath, filepath.Ext(certPath))+".key",
+	)
+	for _, candidate := range candidates {
+		if exists, _ := utils.FileExists(candidate); exists {
+			return candidate
+		}
+	}
+	return ""
+}
 
-		domains = append(domains, parseHaproxyDomains(trimmed)...)
+func isHaproxySidecar(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".key", ".issuer", ".ocsp", ".sctl":
+		return true
 	}
+	return false
+}
 
-	return certs, keys, domains, nil
+type haproxyCrtListEntry struct {
+	certPath string
+	domains  []api.InventoryDomain
 }
 
-func parseHaproxyCrtList(path string) ([]string, error) {
+// parseHaproxyCrtList reads a crt-list file. Each line is a certificate path,
+// an optional [bracketed] list of SSL options and optional SNI filters;
+// negative filters (!name) exclude names and are not reported.
+func parseHaproxyCrtList(path string) ([]haproxyCrtListEntry, error) {
 	data, err := utils.ReadFileBytes(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var certs []string
+	var entries []haproxyCrtListEntry
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
 		trimmed := stripHaproxyComment(line)
@@ -119,19 +333,36 @@ func parseHaproxyCrtList(path string) ([]string, error) {
 			continue
 		}
 		fields := strings.Fields(trimmed)
-		if len(fields) == 0 {
+		entry := haproxyCrtListEntry{certPath: cleanConfigValue(fields[0])}
+		if entry.certPath == "" {
 			continue
 		}
-		certPath := cleanConfigValue(fields[0])
-		if certPath != "" {
-			certs = append(certs, certPath)
+
+		inOptions := false
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "[") {
+				inOptions = true
+			}
+			if inOptions {
+				if strings.HasSuffix(field, "]") {
+					inOptions = false
+				}
+				continue
+			}
+			if strings.HasPrefix(field, "!") {
+				continue
+			}
+			if domain, ok := parseDomain(field); ok {
+				entry.domains = append(entry.domains, domain)
+			}
 		}
+		entries = append(entries, entry)
 	}
 
-	return certs, nil
+	return entries, nil
 }
 
-func parseHaproxyDomains(line string) []string {
+func parseHaproxyDomains(line string) []api.InventoryDomain {
 	lower := strings.ToLower(line)
 	if !strings.Contains(lower, "hdr(host") &&
 		!strings.Contains(lower, "ssl_fc_sni") &&
@@ -139,10 +370,10 @@ func parseHaproxyDomains(line string) []string {
 		return nil
 	}
 
-	var domains []string
+	var domains []api.InventoryDomain
 	fields := strings.Fields(line)
 	for _, field := range fields {
-		if domain, ok := normalizeDomain(field); ok {
+		if domain, ok := parseDomain(field); ok {
 			domains = append(domains, domain)
 		}
 	}
@@ -3,9 +3,9 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/certkit-io/certkit-agent/api"
@@ -18,16 +18,19 @@ func (IISProvider) Name() string {
 	return "iis"
 }
 
-func (IISProvider) Collect() ([]api.InventoryItem, error) {
-	bindings, ok := loadIISBindingsFromPowerShell()
-	if !ok || len(bindings) == 0 {
+func (IISProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	bindings, err := loadIISBindingsFromPowerShell(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(bindings) == 0 {
 		return nil, nil
 	}
 
 	items := make([]api.InventoryItem, 0, len(bindings))
 	for _, binding := range bindings {
-		domains := make([]string, 0, 1)
-		if value, ok := normalizeDomain(binding.Host); ok {
+		domains := make([]api.InventoryDomain, 0, 1)
+		if value, ok := parseDomain(binding.Host); ok {
 			domains = append(domains, value)
 		}
 
@@ -38,7 +41,7 @@ func (IISProvider) Collect() ([]api.InventoryItem, error) {
 			ConfigPath:      "IIS:\\SslBindings",
 			CertificatePath: pemPath,
 			KeyPath:         pemPath,
-			Domains:         joinDomains(domains),
+			Domains:         uniqueDomains(domains),
 		})
 	}
 
@@ -56,7 +59,7 @@ type iisBindingResult struct {
 	Count int          `json:"Count"`
 }
 
-func loadIISBindingsFromPowerShell() ([]iisBinding, bool) {
+func loadIISBindingsFromPowerShell(ctx context.Context) ([]iisBinding, error) {
 	script := `
 
 if (-not (Get-Module -ListAvailable -Name WebAdministration)) {
@@ -83,23 +86,22 @@ Import-Module WebAdministration
     Select-Object -First 10
 ) | ConvertTo-Json
 `
-	out, err := utils.RunPowerShell(script)
+	out, err := utils.RunPowerShellContext(ctx, script)
 	if err != nil {
-		log.Printf("IIS SSL bindings lookup via PowerShell failed: %v", err)
-		return nil, false
+		return nil, fmt.Errorf("IIS SSL bindings lookup via PowerShell failed: %w", err)
 	}
 
 	raw := strings.TrimSpace(out)
 
 	if raw == "" || raw == "null" {
-		return nil, true
+		return nil, nil
 	}
 
 	var result iisBindingResult
 	if err := json.Unmarshal([]byte(raw), &result); err != nil {
-		log.Printf("IIS SSL bindings JSON parse failed: %v", err)
+		return nil, fmt.Errorf("IIS SSL bindings JSON parse failed: %w", err)
 	}
 	bindings := result.Value
 
-	return bindings, true
+	return bindings, nil
 }
@@ -1,34 +1,275 @@
 package inventory
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/metrics"
 	"github.com/certkit-io/certkit-agent/utils"
 )
 
+// Provider discovers certificates used by one kind of server. Collect returns
+// whatever it could find; problems with individual files are returned as
+// *FileError values joined with errors.Join alongside those items.
 type Provider interface {
 	Name() string
-	Collect() ([]api.InventoryItem, error)
+	Collect(ctx context.Context) ([]api.InventoryItem, error)
 }
 
-func Collect() ([]api.InventoryItem, error) {
-	providers := getProviders()
+// providerTimeout bounds each provider so a hung lookup cannot hold up the
+// rest of the inventory.
+var providerTimeout = 2 * time.Minute
 
-	items := make([]api.InventoryItem, 0)
-	for _, provider := range providers {
-		providerItems, err := provider.Collect()
-		if err != nil {
-			return nil, fmt.Errorf("%s inventory: %w", provider.Name(), err)
+// FileError is a problem reading or parsing one configuration or certificate
+// file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// knownItemsProvider is a provider that builds on what the file-based
+// providers found, such as the TLS probe, which uses known domains for SNI.
+// These run after the others, once certificate files have been described.
+type knownItemsProvider interface {
+	Provider
+	CollectKnown(ctx context.Context, known []api.InventoryItem) ([]api.InventoryItem, error)
+}
+
+type providerResult struct {
+	items []api.InventoryItem
+	err   error
+}
+
+// Collect runs every provider concurrently and returns all items found along
+// with the errors providers reported. A failing or slow provider does not
+// prevent the others' results from being returned; the error return is only
+// set when ctx is cancelled.
+func Collect(ctx context.Context) (api.InventoryUpdate, error) {
+	var fileProviders, knownProviders []Provider
+	for _, provider := range getProviders() {
+		if _, ok := provider.(knownItemsProvider); ok {
+			knownProviders = append(knownProviders, provider)
+		} else {
+			fileProviders = append(fileProviders, provider)
+		}
+	}
+
+	update := api.InventoryUpdate{Items: make([]api.InventoryItem, 0)}
+	counts := make(map[string]int)
+	if err := runProviders(ctx, fileProviders, nil, &update, counts); err != nil {
+		return api.InventoryUpdate{}, err
+	}
+	enrichItems(update.Items)
+
+	if len(knownProviders) > 0 {
+		known := append([]api.InventoryItem(nil), update.Items...)
+		if err := runProviders(ctx, knownProviders, known, &update, counts); err != nil {
+			return api.InventoryUpdate{}, err
+		}
+		correlateEndpoints(update.Items)
+	}
+	metrics.SetInventoryItems(counts)
+
+	return update, nil
+}
+
+func runProviders(ctx context.Context, providers []Provider, known []api.InventoryItem, update *api.InventoryUpdate, counts map[string]int) error {
+	results := make([]chan providerResult, len(providers))
+	deadlines := make([]context.Context, len(providers))
+	for i, provider := range providers {
+		providerCtx, cancel := context.WithTimeout(ctx, providerTimeout)
+		defer cancel()
+		deadlines[i] = providerCtx
+		results[i] = make(chan providerResult, 1)
+		go collectProvider(providerCtx, provider, known, results[i])
+	}
+
+	for i, provider := range providers {
+		var result providerResult
+		select {
+		case result = <-results[i]:
+		case <-deadlines[i].Done():
+			// Waiting on an earlier provider can use up this one's deadline
+			// too, so a result that is already there still counts.
+			select {
+			case result = <-results[i]:
+			default:
+				// The provider ignored its context; leave it to finish on its own.
+				result.err = deadlines[i].Err()
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if errors.Is(result.err, context.DeadlineExceeded) {
+			result.err = fmt.Errorf("timed out after %s", providerTimeout)
+		}
+		result.items = withoutExcluded(result.items)
+		update.Items = append(update.Items, result.items...)
+		update.Errors = append(update.Errors, inventoryErrors(provider.Name(), result.err)...)
+		counts[provider.Name()] = len(result.items)
+	}
+	return nil
+}
+
+func collectProvider(ctx context.Context, provider Provider, known []api.InventoryItem, out chan<- providerResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			out <- providerResult{err: fmt.Errorf("panic: %v", r)}
+		}
+	}()
+	var items []api.InventoryItem
+	var err error
+	if knownProvider, ok := provider.(knownItemsProvider); ok {
+		items, err = knownProvider.CollectKnown(ctx, known)
+	} else {
+		items, err = provider.Collect(ctx)
+	}
+	out <- providerResult{items: items, err: err}
+}
+
+// correlateEndpoints links each TLS endpoint to the inventoried files holding
+// the certificate it served, and records on those files where they are served.
+func correlateEndpoints(items []api.InventoryItem) {
+	byFingerprint := make(map[string][]int)
+	for i, item := range items {
+		if item.Endpoint != nil || item.Certificate == nil || item.Certificate.FingerprintSHA256 == "" {
+			continue
+		}
+		fingerprint := item.Certificate.FingerprintSHA256
+		byFingerprint[fingerprint] = append(byFingerprint[fingerprint], i)
+	}
+
+	for i := range items {
+		endpoint := items[i].Endpoint
+		if endpoint == nil || items[i].Certificate == nil {
+			continue
 		}
-		items = append(items, providerItems...)
+		for _, j := range byFingerprint[items[i].Certificate.FingerprintSHA256] {
+			file := &items[j]
+			endpoint.CertificatePaths = appendUnique(endpoint.CertificatePaths, file.CertificatePath)
+			file.ServedOn = appendUnique(file.ServedOn, endpoint.Address)
+		}
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// inventoryErrors flattens a provider's error into one entry per file.
+func inventoryErrors(provider string, err error) []api.InventoryError {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		out := make([]api.InventoryError, 0)
+		for _, inner := range joined.Unwrap() {
+			out = append(out, inventoryErrors(provider, inner)...)
+		}
+		return out
 	}
 
-	return items, nil
+	entry := api.InventoryError{Provider: provider, Error: err.Error()}
+	var fileErr *FileError
+	if errors.As(err, &fileErr) {
+		entry.Path = fileErr.Path
+		entry.Error = fileErr.Err.Error()
+	}
+	log.Printf("Inventory error (%s): %v", provider, err)
+	return []api.InventoryError{entry}
+}
+
+// getProviders returns the platform's providers minus any disabled in the
+// inventory config.
+func getProviders() []Provider {
+	disabled := make(map[string]struct{})
+	for _, name := range inventoryConfig().DisabledProviders {
+		disabled[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+	}
+
+	providers := make([]Provider, 0)
+	for _, provider := range platformProviders() {
+		if _, ok := disabled[provider.Name()]; ok {
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
 }
 
+func inventoryConfig() config.InventoryConfig {
+	if config.CurrentConfig.Inventory == nil {
+		return config.InventoryConfig{}
+	}
+	return *config.CurrentConfig.Inventory
+}
+
+// extraConfigGlobs returns the user-configured config globs for provider.
+func extraConfigGlobs(provider string) []string {
+	for name, globs := range inventoryConfig().ExtraConfigPaths {
+		if strings.EqualFold(name, provider) {
+			return globs
+		}
+	}
+	return nil
+}
+
+// isExcludedPath reports whether path, or a directory containing it, matches
+// one of the configured exclude globs.
+func isExcludedPath(path string) bool {
+	patterns := inventoryConfig().ExcludePaths
+	if len(patterns) == 0 || path == "" {
+		return false
+	}
+	for current := filepath.Clean(path); ; {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(filepath.Clean(pattern), current); matched {
+				return true
+			}
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return false
+		}
+		current = parent
+	}
+}
+
+func withoutExcluded(items []api.InventoryItem) []api.InventoryItem {
+	kept := items[:0]
+	for _, item := range items {
+		if isExcludedPath(item.CertificatePath) || isExcludedPath(item.ConfigPath) {
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// expandConfigGlobs returns the existing files matching globs, sorted and
+// with excluded paths removed.
 func expandConfigGlobs(globs []string) ([]string, error) {
 	seen := make(map[string]struct{})
 	for _, pattern := range globs {
@@ -53,8 +294,12 @@ func expandConfigGlobs(globs []string) ([]string, error) {
 
 	paths := make([]string, 0, len(seen))
 	for path := range seen {
+		if isExcludedPath(path) {
+			continue
+		}
 		paths = append(paths, path)
 	}
+	sort.Strings(paths)
 	return paths, nil
 }
 
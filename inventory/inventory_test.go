@@ -0,0 +1,160 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+func TestInventoryErrorsFlattensFileErrors(t *testing.T) {
+	err := errors.Join(
+		&FileError{Path: "/etc/haproxy/certs.list", Err: errors.New("permission denied")},
+		errors.Join(&FileError{Path: "/etc/nginx/nginx.conf", Err: errors.New("line 3: unexpected \"}\"")}),
+		errors.New("lookup failed"),
+	)
+
+	got := inventoryErrors("test", err)
+	want := []api.InventoryError{
+		{Provider: "test", Path: "/etc/haproxy/certs.list", Error: "permission denied"},
+		{Provider: "test", Path: "/etc/nginx/nginx.conf", Error: "line 3: unexpected \"}\""},
+		{Provider: "test", Error: "lookup failed"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d errors, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("error %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if inventoryErrors("test", nil) != nil {
+		t.Error("expected no errors for nil")
+	}
+}
+
+type panickingProvider struct{}
+
+func (panickingProvider) Name() string { return "panicking" }
+
+func (panickingProvider) Collect(context.Context) ([]api.InventoryItem, error) {
+	panic("boom")
+}
+
+func TestCollectProviderRecoversPanic(t *testing.T) {
+	out := make(chan providerResult, 1)
+	collectProvider(context.Background(), panickingProvider{}, nil, out)
+	result := <-out
+	if result.err == nil || result.err.Error() != "panic: boom" {
+		t.Fatalf("err = %v, want panic: boom", result.err)
+	}
+}
+
+func TestInventoryConfigExcludesAndDisables(t *testing.T) {
+	previous := config.CurrentConfig.Inventory
+	defer func() { config.CurrentConfig.Inventory = previous }()
+	config.CurrentConfig.Inventory = &config.InventoryConfig{
+		DisabledProviders: []string{"Docker"},
+		ExcludePaths:      []string{"/etc/nginx/sites-available", "/certs/*.old"},
+	}
+
+	excluded := []string{"/etc/nginx/sites-available/default", "/certs/site.old"}
+	for _, path := range excluded {
+		if !isExcludedPath(path) {
+			t.Errorf("%s should be excluded", path)
+		}
+	}
+	included := []string{"/etc/nginx/sites-enabled/default", "/certs/site.pem"}
+	for _, path := range included {
+		if isExcludedPath(path) {
+			t.Errorf("%s should not be excluded", path)
+		}
+	}
+
+	for _, provider := range getProviders() {
+		if provider.Name() == "docker" {
+			t.Error("docker provider should be disabled")
+		}
+	}
+}
+
+// stubProvider returns items after delay. When block is set it ignores its
+// context and waits for block to be closed, like a hung lookup.
+type stubProvider struct {
+	name  string
+	items []api.InventoryItem
+	delay time.Duration
+	block <-chan struct{}
+}
+
+func (p stubProvider) Name() string { return p.name }
+
+func (p stubProvider) Collect(context.Context) ([]api.InventoryItem, error) {
+	if p.block != nil {
+		<-p.block
+	}
+	time.Sleep(p.delay)
+	return p.items, nil
+}
+
+func TestRunProvidersCutsOffSlowProvider(t *testing.T) {
+	previous := providerTimeout
+	providerTimeout = 50 * time.Millisecond
+	defer func() { providerTimeout = previous }()
+	hung := make(chan struct{})
+	defer close(hung)
+
+	providers := []Provider{
+		stubProvider{name: "hung", block: hung},
+		stubProvider{name: "nginx", items: []api.InventoryItem{{Server: "nginx", CertificatePath: "/certs/a.pem"}}},
+	}
+	update := api.InventoryUpdate{}
+	counts := make(map[string]int)
+	start := time.Now()
+	if err := runProviders(context.Background(), providers, nil, &update, counts); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runProviders took %s", elapsed)
+	}
+	if len(update.Items) != 1 || update.Items[0].CertificatePath != "/certs/a.pem" {
+		t.Errorf("items = %+v, want the nginx item", update.Items)
+	}
+	if len(update.Errors) != 1 || update.Errors[0].Provider != "hung" || update.Errors[0].Error != "timed out after 50ms" {
+		t.Errorf("errors = %+v, want a timeout for hung", update.Errors)
+	}
+}
+
+func TestRunProvidersOrderIsStable(t *testing.T) {
+	providers := make([]Provider, 0, 5)
+	want := make([]string, 0, 5)
+	for i := range 5 {
+		path := fmt.Sprintf("/certs/%d.pem", i)
+		providers = append(providers, stubProvider{
+			name: fmt.Sprintf("p%d", i),
+			// Later providers finish first.
+			delay: time.Duration(5-i) * 5 * time.Millisecond,
+			items: []api.InventoryItem{{CertificatePath: path}},
+		})
+		want = append(want, path)
+	}
+
+	for run := range 3 {
+		update := api.InventoryUpdate{}
+		if err := runProviders(context.Background(), providers, nil, &update, make(map[string]int)); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(update.Items))
+		for _, item := range update.Items {
+			got = append(got, item.CertificatePath)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("run %d: items = %v, want %v", run, got, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	jksMagic   = 0xFEEDFEED
+	jceksMagic = 0xCECECECE
+
+	jksHeaderSize = 12
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+
+	// jksMinEntrySize is the tag, an empty alias and the timestamp: the
+	// smallest an entry can be.
+	jksMinEntrySize = 4 + 2 + 8
+)
+
+// isJavaKeyStore reports whether data starts with the JKS or JCEKS magic.
+// Newer Java releases default to PKCS#12 keystores, which are read as PFX.
+func isJavaKeyStore(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	magic := binary.BigEndian.Uint32(data)
+	return magic == jksMagic || magic == jceksMagic
+}
+
+// hasJavaKeyStoreHeader reports whether data starts with a complete JKS or
+// JCEKS header of a version parseJavaKeyStore reads.
+func hasJavaKeyStoreHeader(data []byte) bool {
+	if len(data) < jksHeaderSize || !isJavaKeyStore(data) {
+		return false
+	}
+	version := binary.BigEndian.Uint32(data[4:])
+	return version == 1 || version == 2
+}
+
+// jksEntry is one alias in a Java keystore. Certificates are stored in the
+// clear, so they can be read without the store password; private keys stay
+// encrypted and are not decoded.
+type jksEntry struct {
+	alias        string
+	privateKey   bool
+	certificates [][]byte
+}
+
+func parseJavaKeyStore(data []byte) ([]jksEntry, error) {
+	r := bytes.NewReader(data)
+	var header struct {
+		Magic   uint32
+		Version uint32
+		Count   uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("read keystore header: %w", err)
+	}
+	if header.Magic != jksMagic && header.Magic != jceksMagic {
+		return nil, errors.New("not a Java keystore")
+	}
+	if header.Version != 1 && header.Version != 2 {
+		return nil, fmt.Errorf("unsupported keystore version %d", header.Version)
+	}
+
+	// The count is untrusted; a file too short to hold that many entries
+	// is rejected before anything is allocated for them.
+	if uint64(header.Count) > uint64(r.Len())/jksMinEntrySize {
+		return nil, fmt.Errorf("keystore claims %d entries in %d bytes", header.Count, r.Len())
+	}
+
+	var entries []jksEntry
+	for i := uint32(0); i < header.Count; i++ {
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, fmt.Errorf("read entry %d: %w", i, err)
+		}
+		alias, err := readJavaUTF(r)
+		if err != nil {
+			return nil, fmt.Errorf("read entry %d alias: %w", i, err)
+		}
+		if _, err := r.Seek(8, io.SeekCurrent); err != nil { // creation timestamp
+			return nil, err
+		}
+
+		entry := jksEntry{alias: alias}
+		switch tag {
+		case jksPrivateKeyTag:
+			entry.privateKey = true
+			if _, err := readJavaBytes(r); err != nil {
+				return nil, fmt.Errorf("read entry %q key: %w", alias, err)
+			}
+			var chainLength uint32
+			if err := binary.Read(r, binary.BigEndian, &chainLength); err != nil {
+				return nil, fmt.Errorf("read entry %q chain: %w", alias, err)
+			}
+			for j := uint32(0); j < chainLength; j++ {
+				der, err := readJavaCertificate(r, header.Version)
+				if err != nil {
+					return nil, fmt.Errorf("read entry %q chain: %w", alias, err)
+				}
+				entry.certificates = append(entry.certificates, der)
+			}
+		case jksTrustedCertTag:
+			der, err := readJavaCertificate(r, header.Version)
+			if err != nil {
+				return nil, fmt.Errorf("read entry %q certificate: %w", alias, err)
+			}
+			entry.certificates = append(entry.certificates, der)
+		default:
+			// JCEKS secret keys are serialized Java objects; entries after
+			// one cannot be located, so stop with what was read.
+			return entries, nil
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readJavaCertificate(r *bytes.Reader, version uint32) ([]byte, error) {
+	if version == 2 {
+		certType, err := readJavaUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		if certType != "X.509" {
+			return nil, fmt.Errorf("unsupported certificate type %q", certType)
+		}
+	}
+	return readJavaBytes(r)
+}
+
+func readJavaUTF(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func readJavaBytes(r *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
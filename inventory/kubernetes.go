@@ -0,0 +1,226 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/kubeapi"
+)
+
+// KubernetesProvider reports the kubernetes.io/tls Secrets of the cluster the
+// agent can reach, either from inside a pod or through the kubeconfig named
+// in inventory.kubernetes. Hosts come from the Ingresses and Gateway
+// listeners that reference each Secret.
+type KubernetesProvider struct{}
+
+func (KubernetesProvider) Name() string {
+	return "kubernetes"
+}
+
+func kubernetesScanConfig() config.KubernetesScanConfig {
+	if cfg := inventoryConfig().Kubernetes; cfg != nil {
+		return *cfg
+	}
+	return config.KubernetesScanConfig{}
+}
+
+func (KubernetesProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	scanConfig := kubernetesScanConfig()
+	clusterConfig, err := kubernetesClusterConfig(scanConfig)
+	if errors.Is(err, kubeapi.ErrNoConfig) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubeapi.NewClient(clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := make(map[string]struct{}, len(scanConfig.ExcludeNamespaces))
+	for _, namespace := range scanConfig.ExcludeNamespaces {
+		excluded[namespace] = struct{}{}
+	}
+	// With no allow list the whole cluster is listed at once.
+	namespaces := []string{""}
+	if len(scanConfig.Namespaces) > 0 {
+		namespaces = scanConfig.Namespaces
+	}
+
+	scan := kubernetesScan{references: make(map[string]*kubernetesReferences)}
+	var errs []error
+	for _, namespace := range namespaces {
+		if _, ok := excluded[namespace]; ok {
+			continue
+		}
+		if err := scan.collectNamespace(ctx, client, namespace); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	items := make([]api.InventoryItem, 0, len(scan.secrets))
+	for _, secret := range scan.secrets {
+		if _, ok := excluded[secret.Metadata.Namespace]; ok {
+			continue
+		}
+		path := secret.Metadata.Namespace + "/" + secret.Metadata.Name
+		item := api.InventoryItem{
+			Server:          "kubernetes",
+			CertificatePath: path,
+			KeyPath:         path,
+			Certificate:     describeSecretCertificate(secret),
+		}
+		if refs := scan.references[path]; refs != nil {
+			sort.Strings(refs.objects)
+			item.ConfigPath = refs.objects[0]
+			item.Domains = uniqueDomains(refs.domains)
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CertificatePath < items[j].CertificatePath
+	})
+	return items, errors.Join(errs...)
+}
+
+// kubernetesClusterConfig only uses credentials meant for the agent: a
+// kubeconfig named in the inventory config, or the service account of the pod
+// it runs in. Unlike kubeapi.LoadConfig it never falls back to $KUBECONFIG or
+// ~/.kube/config, which on a host usually belong to an administrator.
+func kubernetesClusterConfig(scanConfig config.KubernetesScanConfig) (*kubeapi.Config, error) {
+	if scanConfig.Kubeconfig != "" {
+		return kubeapi.LoadKubeconfig(scanConfig.Kubeconfig, scanConfig.Context)
+	}
+	return kubeapi.InClusterConfig()
+}
+
+// kubernetesScan gathers TLS Secrets and, keyed by "namespace/name", the
+// objects that reference them.
+type kubernetesScan struct {
+	secrets    []kubeapi.Secret
+	references map[string]*kubernetesReferences
+}
+
+type kubernetesReferences struct {
+	objects []string
+	domains []api.InventoryDomain
+}
+
+func (s *kubernetesScan) reference(namespace string, name string, object string, hosts []string) {
+	key := namespace + "/" + name
+	refs := s.references[key]
+	if refs == nil {
+		refs = &kubernetesReferences{}
+		s.references[key] = refs
+	}
+	refs.objects = appendUnique(refs.objects, object)
+	for _, host := range hosts {
+		if domain, ok := parseDomain(host); ok {
+			refs.domains = append(refs.domains, domain)
+		}
+	}
+}
+
+// collectNamespace lists TLS Secrets, Ingresses and Gateways in namespace, or
+// cluster-wide when it is empty. Clusters without the Gateway API CRDs are
+// not an error.
+func (s *kubernetesScan) collectNamespace(ctx context.Context, client *kubeapi.Client, namespace string) error {
+	secrets, err := client.ListSecrets(ctx, namespace, kubeapi.SecretTypeTLS)
+	if err != nil {
+		return fmt.Errorf("list secrets%s: %w", namespaceSuffix(namespace), err)
+	}
+	s.secrets = append(s.secrets, secrets...)
+
+	var errs []error
+	ingresses, err := client.ListIngresses(ctx, namespace)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("list ingresses%s: %w", namespaceSuffix(namespace), err))
+	}
+	for _, ingress := range ingresses {
+		object := "ingress/" + ingress.Metadata.Namespace + "/" + ingress.Metadata.Name
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName == "" {
+				// The controller's default certificate is used.
+				continue
+			}
+			hosts := tls.Hosts
+			if len(hosts) == 0 {
+				for _, rule := range ingress.Spec.Rules {
+					hosts = append(hosts, rule.Host)
+				}
+			}
+			s.reference(ingress.Metadata.Namespace, tls.SecretName, object, hosts)
+		}
+	}
+
+	gateways, err := client.ListGateways(ctx, namespace)
+	if err != nil && !kubeapi.IsNotFound(err) {
+		errs = append(errs, fmt.Errorf("list gateways%s: %w", namespaceSuffix(namespace), err))
+	}
+	for _, gateway := range gateways {
+		object := "gateway/" + gateway.Metadata.Namespace + "/" + gateway.Metadata.Name
+		for _, listener := range gateway.Spec.Listeners {
+			if listener.TLS == nil {
+				continue
+			}
+			var hosts []string
+			if listener.Hostname != "" {
+				hosts = []string{listener.Hostname}
+			}
+			for _, ref := range listener.TLS.CertificateRefs {
+				if ref.Group != "" || (ref.Kind != "" && ref.Kind != "Secret") {
+					continue
+				}
+				refNamespace := ref.Namespace
+				if refNamespace == "" {
+					refNamespace = gateway.Metadata.Namespace
+				}
+				s.reference(refNamespace, ref.Name, object, hosts)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func namespaceSuffix(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	return " in " + namespace
+}
+
+// describeSecretCertificate describes the certificate held in a TLS Secret's
+// tls.crt and whether tls.key matches it.
+func describeSecretCertificate(secret kubeapi.Secret) *api.InventoryCertificate {
+	data := secret.Data["tls.crt"]
+	if len(data) == 0 {
+		return &api.InventoryCertificate{Status: api.CertificateStatusMissing, Error: "secret has no tls.crt"}
+	}
+	loaded, err := loadPEMCertificate(data)
+	if err != nil {
+		return &api.InventoryCertificate{Status: api.CertificateStatusInvalid, Format: "pem", Error: err.Error()}
+	}
+
+	description := describeChain(loaded.certificates, loaded.format)
+	leaf := orderChain(loaded.certificates)[0]
+	keyData, ok := secret.Data["tls.key"]
+	if !ok || len(keyData) == 0 {
+		description.KeyMatch = api.KeyMatchMissing
+		return description
+	}
+	key, err := parsePrivateKeyPEM(keyData)
+	if err != nil {
+		description.KeyMatch = keyErrorState(err)
+		return description
+	}
+	description.KeyMatch = keyMatchState(leaf, key)
+	return description
+}
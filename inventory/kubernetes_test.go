@@ -0,0 +1,210 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+	"github.com/certkit-io/certkit-agent/kubeapi"
+)
+
+// startKubernetesAPI serves canned list responses by path and points the
+// provider at it through a kubeconfig. It returns the requested paths.
+func startKubernetesAPI(t *testing.T, scan config.KubernetesScanConfig, responses map[string][]string) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	served := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery)
+		pages, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"kind":"Status","reason":"NotFound","message":"the server could not find the requested resource"}`))
+			return
+		}
+		page := served[r.URL.Path]
+		served[r.URL.Path]++
+		if page > 0 && r.URL.Query().Get("continue") != fmt.Sprintf("page%d", page) {
+			t.Errorf("%s page %d requested with continue=%q", r.URL.Path, page, r.URL.Query().Get("continue"))
+		}
+		_, _ = w.Write([]byte(pages[page]))
+	}))
+	t.Cleanup(server.Close)
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	writeTestFile(t, kubeconfig, `apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: `+server.URL+`
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: agent
+users:
+- name: agent
+  user:
+    token: sekret
+`)
+	scan.Kubeconfig = kubeconfig
+	previous := config.CurrentConfig.Inventory
+	t.Cleanup(func() { config.CurrentConfig.Inventory = previous })
+	config.CurrentConfig.Inventory = &config.InventoryConfig{Kubernetes: &scan}
+
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func kubernetesList(t *testing.T, continueToken string, items ...any) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{
+		"metadata": map[string]string{"continue": continueToken},
+		"items":    items,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func tlsSecret(namespace string, name string, certPem string, keyPem string) kubeapi.Secret {
+	return kubeapi.Secret{
+		Metadata: kubeapi.ObjectMeta{Namespace: namespace, Name: name},
+		Type:     kubeapi.SecretTypeTLS,
+		Data:     map[string][]byte{"tls.crt": []byte(certPem), "tls.key": []byte(keyPem)},
+	}
+}
+
+func TestKubernetesProviderReportsTLSSecrets(t *testing.T) {
+	ca := newTestCertificate(t, "Test Root", nil)
+	site := newTestCertificate(t, "www.example.com", &ca)
+	apiCert := newTestCertificate(t, "api.example.com", &ca)
+	other := newTestCertificate(t, "other.example.com", &ca)
+
+	ingress := kubeapi.Ingress{
+		Metadata: kubeapi.ObjectMeta{Namespace: "web", Name: "site"},
+		Spec: kubeapi.IngressSpec{
+			TLS: []kubeapi.IngressTLS{
+				{Hosts: []string{"www.example.com", "example.com"}, SecretName: "site-tls"},
+				{SecretName: "api-tls"},
+				{Hosts: []string{"default.example.com"}},
+			},
+			Rules: []kubeapi.IngressRule{{Host: "api.example.com"}},
+		},
+	}
+	gateway := kubeapi.Gateway{
+		Metadata: kubeapi.ObjectMeta{Namespace: "gateways", Name: "public"},
+		Spec: kubeapi.GatewaySpec{Listeners: []kubeapi.GatewayListener{{
+			Name:     "https",
+			Hostname: "*.example.com",
+			TLS: &kubeapi.GatewayTLSConfig{CertificateRefs: []kubeapi.SecretObjectReference{
+				{Name: "site-tls", Namespace: "web"},
+				{Name: "vault-cert", Kind: "VaultCertificate", Group: "example.io"},
+			}},
+		}}},
+	}
+
+	requests := startKubernetesAPI(t, config.KubernetesScanConfig{ExcludeNamespaces: []string{"kube-system"}}, map[string][]string{
+		"/api/v1/secrets": {
+			kubernetesList(t, "page1", tlsSecret("web", "site-tls", site.certPEM()+ca.certPEM(), site.keyPEM(t))),
+			kubernetesList(t, "",
+				tlsSecret("web", "api-tls", apiCert.certPEM(), other.keyPEM(t)),
+				tlsSecret("kube-system", "internal", other.certPEM(), other.keyPEM(t)),
+				kubeapi.Secret{Metadata: kubeapi.ObjectMeta{Namespace: "web", Name: "empty"}, Type: kubeapi.SecretTypeTLS},
+			),
+		},
+		"/apis/networking.k8s.io/v1/ingresses":        {kubernetesList(t, "", ingress)},
+		"/apis/gateway.networking.k8s.io/v1/gateways": {kubernetesList(t, "", gateway)},
+	})
+
+	items, err := KubernetesProvider{}.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3: %+v (requests %q)", len(items), items, requests())
+	}
+
+	apiItem, emptyItem, siteItem := items[0], items[1], items[2]
+	if siteItem.CertificatePath != "web/site-tls" || siteItem.KeyPath != "web/site-tls" || siteItem.Server != "kubernetes" {
+		t.Errorf("site item = %+v", siteItem)
+	}
+	if siteItem.ConfigPath != "gateway/gateways/public" {
+		t.Errorf("site config path = %q", siteItem.ConfigPath)
+	}
+	if got := domainNames(siteItem.Domains); got != "*.example.com,www.example.com,example.com" && got != "www.example.com,example.com,*.example.com" {
+		t.Errorf("site domains = %q", got)
+	}
+	if c := siteItem.Certificate; c == nil || c.Status != api.CertificateStatusOK || c.ChainLength != 2 || c.KeyMatch != api.KeyMatchMatch {
+		t.Errorf("site certificate = %+v", c)
+	}
+
+	if apiItem.CertificatePath != "web/api-tls" || apiItem.ConfigPath != "ingress/web/site" || domainNames(apiItem.Domains) != "api.example.com" {
+		t.Errorf("api item = %+v", apiItem)
+	}
+	if c := apiItem.Certificate; c == nil || c.KeyMatch != api.KeyMatchMismatch {
+		t.Errorf("api certificate = %+v", c)
+	}
+
+	if emptyItem.CertificatePath != "web/empty" || emptyItem.ConfigPath != "" || emptyItem.Certificate == nil || emptyItem.Certificate.Status != api.CertificateStatusMissing {
+		t.Errorf("empty item = %+v", emptyItem)
+	}
+
+	if got := requests(); len(got) != 4 || got[0] != "/api/v1/secrets?fieldSelector=type%3Dkubernetes.io%2Ftls&limit=500" {
+		t.Errorf("requests = %q", got)
+	}
+}
+
+func TestKubernetesProviderListsAllowedNamespaces(t *testing.T) {
+	ca := newTestCertificate(t, "Test Root", nil)
+	site := newTestCertificate(t, "www.example.com", &ca)
+
+	requests := startKubernetesAPI(t, config.KubernetesScanConfig{Namespaces: []string{"web", "staging"}, ExcludeNamespaces: []string{"staging"}}, map[string][]string{
+		"/api/v1/namespaces/web/secrets":                      {kubernetesList(t, "", tlsSecret("web", "site-tls", site.certPEM(), site.keyPEM(t)))},
+		"/apis/networking.k8s.io/v1/namespaces/web/ingresses": {kubernetesList(t, "")},
+	})
+
+	items, err := KubernetesProvider{}.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(items) != 1 || items[0].CertificatePath != "web/site-tls" {
+		t.Fatalf("items = %+v", items)
+	}
+	// The Gateway API is not installed; its 404 is not reported.
+	if got := requests(); len(got) != 3 {
+		t.Errorf("requests = %q", got)
+	}
+}
+
+func TestKubernetesProviderIgnoresAmbientKubeconfig(t *testing.T) {
+	requests := startKubernetesAPI(t, config.KubernetesScanConfig{}, nil)
+	// Only a kubeconfig named in the inventory config is used.
+	t.Setenv("KUBECONFIG", config.CurrentConfig.Inventory.Kubernetes.Kubeconfig)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	config.CurrentConfig.Inventory.Kubernetes.Kubeconfig = ""
+
+	items, err := KubernetesProvider{}.Collect(context.Background())
+	if err != nil || len(items) != 0 {
+		t.Fatalf("Collect = %+v, %v", items, err)
+	}
+	if got := requests(); len(got) != 0 {
+		t.Errorf("requests = %q", got)
+	}
+}
@@ -1,7 +1,8 @@
 package inventory
 
 import (
-	"log"
+	"context"
+	"errors"
 	"regexp"
 	"strings"
 
@@ -15,22 +16,26 @@ func (LitespeedProvider) Name() string {
 	return "litespeed"
 }
 
-func (LitespeedProvider) Collect() ([]api.InventoryItem, error) {
-	configFiles, err := expandConfigGlobs([]string{
+func (LitespeedProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	configFiles, err := expandConfigGlobs(append([]string{
 		"/usr/local/lsws/conf/httpd_config.conf",
 		"/usr/local/lsws/conf/vhosts/*/vhconf.conf",
 		"/etc/lsws/conf/httpd_config.conf",
 		"/etc/lsws/conf/vhosts/*/vhconf.conf",
-	})
+	}, extraConfigGlobs("litespeed")...))
 	if err != nil {
 		return nil, err
 	}
 
 	items := make([]api.InventoryItem, 0)
+	var errs []error
 	for _, path := range configFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		certs, keys, domains, err := parseLitespeedConfig(path)
 		if err != nil {
-			log.Printf("Inventory parse error for %s: %v", path, err)
+			errs = append(errs, &FileError{Path: path, Err: err})
 			continue
 		}
 
@@ -44,15 +49,15 @@ func (LitespeedProvider) Collect() ([]api.InventoryItem, error) {
 				ConfigPath:      path,
 				CertificatePath: certs[i],
 				KeyPath:         keys[i],
-				Domains:         joinDomains(domains),
+				Domains:         uniqueDomains(domains),
 			})
 		}
 	}
 
-	return items, nil
+	return items, errors.Join(errs...)
 }
 
-func parseLitespeedConfig(path string) ([]string, []string, []string, error) {
+func parseLitespeedConfig(path string) ([]string, []string, []api.InventoryDomain, error) {
 	data, err := utils.ReadFileBytes(path)
 	if err != nil {
 		return nil, nil, nil, err
@@ -64,7 +69,7 @@ func parseLitespeedConfig(path string) ([]string, []string, []string, error) {
 
 	var certs []string
 	var keys []string
-	var domains []string
+	var domains []api.InventoryDomain
 
 	lines := strings.Split(string(data), "\n")
 	for _, line := range lines {
@@ -85,7 +90,7 @@ func parseLitespeedConfig(path string) ([]string, []string, []string, error) {
 				return r == ',' || r == ' ' || r == '\t'
 			})
 			for _, field := range fields {
-				if domain, ok := normalizeDomain(field); ok {
+				if domain, ok := parseDomain(field); ok {
 					domains = append(domains, domain)
 				}
 			}
@@ -1,8 +1,9 @@
 package inventory
 
 import (
-	"log"
-	"regexp"
+	"context"
+	"errors"
+	"path/filepath"
 	"strings"
 
 	"github.com/certkit-io/certkit-agent/api"
@@ -15,89 +16,224 @@ func (NginxProvider) Name() string {
 	return "nginx"
 }
 
-func (NginxProvider) Collect() ([]api.InventoryItem, error) {
-	configFiles, err := expandConfigGlobs([]string{
+// nginxMainConfigs are top-level nginx.conf files; they are parsed with their
+// includes, starting in the main context.
+func nginxMainConfigs() []string {
+	return []string{
 		"/etc/nginx/nginx.conf",
+		"/usr/local/etc/nginx/nginx.conf",
+		"/usr/local/nginx/conf/nginx.conf",
+	}
+}
+
+// nginxFragmentConfigs are files usually pulled in by include. Any that the
+// main configs did not already include (e.g. sites-available entries that are
+// not enabled) are parsed on their own as http context fragments.
+func nginxFragmentConfigs() []string {
+	return []string{
 		"/etc/nginx/conf.d/*.conf",
 		"/etc/nginx/sites-enabled/*",
 		"/etc/nginx/sites-available/*",
-		"/usr/local/etc/nginx/nginx.conf",
 		"/usr/local/etc/nginx/conf.d/*.conf",
-	})
+	}
+}
+
+func (NginxProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	mainFiles, err := expandConfigGlobs(nginxMainConfigs())
+	if err != nil {
+		return nil, err
+	}
+	extraFiles, err := expandConfigGlobs(extraConfigGlobs("nginx"))
+	if err != nil {
+		return nil, err
+	}
+	fragmentFiles, err := expandConfigGlobs(nginxFragmentConfigs())
 	if err != nil {
 		return nil, err
 	}
 
+	visited := make(map[string]struct{})
 	items := make([]api.InventoryItem, 0)
-	for _, path := range configFiles {
-		certs, keys, domains, err := parseNginxConfig(path)
-		if err != nil {
-			log.Printf("Inventory parse error for %s: %v", path, err)
-			continue
+	var errs []error
+	for _, path := range mainFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-
-		pairs := len(certs)
-		if len(keys) < pairs {
-			pairs = len(keys)
+		fileItems, fileErrs := collectNginxFile(path, filepath.Dir(path), visited)
+		items = append(items, fileItems...)
+		errs = append(errs, fileErrs...)
+	}
+	// Configured extra paths may be either main configs (an OpenResty
+	// nginx.conf) or fragments; collectNginxFile tells them apart.
+	for _, path := range append(extraFiles, fragmentFiles...) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		for i := 0; i < pairs; i++ {
-			items = append(items, api.InventoryItem{
-				Server:          "nginx",
-				ConfigPath:      path,
-				CertificatePath: certs[i],
-				KeyPath:         keys[i],
-				Domains:         joinDomains(domains),
-			})
+		if _, ok := visited[canonicalPath(path)]; ok {
+			continue
 		}
+		fileItems, fileErrs := collectNginxFile(path, nginxPrefixFor(path), visited)
+		items = append(items, fileItems...)
+		errs = append(errs, fileErrs...)
 	}
 
-	return items, nil
+	return items, errors.Join(errs...)
 }
 
-func parseNginxConfig(path string) ([]string, []string, []string, error) {
-	data, err := utils.ReadFileBytes(path)
+// collectNginxFile parses path with its includes. Files without a top-level
+// http, stream or mail block are treated as http context fragments.
+func collectNginxFile(path string, prefix string, visited map[string]struct{}) ([]api.InventoryItem, []error) {
+	loader := newNginxLoader(prefix, visited)
+	directives, err := loader.load(path)
+	errs := make([]error, 0, len(loader.errors)+1)
+	for _, includeErr := range loader.errors {
+		errs = append(errs, &FileError{Path: path, Err: includeErr})
+	}
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, append(errs, &FileError{Path: path, Err: err})
 	}
 
-	reCert := regexp.MustCompile(`(?i)^\s*ssl_certificate\s+([^;]+);`)
-	reKey := regexp.MustCompile(`(?i)^\s*ssl_certificate_key\s+([^;]+);`)
-	reServer := regexp.MustCompile(`(?i)^\s*server_name\s+([^;]+);`)
+	if isNginxFragment(directives) {
+		return nginxServerItems(directives, nginxSSLDirectives(directives, loader), loader), errs
+	}
+	return parseNginxConfig(directives, loader), errs
+}
 
-	var certs []string
-	var keys []string
-	var domains []string
+func isNginxFragment(directives []nginxDirective) bool {
+	for _, directive := range directives {
+		switch strings.ToLower(directive.Name) {
+		case "http", "stream", "mail", "events":
+			return false
+		}
+	}
+	return true
+}
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		trimmed := stripNginxComment(line)
-		if trimmed == "" {
+// parseNginxConfig emits one inventory item per certificate configured in each
+// server block of the http, stream and mail contexts.
+func parseNginxConfig(directives []nginxDirective, loader *nginxLoader) []api.InventoryItem {
+	items := make([]api.InventoryItem, 0)
+	for _, directive := range directives {
+		if directive.Block == nil {
 			continue
 		}
-		if match := reCert.FindStringSubmatch(trimmed); len(match) == 2 {
-			certs = append(certs, cleanConfigValue(match[1]))
+		switch strings.ToLower(directive.Name) {
+		case "http", "stream", "mail":
+			inherited := nginxSSLDirectives(directive.Block, loader)
+			items = append(items, nginxServerItems(directive.Block, inherited, loader)...)
+		}
+	}
+	return items
+}
+
+// nginxSSL holds the ssl_certificate and ssl_certificate_key values defined at
+// one level. nginx pairs the Nth certificate with the Nth key, which is how
+// dual RSA+ECDSA certificates are configured.
+type nginxSSL struct {
+	certs []string
+	keys  []string
+}
+
+func nginxSSLDirectives(directives []nginxDirective, loader *nginxLoader) nginxSSL {
+	var ssl nginxSSL
+	for _, directive := range directives {
+		if directive.Block != nil || len(directive.Args) == 0 {
 			continue
 		}
-		if match := reKey.FindStringSubmatch(trimmed); len(match) == 2 {
-			keys = append(keys, cleanConfigValue(match[1]))
+		switch strings.ToLower(directive.Name) {
+		case "ssl_certificate":
+			ssl.certs = append(ssl.certs, loader.resolvePath(cleanConfigValue(directive.Args[0])))
+		case "ssl_certificate_key":
+			ssl.keys = append(ssl.keys, loader.resolvePath(cleanConfigValue(directive.Args[0])))
+		}
+	}
+	return ssl
+}
+
+func nginxServerItems(directives []nginxDirective, inherited nginxSSL, loader *nginxLoader) []api.InventoryItem {
+	items := make([]api.InventoryItem, 0)
+	for _, directive := range directives {
+		if directive.Block == nil || !strings.EqualFold(directive.Name, "server") {
 			continue
 		}
-		if match := reServer.FindStringSubmatch(trimmed); len(match) == 2 {
-			fields := strings.Fields(match[1])
-			for _, field := range fields {
-				if domain, ok := normalizeDomain(field); ok {
-					domains = append(domains, domain)
+
+		ssl := nginxSSLDirectives(directive.Block, loader)
+		if len(ssl.certs) == 0 {
+			// Certificates set at the http/stream/mail level apply to every
+			// server below it, but only matter for servers that speak TLS.
+			if len(inherited.certs) == 0 || !nginxServerUsesSSL(directive.Block) {
+				continue
+			}
+			ssl = inherited
+		}
+		if len(ssl.keys) == 0 {
+			ssl.keys = inherited.keys
+		}
+
+		domains := nginxServerNames(directive.Block)
+		for i, certPath := range ssl.certs {
+			keyPath := ""
+			if i < len(ssl.keys) {
+				keyPath = ssl.keys[i]
+			}
+			items = append(items, api.InventoryItem{
+				Server:          "nginx",
+				ConfigPath:      directive.File,
+				CertificatePath: certPath,
+				KeyPath:         keyPath,
+				Domains:         uniqueDomains(domains),
+			})
+		}
+	}
+	return items
+}
+
+func nginxServerUsesSSL(directives []nginxDirective) bool {
+	for _, directive := range directives {
+		switch strings.ToLower(directive.Name) {
+		case "listen":
+			for _, arg := range directive.Args[min(1, len(directive.Args)):] {
+				if strings.EqualFold(arg, "ssl") || strings.EqualFold(arg, "quic") {
+					return true
 				}
 			}
+		case "ssl":
+			if len(directive.Args) > 0 && strings.EqualFold(directive.Args[0], "on") {
+				return true
+			}
 		}
 	}
+	return false
+}
 
-	return certs, keys, domains, nil
+func nginxServerNames(directives []nginxDirective) []api.InventoryDomain {
+	var domains []api.InventoryDomain
+	for _, directive := range directives {
+		if !strings.EqualFold(directive.Name, "server_name") {
+			continue
+		}
+		for _, field := range directive.Args {
+			if domain, ok := parseDomain(field); ok {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return domains
 }
 
-func stripNginxComment(line string) string {
-	if idx := strings.Index(line, "#"); idx >= 0 {
-		line = line[:idx]
+// nginxPrefixFor finds the nginx prefix for a standalone configuration file:
+// the nearest ancestor directory that holds nginx.conf.
+func nginxPrefixFor(path string) string {
+	dir := filepath.Dir(path)
+	for current := dir; ; {
+		if exists, _ := utils.FileExists(filepath.Join(current, "nginx.conf")); exists {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
 	}
-	return strings.TrimSpace(line)
+	return dir
 }
@@ -0,0 +1,268 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const maxNginxIncludeDepth = 16
+
+type nginxToken struct {
+	value  string
+	quoted bool
+	line   int
+}
+
+// nginxDirective is one parsed directive. Block is non-nil for block
+// directives such as http or server. File records where the directive was
+// read from, which matters once includes are spliced in.
+type nginxDirective struct {
+	Name  string
+	Args  []string
+	Block []nginxDirective
+	File  string
+	Line  int
+}
+
+// nginxLoader parses nginx configuration files and follows include
+// directives. Paths in include directives are resolved relative to prefix,
+// the directory holding the main nginx.conf, as nginx itself does.
+type nginxLoader struct {
+	prefix  string
+	visited map[string]struct{}
+	errors  []error
+}
+
+func newNginxLoader(prefix string, visited map[string]struct{}) *nginxLoader {
+	if visited == nil {
+		visited = make(map[string]struct{})
+	}
+	return &nginxLoader{prefix: prefix, visited: visited}
+}
+
+// load parses path and everything it includes. Files that were already loaded
+// through another include are skipped so include cycles terminate.
+func (l *nginxLoader) load(path string) ([]nginxDirective, error) {
+	return l.loadFile(path, 0)
+}
+
+func (l *nginxLoader) loadFile(path string, depth int) ([]nginxDirective, error) {
+	if depth > maxNginxIncludeDepth {
+		return nil, fmt.Errorf("%s: include depth exceeds %d", path, maxNginxIncludeDepth)
+	}
+	key := canonicalPath(path)
+	if _, ok := l.visited[key]; ok {
+		return nil, nil
+	}
+	l.visited[key] = struct{}{}
+
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := tokenizeNginx(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	directives, rest, err := l.parseBlock(tokens, path, depth, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%s:%d: unexpected \"}\"", path, rest[0].line)
+	}
+	return directives, nil
+}
+
+func (l *nginxLoader) parseBlock(tokens []nginxToken, path string, depth int, nested bool) ([]nginxDirective, []nginxToken, error) {
+	directives := make([]nginxDirective, 0)
+	for len(tokens) > 0 {
+		tok := tokens[0]
+		if !tok.quoted && tok.value == "}" {
+			if !nested {
+				return directives, tokens, nil
+			}
+			return directives, tokens[1:], nil
+		}
+		if !tok.quoted && (tok.value == ";" || tok.value == "{") {
+			return nil, nil, fmt.Errorf("line %d: unexpected %q", tok.line, tok.value)
+		}
+
+		directive := nginxDirective{Name: tok.value, File: path, Line: tok.line}
+		tokens = tokens[1:]
+		terminated := false
+		for len(tokens) > 0 {
+			arg := tokens[0]
+			tokens = tokens[1:]
+			if !arg.quoted && arg.value == ";" {
+				terminated = true
+				break
+			}
+			if !arg.quoted && arg.value == "{" {
+				block, rest, err := l.parseBlock(tokens, path, depth, true)
+				if err != nil {
+					return nil, nil, err
+				}
+				directive.Block = block
+				tokens = rest
+				terminated = true
+				break
+			}
+			if !arg.quoted && arg.value == "}" {
+				return nil, nil, fmt.Errorf("line %d: unexpected \"}\"", arg.line)
+			}
+			directive.Args = append(directive.Args, arg.value)
+		}
+		if !terminated {
+			return nil, nil, fmt.Errorf("line %d: unexpected end of file in %q", directive.Line, directive.Name)
+		}
+
+		if strings.EqualFold(directive.Name, "include") && directive.Block == nil {
+			directives = append(directives, l.resolveInclude(directive, depth)...)
+			continue
+		}
+		directives = append(directives, directive)
+	}
+	if nested {
+		return nil, nil, fmt.Errorf("unexpected end of file, expecting \"}\"")
+	}
+	return directives, nil, nil
+}
+
+func (l *nginxLoader) resolveInclude(directive nginxDirective, depth int) []nginxDirective {
+	spliced := make([]nginxDirective, 0)
+	for _, pattern := range directive.Args {
+		pattern = l.resolvePath(pattern)
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				l.errors = append(l.errors, fmt.Errorf("%s:%d: include %q: %w", directive.File, directive.Line, pattern, err))
+				continue
+			}
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				continue
+			}
+			if isExcludedPath(match) {
+				continue
+			}
+			included, err := l.loadFile(match, depth+1)
+			if err != nil {
+				l.errors = append(l.errors, err)
+				continue
+			}
+			spliced = append(spliced, included...)
+		}
+	}
+	return spliced
+}
+
+// resolvePath makes a configuration path absolute relative to the nginx
+// prefix. Paths built from variables are left untouched.
+func (l *nginxLoader) resolvePath(value string) string {
+	if value == "" || filepath.IsAbs(value) || strings.Contains(value, "$") || l.prefix == "" {
+		return value
+	}
+	return filepath.Join(l.prefix, value)
+}
+
+// tokenizeNginx splits configuration text into words, quoted strings and the
+// ";", "{" and "}" punctuation, dropping comments.
+func tokenizeNginx(input string) ([]nginxToken, error) {
+	tokens := make([]nginxToken, 0)
+	line := 1
+	var word strings.Builder
+	wordLine := 0
+
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		tokens = append(tokens, nginxToken{value: word.String(), line: wordLine})
+		word.Reset()
+	}
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == '\n':
+			flush()
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+		case c == '#' && word.Len() == 0:
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+			i--
+		case (c == '"' || c == '\'') && word.Len() == 0:
+			quote := c
+			startLine := line
+			var value strings.Builder
+			closed := false
+			for i++; i < len(input); i++ {
+				ch := input[i]
+				if ch == '\\' && i+1 < len(input) {
+					next := input[i+1]
+					if next == quote || next == '\\' {
+						value.WriteByte(next)
+						i++
+						continue
+					}
+				}
+				if ch == '\n' {
+					line++
+				}
+				if ch == quote {
+					closed = true
+					break
+				}
+				value.WriteByte(ch)
+			}
+			if !closed {
+				return nil, fmt.Errorf("line %d: unterminated quoted string", startLine)
+			}
+			tokens = append(tokens, nginxToken{value: value.String(), quoted: true, line: startLine})
+		case c == '{' && strings.HasSuffix(word.String(), "$"):
+			// ${var} inside a word is a variable reference, not a block.
+			for ; i < len(input) && input[i] != '}'; i++ {
+				word.WriteByte(input[i])
+			}
+			if i < len(input) {
+				word.WriteByte('}')
+			}
+		case c == ';' || c == '{' || c == '}':
+			flush()
+			tokens = append(tokens, nginxToken{value: string(c), line: line})
+		case c == '\\' && i+1 < len(input):
+			if word.Len() == 0 {
+				wordLine = line
+			}
+			word.WriteByte(input[i+1])
+			i++
+		default:
+			if word.Len() == 0 {
+				wordLine = line
+			}
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, nil
+}
+
+// canonicalPath resolves symlinks so a file reached both directly and through
+// a symlink (sites-enabled -> sites-available) is only parsed once.
+func canonicalPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,114 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseNginxConfigScopesServerBlocks(t *testing.T) {
+	prefix := t.TempDir()
+	writeTestFile(t, filepath.Join(prefix, "nginx.conf"), `
+# main config
+events {}
+http {
+    include conf.d/*.conf;
+    server {
+        listen 80;
+        server_name plain.example.com; # no TLS here
+    }
+}
+stream {
+    server {
+        listen 5432 ssl;
+        ssl_certificate     /certs/db.crt;
+        ssl_certificate_key /certs/db.key;
+    }
+}
+`)
+	writeTestFile(t, filepath.Join(prefix, "conf.d", "a.conf"), `
+server {
+    listen 443 ssl;
+    server_name a.example.com www.a.example.com;
+    ssl_certificate "/certs/a rsa.crt";
+    ssl_certificate_key /certs/a-rsa.key;
+    ssl_certificate /certs/a-ecdsa.crt;
+    ssl_certificate_key /certs/a-ecdsa.key;
+}
+server {
+    listen 443 ssl;
+    server_name b.example.com;
+    ssl_certificate certs/b.crt;
+    ssl_certificate_key certs/b.key;
+    location / { return 200 "}{;"; }
+}
+`)
+
+	loader := newNginxLoader(prefix, nil)
+	directives, err := loader.load(filepath.Join(prefix, "nginx.conf"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(loader.errors) > 0 {
+		t.Fatalf("include errors: %v", loader.errors)
+	}
+
+	items := parseNginxConfig(directives, loader)
+	type want struct{ cert, key, domains string }
+	wants := []want{
+		{"/certs/a rsa.crt", "/certs/a-rsa.key", "a.example.com,www.a.example.com"},
+		{"/certs/a-ecdsa.crt", "/certs/a-ecdsa.key", "a.example.com,www.a.example.com"},
+		{filepath.Join(prefix, "certs/b.crt"), filepath.Join(prefix, "certs/b.key"), "b.example.com"},
+		{"/certs/db.crt", "/certs/db.key", ""},
+	}
+	if len(items) != len(wants) {
+		t.Fatalf("got %d items, want %d: %+v", len(items), len(wants), items)
+	}
+	for i, w := range wants {
+		got := items[i]
+		if got.CertificatePath != w.cert || got.KeyPath != w.key || domainNames(got.Domains) != w.domains {
+			t.Errorf("item %d = {%s %s %s}, want {%s %s %s}", i, got.CertificatePath, got.KeyPath, domainNames(got.Domains), w.cert, w.key, w.domains)
+		}
+	}
+	if items[0].ConfigPath != filepath.Join(prefix, "conf.d", "a.conf") {
+		t.Errorf("item 0 config path = %s, want the included file", items[0].ConfigPath)
+	}
+}
+
+func TestParseNginxConfigInheritsHttpCertificate(t *testing.T) {
+	prefix := t.TempDir()
+	writeTestFile(t, filepath.Join(prefix, "nginx.conf"), `
+http {
+    ssl_certificate /certs/default.crt;
+    ssl_certificate_key /certs/default.key;
+    server { listen 80; server_name insecure.example.com; }
+    server { listen 443 ssl; server_name secure.example.com; }
+}
+`)
+
+	loader := newNginxLoader(prefix, nil)
+	directives, err := loader.load(filepath.Join(prefix, "nginx.conf"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	items := parseNginxConfig(directives, loader)
+	if len(items) != 1 || domainNames(items[0].Domains) != "secure.example.com" || items[0].CertificatePath != "/certs/default.crt" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+}
+
+func TestTokenizeNginxRejectsUnterminatedQuote(t *testing.T) {
+	if _, err := tokenizeNginx(`ssl_certificate "/certs/a.crt;`); err == nil {
+		t.Fatal("expected error for unterminated quote")
+	}
+}
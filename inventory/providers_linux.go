@@ -2,12 +2,31 @@
 
 package inventory
 
-func getProviders() []Provider {
+func platformProviders() []Provider {
 	return []Provider{
 		NginxProvider{},
 		ApacheProvider{},
 		LitespeedProvider{},
 		HaproxyProvider{},
+		CaddyProvider{},
+		TraefikProvider{},
+		KubernetesProvider{},
 		DockerProvider{},
+		DockerEngineProvider{},
+		TLSProbeProvider{},
+		FilesystemProvider{},
+	}
+}
+
+// filesystemRoots are the directories the filesystem provider scans by
+// default.
+func filesystemRoots() []string {
+	return []string{
+		"/etc/ssl",
+		"/etc/pki",
+		"/etc/letsencrypt/live",
+		"/usr/local/etc/ssl",
+		"/opt/*/certs",
+		"/opt/*/ssl",
 	}
 }
@@ -2,10 +2,18 @@
 
 package inventory
 
-func getProviders() []Provider {
+func platformProviders() []Provider {
 	return []Provider{
 		IISProvider{},
 		RRASProvider{},
 		ApacheProvider{},
+		FilesystemProvider{},
 	}
 }
+
+// filesystemRoots are the directories the filesystem provider scans by
+// default. Windows servers keep certificates in the certificate store, so
+// only configured roots are scanned.
+func filesystemRoots() []string {
+	return nil
+}
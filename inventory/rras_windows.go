@@ -3,8 +3,9 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"strings"
 
 	"github.com/certkit-io/certkit-agent/api"
@@ -17,19 +18,19 @@ func (RRASProvider) Name() string {
 	return "rras"
 }
 
-func (RRASProvider) Collect() ([]api.InventoryItem, error) {
-	result, ok := loadRRASInventoryFromPowerShell()
-	if !ok {
-		return nil, nil
+func (RRASProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	result, err := loadRRASInventoryFromPowerShell(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	if !result.ServiceRunning || !result.Listening443 {
 		return nil, nil
 	}
 
-	domains := make([]string, 0, len(result.Domains))
+	domains := make([]api.InventoryDomain, 0, len(result.Domains))
 	for _, domain := range result.Domains {
-		if normalized, ok := normalizeDomain(domain); ok {
+		if normalized, ok := parseDomain(domain); ok {
 			domains = append(domains, normalized)
 		}
 	}
@@ -39,7 +40,7 @@ func (RRASProvider) Collect() ([]api.InventoryItem, error) {
 		ConfigPath:      "RRAS:SSTP",
 		CertificatePath: "Routing and Remote Access:443",
 		KeyPath:         "Routing and Remote Access:443",
-		Domains:         joinDomains(domains),
+		Domains:         uniqueDomains(domains),
 	}
 
 	return []api.InventoryItem{item}, nil
@@ -52,7 +53,7 @@ type rrasInventoryResult struct {
 	Domains        []string `json:"Domains"`
 }
 
-func loadRRASInventoryFromPowerShell() (rrasInventoryResult, bool) {
+func loadRRASInventoryFromPowerShell(ctx context.Context) (rrasInventoryResult, error) {
 	script := `
 $service = Get-Service -Name RemoteAccess -ErrorAction SilentlyContinue
 if (-not $service -or $service.Status -ne 'Running') {
@@ -105,22 +106,20 @@ if ($thumbprint) {
 } | ConvertTo-Json -Depth 5
 `
 
-	out, err := utils.RunPowerShell(script)
+	out, err := utils.RunPowerShellContext(ctx, script)
 	if err != nil {
-		log.Printf("RRAS inventory lookup via PowerShell failed: %v", err)
-		return rrasInventoryResult{}, false
+		return rrasInventoryResult{}, fmt.Errorf("RRAS inventory lookup via PowerShell failed: %w", err)
 	}
 
 	raw := strings.TrimSpace(out)
 	if raw == "" || raw == "null" {
-		return rrasInventoryResult{}, true
+		return rrasInventoryResult{}, nil
 	}
 
 	var result rrasInventoryResult
 	if err := json.Unmarshal([]byte(raw), &result); err != nil {
-		log.Printf("RRAS inventory JSON parse failed: %v", err)
-		return rrasInventoryResult{}, false
+		return rrasInventoryResult{}, fmt.Errorf("RRAS inventory JSON parse failed: %w", err)
 	}
 
-	return result, true
+	return result, nil
 }
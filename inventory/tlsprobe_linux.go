@@ -0,0 +1,423 @@
+//go:build linux
+
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/api"
+)
+
+const (
+	tlsProbeTimeout     = 3 * time.Second
+	tlsProbeConcurrency = 8
+	tlsProbeMaxSockets  = 64
+	tlsProbeMaxDomains  = 16
+
+	tcpStateListen = "0A"
+)
+
+// tlsProbeSkipPorts are the default ports of protocols that do not start
+// with a TLS handshake (plaintext, or upgraded with STARTTLS or a protocol
+// specific request); sending them a ClientHello only produces log noise.
+// inventory.tls_probe.skip_ports adds to them.
+var tlsProbeSkipPorts = []uint16{
+	21,    // FTP
+	22,    // SSH
+	23,    // Telnet
+	25,    // SMTP
+	53,    // DNS
+	110,   // POP3
+	111,   // RPC portmapper
+	143,   // IMAP
+	389,   // LDAP
+	587,   // SMTP submission
+	1433,  // SQL Server
+	3306,  // MySQL
+	5432,  // PostgreSQL
+	6379,  // Redis
+	11211, // memcached
+	27017, // MongoDB
+}
+
+// skippedProbePorts returns the ports the probe leaves alone.
+func skippedProbePorts() map[uint16]struct{} {
+	skip := make(map[uint16]struct{}, len(tlsProbeSkipPorts))
+	for _, port := range tlsProbeSkipPorts {
+		skip[port] = struct{}{}
+	}
+	if probeConfig := inventoryConfig().TLSProbe; probeConfig != nil {
+		for _, port := range probeConfig.SkipPorts {
+			if port > 0 && port <= 65535 {
+				skip[uint16(port)] = struct{}{}
+			}
+		}
+	}
+	return skip
+}
+
+// TLSProbeProvider finds certificates by handshaking with every listening
+// TCP socket, which catches servers whose configuration the file-based
+// providers do not know about.
+type TLSProbeProvider struct{}
+
+func (TLSProbeProvider) Name() string {
+	return "tls-probe"
+}
+
+func (p TLSProbeProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	return p.CollectKnown(ctx, nil)
+}
+
+// CollectKnown probes each listening socket without SNI, then again with
+// each domain the other providers found, and reports every distinct
+// certificate served.
+func (TLSProbeProvider) CollectKnown(ctx context.Context, known []api.InventoryItem) ([]api.InventoryItem, error) {
+	sockets, err := listeningSockets()
+	if err != nil {
+		return nil, err
+	}
+	var truncated error
+	if len(sockets) > tlsProbeMaxSockets {
+		log.Printf("TLS probe: %d listening sockets found; probing the first %d", len(sockets), tlsProbeMaxSockets)
+		truncated = &FileError{Path: "/proc/net/tcp", Err: fmt.Errorf("probed %d of %d listening sockets", tlsProbeMaxSockets, len(sockets))}
+		sockets = sockets[:tlsProbeMaxSockets]
+	}
+	owners := socketOwners(sockets)
+	domains := probeDomains(known)
+
+	results := make([][]api.InventoryItem, len(sockets))
+	sem := make(chan struct{}, tlsProbeConcurrency)
+	var wg sync.WaitGroup
+	for i, socket := range sockets {
+		wg.Add(1)
+		go func(i int, socket listeningSocket) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = probeSocket(ctx, socket, owners[socket.inode], domains)
+		}(i, socket)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return mergeProbeResults(sockets, results), truncated
+}
+
+// mergeProbeResults flattens the per-socket results. A service listening on
+// both 0.0.0.0 and [::] has two sockets serving the same certificate on the
+// same port; it is reported once.
+func mergeProbeResults(sockets []listeningSocket, results [][]api.InventoryItem) []api.InventoryItem {
+	items := make([]api.InventoryItem, 0)
+	seen := make(map[string]struct{})
+	for i, socketItems := range results {
+		for _, item := range socketItems {
+			if item.Certificate != nil && item.Certificate.FingerprintSHA256 != "" {
+				key := strconv.Itoa(int(sockets[i].address.Port())) + "/" + item.Certificate.FingerprintSHA256
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+			}
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type listeningSocket struct {
+	address netip.AddrPort
+	inode   uint64
+}
+
+// probeAddress is where to connect to reach the socket: wildcard listeners
+// are reached over loopback.
+func (s listeningSocket) probeAddress() string {
+	addr := s.address.Addr()
+	if addr.IsUnspecified() {
+		if addr.Is4() {
+			addr = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+		} else {
+			addr = netip.IPv6Loopback()
+		}
+	}
+	return netip.AddrPortFrom(addr, s.address.Port()).String()
+}
+
+type socketOwner struct {
+	pid  int
+	name string
+	exe  string
+}
+
+func probeSocket(ctx context.Context, socket listeningSocket, owner socketOwner, domains []string) []api.InventoryItem {
+	target := socket.probeAddress()
+	chain, err := probeTLS(ctx, target, "")
+	if err != nil {
+		// Not a TLS listener, or one that requires SNI we do not know.
+		if len(domains) == 0 {
+			return nil
+		}
+	}
+
+	items := make([]api.InventoryItem, 0, 1)
+	seen := make(map[[32]byte]struct{})
+	add := func(chain []*x509.Certificate, serverName string) {
+		leaf := orderChain(chain)[0]
+		fingerprint := sha256.Sum256(leaf.Raw)
+		if _, ok := seen[fingerprint]; ok {
+			return
+		}
+		seen[fingerprint] = struct{}{}
+
+		var names []api.InventoryDomain
+		for _, name := range leaf.DNSNames {
+			if domain, ok := parseDomain(name); ok {
+				names = append(names, domain)
+			}
+		}
+		for _, ip := range leaf.IPAddresses {
+			if domain, ok := parseDomain(ip.String()); ok {
+				names = append(names, domain)
+			}
+		}
+		items = append(items, api.InventoryItem{
+			Server:          "tls-probe",
+			ConfigPath:      owner.exe,
+			CertificatePath: "tls://" + target,
+			Domains:         uniqueDomains(names),
+			Certificate:     describeChain(chain, "tls"),
+			Endpoint: &api.InventoryEndpoint{
+				Address:     socket.address.String(),
+				ServerName:  serverName,
+				PID:         owner.pid,
+				ProcessName: owner.name,
+				ProcessExe:  owner.exe,
+			},
+		})
+	}
+
+	if err == nil {
+		add(chain, "")
+	}
+	answered := err == nil
+	for _, domain := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		chain, err := probeTLS(ctx, target, domain)
+		if err != nil {
+			if !answered {
+				// Neither the default nor the first named handshake
+				// worked; this is not a TLS listener.
+				break
+			}
+			continue
+		}
+		answered = true
+		add(chain, domain)
+	}
+	return items
+}
+
+func probeTLS(ctx context.Context, address string, serverName string) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsProbeTimeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: tlsProbeTimeout},
+		Config: &tls.Config{
+			ServerName: serverName,
+			// The probe records what is served; it does not trust it.
+			InsecureSkipVerify: true,
+		},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	return chain, nil
+}
+
+// probeDomains returns the distinct DNS names other providers reported, for
+// use as SNI names. Wildcards and IP addresses cannot be sent as SNI.
+func probeDomains(known []api.InventoryItem) []string {
+	seen := make(map[string]struct{})
+	domains := make([]string, 0)
+	for _, item := range known {
+		for _, domain := range item.Domains {
+			if domain.Type != api.DomainTypeDNS {
+				continue
+			}
+			if _, ok := seen[domain.Name]; ok {
+				continue
+			}
+			seen[domain.Name] = struct{}{}
+			domains = append(domains, domain.Name)
+			if len(domains) == tlsProbeMaxDomains {
+				return domains
+			}
+		}
+	}
+	return domains
+}
+
+// listeningSockets reads listening TCP sockets from /proc/net/tcp and
+// /proc/net/tcp6.
+func listeningSockets() ([]listeningSocket, error) {
+	sockets := make([]listeningSocket, 0)
+	seen := make(map[string]struct{})
+	skip := skippedProbePorts()
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		parsed, err := parseProcNetTCP(data)
+		if err != nil {
+			return nil, &FileError{Path: path, Err: err}
+		}
+		for _, socket := range parsed {
+			if _, ok := skip[socket.address.Port()]; ok {
+				continue
+			}
+			key := socket.probeAddress()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			sockets = append(sockets, socket)
+		}
+	}
+	return sockets, nil
+}
+
+func parseProcNetTCP(data []byte) ([]listeningSocket, error) {
+	sockets := make([]listeningSocket, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	first := true
+	for scanner.Scan() {
+		if first {
+			first = false // header
+			continue
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpStateListen {
+			continue
+		}
+		address, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inode %q", fields[9])
+		}
+		sockets = append(sockets, listeningSocket{address: address, inode: inode})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcNetAddress decodes "0100007F:01BB". The address is stored as
+// host-order 32-bit words, which are little-endian on every platform the
+// agent supports.
+func parseProcNetAddress(value string) (netip.AddrPort, error) {
+	hostHex, portHex, ok := strings.Cut(value, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid socket address %q", value)
+	}
+	raw, err := hex.DecodeString(hostHex)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("invalid socket address %q", value)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid socket port %q", value)
+	}
+
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(raw[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	addr, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
+
+// socketOwners maps socket inodes to the processes holding them by scanning
+// /proc/<pid>/fd. Processes the agent cannot inspect are skipped.
+func socketOwners(sockets []listeningSocket) map[uint64]socketOwner {
+	wanted := make(map[string]uint64, len(sockets))
+	for _, socket := range sockets {
+		wanted[fmt.Sprintf("socket:[%d]", socket.inode)] = socket.inode
+	}
+
+	owners := make(map[uint64]socketOwner)
+	procDirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, dir := range procDirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", dir.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			inode, ok := wanted[link]
+			if !ok {
+				continue
+			}
+			if _, found := owners[inode]; found {
+				continue
+			}
+			owner := socketOwner{pid: pid}
+			if comm, err := os.ReadFile(filepath.Join("/proc", dir.Name(), "comm")); err == nil {
+				owner.name = strings.TrimSpace(string(comm))
+			}
+			if exe, err := os.Readlink(filepath.Join("/proc", dir.Name(), "exe")); err == nil {
+				owner.exe = exe
+			}
+			owners[inode] = owner
+		}
+		if len(owners) == len(wanted) {
+			break
+		}
+	}
+	return owners
+}
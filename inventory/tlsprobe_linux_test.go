@@ -0,0 +1,125 @@
+//go:build linux
+
+package inventory
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/config"
+)
+
+func TestParseProcNetTCP(t *testing.T) {
+	data := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:01BB 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1234 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1235 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:A1B2 0100007F:01BB 01 00000000:00000000 00:00000000 00000000     0        0 1236 1 0000000000000000 100 0 0 10 0
+   3: 00000000000000000000000001000000:20FB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1237 1 0000000000000000 100 0 0 10 0
+`
+	sockets, err := parseProcNetTCP([]byte(data))
+	if err != nil {
+		t.Fatalf("parseProcNetTCP: %v", err)
+	}
+	want := []listeningSocket{
+		{address: netip.MustParseAddrPort("127.0.0.1:443"), inode: 1234},
+		{address: netip.MustParseAddrPort("0.0.0.0:22"), inode: 1235},
+		{address: netip.MustParseAddrPort("[::1]:8443"), inode: 1237},
+	}
+	if len(sockets) != len(want) {
+		t.Fatalf("got %d sockets, want %d: %+v", len(sockets), len(want), sockets)
+	}
+	for i := range want {
+		if sockets[i] != want[i] {
+			t.Errorf("socket %d = %+v, want %+v", i, sockets[i], want[i])
+		}
+	}
+	if got := sockets[1].probeAddress(); got != "127.0.0.1:22" {
+		t.Errorf("probeAddress = %s", got)
+	}
+}
+
+func TestProbeSocketReportsServedCertificate(t *testing.T) {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+
+	address := netip.MustParseAddrPort(strings.TrimPrefix(server.URL, "https://"))
+	socket := listeningSocket{address: address}
+	owner := socketOwner{pid: 42, name: "test", exe: "/usr/bin/test"}
+
+	items := probeSocket(context.Background(), socket, owner, []string{"example.com"})
+	if len(items) != 1 {
+		t.Fatalf("got %d items, want 1: %+v", len(items), items)
+	}
+	item := items[0]
+	if item.Endpoint == nil || item.Endpoint.Address != address.String() || item.Endpoint.ProcessName != "test" {
+		t.Errorf("endpoint = %+v", item.Endpoint)
+	}
+	if item.Certificate == nil || item.Certificate.Status != api.CertificateStatusOK || item.Certificate.FingerprintSHA256 == "" {
+		t.Errorf("certificate = %+v", item.Certificate)
+	}
+
+	file := api.InventoryItem{
+		CertificatePath: "/etc/ssl/site.pem",
+		Certificate:     &api.InventoryCertificate{FingerprintSHA256: item.Certificate.FingerprintSHA256},
+	}
+	all := []api.InventoryItem{file, item}
+	correlateEndpoints(all)
+	if len(all[0].ServedOn) != 1 || all[0].ServedOn[0] != address.String() {
+		t.Errorf("served on = %v", all[0].ServedOn)
+	}
+	if len(all[1].Endpoint.CertificatePaths) != 1 || all[1].Endpoint.CertificatePaths[0] != "/etc/ssl/site.pem" {
+		t.Errorf("certificate paths = %v", all[1].Endpoint.CertificatePaths)
+	}
+}
+
+func TestMergeProbeResultsReportsDualStackOnce(t *testing.T) {
+	sockets := []listeningSocket{
+		{address: netip.MustParseAddrPort("0.0.0.0:443"), inode: 1},
+		{address: netip.MustParseAddrPort("[::]:443"), inode: 2},
+		{address: netip.MustParseAddrPort("[::]:8443"), inode: 3},
+	}
+	served := func(address string, fingerprint string) api.InventoryItem {
+		return api.InventoryItem{
+			CertificatePath: "tls://" + address,
+			Certificate:     &api.InventoryCertificate{FingerprintSHA256: fingerprint},
+		}
+	}
+	items := mergeProbeResults(sockets, [][]api.InventoryItem{
+		{served("127.0.0.1:443", "aa")},
+		{served("[::1]:443", "aa"), served("[::1]:443", "bb")},
+		{served("[::1]:8443", "aa")},
+	})
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.CertificatePath+" "+item.Certificate.FingerprintSHA256)
+	}
+	want := []string{"tls://127.0.0.1:443 aa", "tls://[::1]:443 bb", "tls://[::1]:8443 aa"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("items = %q, want %q", got, want)
+	}
+}
+
+func TestSkippedProbePorts(t *testing.T) {
+	previous := config.CurrentConfig.Inventory
+	defer func() { config.CurrentConfig.Inventory = previous }()
+	config.CurrentConfig.Inventory = &config.InventoryConfig{
+		TLSProbe: &config.TLSProbeScanConfig{SkipPorts: []int{9000, 70000}},
+	}
+
+	skip := skippedProbePorts()
+	for _, port := range []uint16{22, 25, 3306, 5432, 6379, 9000} {
+		if _, ok := skip[port]; !ok {
+			t.Errorf("port %d should be skipped", port)
+		}
+	}
+	for _, port := range []uint16{443, 8443} {
+		if _, ok := skip[port]; ok {
+			t.Errorf("port %d should be probed", port)
+		}
+	}
+}
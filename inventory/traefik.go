@@ -0,0 +1,304 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/certkit-io/certkit-agent/api"
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+type TraefikProvider struct{}
+
+func (TraefikProvider) Name() string {
+	return "traefik"
+}
+
+// traefikStaticConfigs are the locations Traefik reads its static config
+// from.
+func traefikStaticConfigs() []string {
+	return []string{
+		"/etc/traefik/traefik.yml",
+		"/etc/traefik/traefik.yaml",
+		"/etc/traefik/traefik.toml",
+	}
+}
+
+// traefikDynamicDirs are scanned for dynamic config files in addition to the
+// file provider locations named by the static config. The agent writes its
+// own Traefik configurations to /etc/traefik/dynamic.
+func traefikDynamicDirs() []string {
+	return []string{"/etc/traefik/dynamic"}
+}
+
+var traefikHostRule = regexp.MustCompile("Host(?:SNI)?(?:Regexp)?\\(([^)]*)\\)")
+
+func (TraefikProvider) Collect(ctx context.Context) ([]api.InventoryItem, error) {
+	staticFiles, err := expandConfigGlobs(append(traefikStaticConfigs(), extraConfigGlobs("traefik")...))
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	dynamicGlobs := make([]string, 0)
+	for _, dir := range traefikDynamicDirs() {
+		dynamicGlobs = append(dynamicGlobs, traefikDirGlobs(dir)...)
+	}
+	for _, path := range staticFiles {
+		data, err := utils.ReadFileBytes(path)
+		if err != nil {
+			errs = append(errs, &FileError{Path: path, Err: err})
+			continue
+		}
+		for _, entry := range parseTraefikConfig(data, isTOML(path)) {
+			switch entry.key {
+			case "providers.file.filename":
+				dynamicGlobs = append(dynamicGlobs, entry.value)
+			case "providers.file.directory":
+				dynamicGlobs = append(dynamicGlobs, traefikDirGlobs(entry.value)...)
+			}
+		}
+	}
+
+	// Extra paths may name static or dynamic files, so they are read as both.
+	dynamicFiles, err := expandConfigGlobs(append(dynamicGlobs, extraConfigGlobs("traefik")...))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]api.InventoryItem, 0)
+	for _, path := range dynamicFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fileItems, err := parseTraefikDynamicConfig(path)
+		if err != nil {
+			errs = append(errs, &FileError{Path: path, Err: err})
+			continue
+		}
+		items = append(items, fileItems...)
+	}
+	return items, errors.Join(errs...)
+}
+
+func traefikDirGlobs(dir string) []string {
+	return []string{
+		filepath.Join(dir, "*.yml"),
+		filepath.Join(dir, "*.yaml"),
+		filepath.Join(dir, "*.toml"),
+	}
+}
+
+func isTOML(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".toml")
+}
+
+// parseTraefikDynamicConfig reports the certFile/keyFile pairs under tls
+// (tls.certificates and store default certificates), with the hosts the
+// file's router rules match as domains. Inline PEM content is skipped.
+func parseTraefikDynamicConfig(path string) ([]api.InventoryItem, error) {
+	data, err := utils.ReadFileBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []api.InventoryDomain
+	for _, match := range traefikHostRule.FindAllStringSubmatch(string(data), -1) {
+		for _, host := range strings.Split(match[1], ",") {
+			host = strings.Trim(strings.TrimSpace(host), "`\"'")
+			if domain, ok := parseDomain(host); ok {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	domains = uniqueDomains(domains)
+
+	items := make([]api.InventoryItem, 0)
+	var current *api.InventoryItem
+	flush := func() {
+		if current != nil && current.CertificatePath != "" {
+			items = append(items, *current)
+		}
+		current = nil
+	}
+	for _, entry := range parseTraefikConfig(data, isTOML(path)) {
+		if entry.newItem {
+			flush()
+		}
+		if !strings.HasPrefix(entry.key, "tls.") || strings.Contains(entry.value, "-----BEGIN") {
+			continue
+		}
+		field := entry.key[strings.LastIndex(entry.key, ".")+1:]
+		if field != "certfile" && field != "keyfile" {
+			continue
+		}
+		if current == nil || (field == "certfile" && current.CertificatePath != "") {
+			flush()
+			current = &api.InventoryItem{Server: "traefik", ConfigPath: path, Domains: domains}
+		}
+		if field == "certfile" {
+			current.CertificatePath = entry.value
+		} else {
+			current.KeyPath = entry.value
+		}
+	}
+	flush()
+	return items, nil
+}
+
+// traefikEntry is one scalar from a Traefik config file: its dotted,
+// lowercased key path and value. newItem marks the first value of a new list
+// element ("- " in YAML, [[table]] in TOML).
+type traefikEntry struct {
+	key     string
+	value   string
+	newItem bool
+}
+
+// parseTraefikConfig flattens the simple YAML or TOML Traefik configs are
+// written in. It follows indentation, list items and table headers; flow
+// collections and multi-line strings are not interpreted.
+func parseTraefikConfig(data []byte, toml bool) []traefikEntry {
+	if toml {
+		return parseTraefikTOML(string(data))
+	}
+	return parseTraefikYAML(string(data))
+}
+
+func parseTraefikYAML(data string) []traefikEntry {
+	type level struct {
+		indent int
+		key    string
+	}
+	var stack []level
+	var entries []traefikEntry
+	blockIndent := -1
+	for _, line := range strings.Split(data, "\n") {
+		content := stripYAMLComment(line)
+		if strings.TrimSpace(content) == "" || strings.HasPrefix(strings.TrimSpace(content), "---") {
+			continue
+		}
+		indent := len(content) - len(strings.TrimLeft(content, " "))
+		content = strings.TrimSpace(content)
+		// Skip the body of a | or > block scalar.
+		if blockIndent >= 0 {
+			if indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+
+		newItem := false
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			newItem = true
+			content = strings.TrimSpace(strings.TrimPrefix(content, "-"))
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+			indent += 2
+		} else {
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+		}
+
+		parent := make([]string, len(stack))
+		for i, l := range stack {
+			parent[i] = l.key
+		}
+		key, value, isMapping := cutYAMLMapping(content)
+		if !isMapping {
+			if content != "" {
+				entries = append(entries, traefikEntry{key: strings.Join(parent, "."), value: unquoteConfig(content), newItem: newItem})
+			}
+			continue
+		}
+		key = strings.ToLower(unquoteConfig(key))
+		if value == "" {
+			if newItem {
+				entries = append(entries, traefikEntry{key: strings.Join(parent, "."), newItem: true})
+			}
+			stack = append(stack, level{indent: indent, key: key})
+			continue
+		}
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+			if newItem {
+				entries = append(entries, traefikEntry{key: strings.Join(parent, "."), newItem: true})
+			}
+			continue
+		}
+		entries = append(entries, traefikEntry{key: strings.Join(append(parent, key), "."), value: unquoteConfig(value), newItem: newItem})
+	}
+	return entries
+}
+
+// cutYAMLMapping splits "key: value". A colon inside a value, such as in a
+// URL, is not a separator.
+func cutYAMLMapping(content string) (string, string, bool) {
+	idx := strings.Index(content, ": ")
+	if idx < 0 {
+		if strings.HasSuffix(content, ":") {
+			return strings.TrimSuffix(content, ":"), "", true
+		}
+		return "", "", false
+	}
+	return strings.TrimSpace(content[:idx]), strings.TrimSpace(content[idx+2:]), true
+}
+
+func stripYAMLComment(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	if idx := strings.Index(line, " #"); idx >= 0 {
+		line = line[:idx]
+	}
+	return strings.TrimRight(line, " \t\r")
+}
+
+func parseTraefikTOML(data string) []traefikEntry {
+	var entries []traefikEntry
+	section := ""
+	newItem := false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[[") && strings.HasSuffix(line, "]]") {
+			section = strings.ToLower(strings.TrimSpace(line[2 : len(line)-2]))
+			newItem = true
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(unquoteConfig(strings.TrimSpace(key)))
+		value = strings.TrimSpace(value)
+		if idx := strings.Index(value, " #"); idx >= 0 && !strings.HasPrefix(value, "\"") {
+			value = strings.TrimSpace(value[:idx])
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		entries = append(entries, traefikEntry{key: key, value: unquoteConfig(value), newItem: newItem})
+		newItem = false
+	}
+	return entries
+}
+
+func unquoteConfig(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return value
+}
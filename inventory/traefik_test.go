@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTraefikDynamicYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dynamic.yml")
+	writeTestFile(t, path, `
+http:
+  routers:
+    web:
+      rule: "Host(`+"`a.example.com`, `b.example.com`"+`) && PathPrefix(`+"`/`"+`)"
+      tls: {}
+tls:
+  certificates:
+    - certFile: /etc/traefik/certs/a.pem
+      keyFile: /etc/traefik/certs/a.key
+      stores:
+        - default
+    - certFile: "/etc/traefik/certs/b.pem" # bundle
+      keyFile: "/etc/traefik/certs/b.pem"
+    - certFile: |
+        -----BEGIN CERTIFICATE-----
+  stores:
+    default:
+      defaultCertificate:
+        certFile: /etc/traefik/certs/default.pem
+        keyFile: /etc/traefik/certs/default.key
+`)
+
+	items, err := parseTraefikDynamicConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"/etc/traefik/certs/a.pem", "/etc/traefik/certs/a.key"},
+		{"/etc/traefik/certs/b.pem", "/etc/traefik/certs/b.pem"},
+		{"/etc/traefik/certs/default.pem", "/etc/traefik/certs/default.key"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("items = %+v", items)
+	}
+	for i, item := range items {
+		if item.CertificatePath != want[i][0] || item.KeyPath != want[i][1] {
+			t.Errorf("item %d = %s, %s; want %v", i, item.CertificatePath, item.KeyPath, want[i])
+		}
+	}
+	if got := domainNames(items[0].Domains); got != "a.example.com,b.example.com" {
+		t.Errorf("domains = %s", got)
+	}
+}
+
+func TestParseTraefikTOML(t *testing.T) {
+	entries := parseTraefikConfig([]byte(`
+[providers.file]
+  directory = "/etc/traefik/conf.d"
+
+[[tls.certificates]]
+  certFile = "/certs/a.pem"
+  keyFile = "/certs/a.key"
+
+[[tls.certificates]]
+  certFile = "/certs/b.pem"
+  keyFile = "/certs/b.key"
+`), true)
+
+	if len(entries) != 5 || entries[0].key != "providers.file.directory" || entries[0].value != "/etc/traefik/conf.d" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	if !entries[1].newItem || entries[1].key != "tls.certificates.certfile" || entries[2].newItem || !entries[3].newItem {
+		t.Errorf("entries = %+v", entries)
+	}
+}
@@ -0,0 +1,202 @@
+// Package kubeapi is a minimal Kubernetes API client covering the objects
+// the agent reads and writes, authenticated with a service account token or
+// a kubeconfig.
+package kubeapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 30 * time.Second
+
+type Client struct {
+	server    string
+	namespace string
+	token     string
+	tokenFile string
+	http      *http.Client
+}
+
+func NewClient(cfg *Config) (*Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.Insecure}
+	if len(cfg.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.CAData) {
+			return nil, errors.New("no certificates in cluster CA data")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(cfg.ClientCertData) > 0 {
+		pair, err := tls.X509KeyPair(cfg.ClientCertData, cfg.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return &Client{
+		server:    strings.TrimSuffix(cfg.Server, "/"),
+		namespace: namespace,
+		token:     cfg.Token,
+		tokenFile: cfg.TokenFile,
+		http: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+			Timeout:   requestTimeout,
+		},
+	}, nil
+}
+
+// Namespace is the namespace of the client's context or service account.
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
+// Error is a failed API request, decoded from the server's Status object
+// when it sent one.
+type Error struct {
+	StatusCode int
+	Reason     string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kubernetes api: status=%d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the API server.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// ObjectMeta is the subset of object metadata the agent uses.
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, contentType string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, reader)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token, err := c.bearerToken()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		apiErr := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var status struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &status) == nil && status.Message != "" {
+			apiErr.Reason = status.Reason
+			apiErr.Message = status.Message
+		}
+		return apiErr
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// bearerToken re-reads the token file on every request, since projected
+// service account tokens are rotated while the agent runs.
+func (c *Client) bearerToken() (string, error) {
+	if c.tokenFile == "" {
+		return c.token, nil
+	}
+	data, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// listPageSize is the number of objects requested per page when listing.
+const listPageSize = 500
+
+// ListMeta is the metadata of a list response.
+type ListMeta struct {
+	Continue string `json:"continue,omitempty"`
+}
+
+type objectList[T any] struct {
+	Metadata ListMeta `json:"metadata"`
+	Items    []T      `json:"items"`
+}
+
+// collectionPath is the path of a resource collection under prefix ("/api/v1"
+// or "/apis/<group>/<version>"), cluster-wide when namespace is empty.
+func collectionPath(prefix string, namespace string, resource string) string {
+	if namespace == "" {
+		return prefix + "/" + resource
+	}
+	return prefix + "/namespaces/" + url.PathEscape(namespace) + "/" + resource
+}
+
+// listAll pages through a collection, following continue tokens.
+func listAll[T any](ctx context.Context, c *Client, path string, query url.Values) ([]T, error) {
+	items := make([]T, 0)
+	query.Set("limit", strconv.Itoa(listPageSize))
+	for {
+		var page objectList[T]
+		if err := c.do(ctx, http.MethodGet, path+"?"+query.Encode(), "", nil, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.Metadata.Continue == "" {
+			return items, nil
+		}
+		query.Set("continue", page.Metadata.Continue)
+	}
+}
@@ -0,0 +1,200 @@
+package kubeapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultNamespace  = "default"
+)
+
+// Config is how to reach and authenticate to an API server.
+type Config struct {
+	Server         string
+	Namespace      string
+	Token          string
+	TokenFile      string
+	CAData         []byte
+	ClientCertData []byte
+	ClientKeyData  []byte
+	Insecure       bool
+}
+
+// ErrNoConfig means neither in-cluster credentials nor a kubeconfig were
+// found.
+var ErrNoConfig = errors.New("no in-cluster service account or kubeconfig found")
+
+// LoadConfig returns the configuration to use: the given kubeconfig if set,
+// otherwise the in-cluster service account, otherwise $KUBECONFIG or
+// ~/.kube/config. contextName selects a kubeconfig context other than the
+// current one.
+func LoadConfig(kubeconfig string, contextName string) (*Config, error) {
+	if kubeconfig != "" {
+		return LoadKubeconfig(kubeconfig, contextName)
+	}
+	if cfg, err := InClusterConfig(); err == nil {
+		return cfg, nil
+	}
+	candidates := filepath.SplitList(os.Getenv("KUBECONFIG"))
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".kube", "config"))
+	}
+	for _, path := range candidates {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return LoadKubeconfig(path, contextName)
+		}
+	}
+	return nil, ErrNoConfig
+}
+
+// InClusterConfig uses the pod's service account.
+func InClusterConfig() (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNoConfig
+	}
+	tokenFile := filepath.Join(serviceAccountDir, "token")
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, ErrNoConfig
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("read service account CA: %w", err)
+	}
+	namespace := defaultNamespace
+	if data, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace")); err == nil && strings.TrimSpace(string(data)) != "" {
+		namespace = strings.TrimSpace(string(data))
+	}
+	return &Config{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		Namespace: namespace,
+		TokenFile: tokenFile,
+		CAData:    ca,
+	}, nil
+}
+
+// LoadKubeconfig reads the cluster, user and namespace of a kubeconfig
+// context. Credentials from exec and auth-provider plugins are not supported.
+func LoadKubeconfig(path string, contextName string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse kubeconfig %s: %w", path, err)
+	}
+	root, _ := parsed.(map[string]any)
+	if root == nil {
+		return nil, fmt.Errorf("kubeconfig %s is not a mapping", path)
+	}
+
+	if contextName == "" {
+		contextName, _ = root["current-context"].(string)
+	}
+	kubeContext := namedEntry(root, "contexts", "context", contextName)
+	if kubeContext == nil {
+		return nil, fmt.Errorf("kubeconfig %s has no context %q", path, contextName)
+	}
+	clusterName, _ := kubeContext["cluster"].(string)
+	userName, _ := kubeContext["user"].(string)
+	cluster := namedEntry(root, "clusters", "cluster", clusterName)
+	if cluster == nil {
+		return nil, fmt.Errorf("kubeconfig %s has no cluster %q", path, clusterName)
+	}
+	user := namedEntry(root, "users", "user", userName)
+	if user == nil {
+		user = map[string]any{}
+	}
+
+	dir := filepath.Dir(path)
+	cfg := &Config{Namespace: defaultNamespace}
+	cfg.Server, _ = cluster["server"].(string)
+	if cfg.Server == "" {
+		return nil, fmt.Errorf("kubeconfig cluster %q has no server", clusterName)
+	}
+	if namespace, _ := kubeContext["namespace"].(string); namespace != "" {
+		cfg.Namespace = namespace
+	}
+	// Scalars are strings in YAML kubeconfigs and booleans in JSON ones.
+	insecure := cluster["insecure-skip-tls-verify"]
+	cfg.Insecure = insecure == "true" || insecure == true
+	if cfg.CAData, err = inlineOrFile(cluster, "certificate-authority", dir); err != nil {
+		return nil, err
+	}
+	if cfg.ClientCertData, err = inlineOrFile(user, "client-certificate", dir); err != nil {
+		return nil, err
+	}
+	if cfg.ClientKeyData, err = inlineOrFile(user, "client-key", dir); err != nil {
+		return nil, err
+	}
+	cfg.Token, _ = user["token"].(string)
+	if tokenFile, _ := user["tokenFile"].(string); tokenFile != "" {
+		cfg.TokenFile = resolvePath(dir, tokenFile)
+	}
+	if _, ok := user["exec"]; ok && cfg.Token == "" && cfg.TokenFile == "" && cfg.ClientCertData == nil {
+		return nil, fmt.Errorf("kubeconfig user %q uses an exec credential plugin, which is not supported", userName)
+	}
+	if _, ok := user["auth-provider"]; ok && cfg.Token == "" && cfg.TokenFile == "" && cfg.ClientCertData == nil {
+		return nil, fmt.Errorf("kubeconfig user %q uses an auth provider, which is not supported", userName)
+	}
+	return cfg, nil
+}
+
+// namedEntry finds {name: name, <field>: {...}} in root[list] and returns
+// the inner mapping.
+func namedEntry(root map[string]any, list string, field string, name string) map[string]any {
+	entries, _ := root[list].([]any)
+	for _, entry := range entries {
+		m, _ := entry.(map[string]any)
+		if m == nil || m["name"] != name {
+			continue
+		}
+		inner, _ := m[field].(map[string]any)
+		return inner
+	}
+	return nil
+}
+
+// inlineOrFile reads key+"-data" (base64) or the file named by key.
+func inlineOrFile(m map[string]any, key string, dir string) ([]byte, error) {
+	if encoded, _ := m[key+"-data"].(string); encoded != "" {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s-data: %w", key, err)
+		}
+		return data, nil
+	}
+	if path, _ := m[key].(string); path != "" {
+		data, err := os.ReadFile(resolvePath(dir, path))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", key, err)
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+// resolvePath resolves a kubeconfig path relative to the kubeconfig's
+// directory.
+func resolvePath(dir string, path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
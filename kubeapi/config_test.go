@@ -0,0 +1,101 @@
+package kubeapi
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	value, err := parseYAML([]byte(`
+# comment
+top: "quoted # not a comment"
+url: https://10.0.0.1:6443 # trailing
+list:
+- a
+- name: b
+  nested:
+    key: 'it''s'
+  items:
+    - x
+empty: {}
+none:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"top": "quoted # not a comment",
+		"url": "https://10.0.0.1:6443",
+		"list": []any{
+			"a",
+			map[string]any{"name": "b", "nested": map[string]any{"key": "it's"}, "items": []any{"x"}},
+		},
+		"empty": map[string]any{},
+		"none":  nil,
+	}
+	if !reflect.DeepEqual(value, want) {
+		t.Errorf("parseYAML = %#v", value)
+	}
+}
+
+func TestLoadKubeconfig(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ca.crt"), []byte("ca"), 0o600)
+	os.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0o600)
+	path := filepath.Join(dir, "config")
+	os.WriteFile(path, []byte(`apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: ca.crt
+    server: https://prod.example.com:6443
+  name: prod
+- cluster:
+    insecure-skip-tls-verify: true
+    server: https://dev.example.com:6443
+  name: dev
+contexts:
+- context:
+    cluster: prod
+    user: deployer
+  name: prod
+- context:
+    cluster: dev
+    namespace: sandbox
+    user: deployer
+  name: dev
+current-context: prod
+users:
+- name: deployer
+  user:
+    tokenFile: token
+`), 0o600)
+
+	cfg, err := LoadKubeconfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server != "https://prod.example.com:6443" || string(cfg.CAData) != "ca" || cfg.Namespace != "default" || cfg.Insecure {
+		t.Errorf("prod config = %+v", cfg)
+	}
+	client, err := NewClient(&Config{Server: cfg.Server, TokenFile: cfg.TokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := client.bearerToken(); err != nil || token != "file-token" {
+		t.Errorf("token = %q, %v", token, err)
+	}
+
+	cfg, err = LoadKubeconfig(path, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server != "https://dev.example.com:6443" || cfg.Namespace != "sandbox" || !cfg.Insecure {
+		t.Errorf("dev config = %+v", cfg)
+	}
+
+	if _, err := LoadKubeconfig(path, "missing"); err == nil {
+		t.Error("expected error for unknown context")
+	}
+}
@@ -0,0 +1,72 @@
+package kubeapi
+
+import (
+	"context"
+	"net/url"
+)
+
+// Ingress is the subset of a networking.k8s.io/v1 Ingress that names TLS
+// Secrets and the hosts they serve.
+type Ingress struct {
+	Metadata ObjectMeta  `json:"metadata"`
+	Spec     IngressSpec `json:"spec"`
+}
+
+type IngressSpec struct {
+	TLS   []IngressTLS  `json:"tls,omitempty"`
+	Rules []IngressRule `json:"rules,omitempty"`
+}
+
+type IngressTLS struct {
+	Hosts      []string `json:"hosts,omitempty"`
+	SecretName string   `json:"secretName,omitempty"`
+}
+
+type IngressRule struct {
+	Host string `json:"host,omitempty"`
+}
+
+// ListIngresses returns the Ingresses in namespace, or in every namespace
+// when namespace is empty.
+func (c *Client) ListIngresses(ctx context.Context, namespace string) ([]Ingress, error) {
+	return listAll[Ingress](ctx, c, collectionPath("/apis/networking.k8s.io/v1", namespace, "ingresses"), url.Values{})
+}
+
+// Gateway is the subset of a Gateway API gateway.networking.k8s.io/v1
+// Gateway that names TLS Secrets and the hosts they serve.
+type Gateway struct {
+	Metadata ObjectMeta  `json:"metadata"`
+	Spec     GatewaySpec `json:"spec"`
+}
+
+type GatewaySpec struct {
+	Listeners []GatewayListener `json:"listeners,omitempty"`
+}
+
+type GatewayListener struct {
+	Name     string            `json:"name"`
+	Hostname string            `json:"hostname,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	TLS      *GatewayTLSConfig `json:"tls,omitempty"`
+}
+
+type GatewayTLSConfig struct {
+	Mode            string                  `json:"mode,omitempty"`
+	CertificateRefs []SecretObjectReference `json:"certificateRefs,omitempty"`
+}
+
+// SecretObjectReference points at a certificate. Group and Kind default to
+// the core group and Secret; Namespace defaults to the Gateway's.
+type SecretObjectReference struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ListGateways returns the Gateways in namespace, or in every namespace when
+// namespace is empty. The API server answers 404 when the Gateway API CRDs
+// are not installed.
+func (c *Client) ListGateways(ctx context.Context, namespace string) ([]Gateway, error) {
+	return listAll[Gateway](ctx, c, collectionPath("/apis/gateway.networking.k8s.io/v1", namespace, "gateways"), url.Values{})
+}
@@ -0,0 +1,65 @@
+package kubeapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// SecretTypeTLS is the type of Secrets holding tls.crt and tls.key.
+const SecretTypeTLS = "kubernetes.io/tls"
+
+// Secret is a core/v1 Secret. Data values are base64 on the wire, which
+// encoding/json handles for []byte.
+type Secret struct {
+	APIVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data,omitempty"`
+}
+
+func secretPath(namespace string, name string) string {
+	path := "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets"
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
+
+func (c *Client) GetSecret(ctx context.Context, namespace string, name string) (*Secret, error) {
+	var secret Secret
+	if err := c.do(ctx, http.MethodGet, secretPath(namespace, name), "", nil, &secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (c *Client) CreateSecret(ctx context.Context, secret *Secret) (*Secret, error) {
+	secret.APIVersion = "v1"
+	secret.Kind = "Secret"
+	var created Secret
+	if err := c.do(ctx, http.MethodPost, secretPath(secret.Metadata.Namespace, ""), "application/json", secret, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// PatchSecret applies a JSON merge patch (RFC 7386) to the Secret.
+func (c *Client) PatchSecret(ctx context.Context, namespace string, name string, patch any) (*Secret, error) {
+	var patched Secret
+	if err := c.do(ctx, http.MethodPatch, secretPath(namespace, name), "application/merge-patch+json", patch, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
+
+// ListSecrets returns the Secrets of type secretType in namespace, or in every
+// namespace when namespace is empty. An empty secretType lists all Secrets.
+func (c *Client) ListSecrets(ctx context.Context, namespace string, secretType string) ([]Secret, error) {
+	query := url.Values{}
+	if secretType != "" {
+		query.Set("fieldSelector", "type="+secretType)
+	}
+	return listAll[Secret](ctx, c, collectionPath("/api/v1", namespace, "secrets"), query)
+}
@@ -0,0 +1,223 @@
+package kubeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// yamlLine is one significant line: its indentation and its text with
+// comments removed.
+type yamlLine struct {
+	indent int
+	text   string
+}
+
+// parseYAML reads the block-style YAML subset kubeconfig files are written
+// in: nested mappings, sequences (including "- key: value" items), plain and
+// quoted scalars, and empty {} or [] values. JSON documents are decoded as
+// JSON. Mappings decode to map[string]any, sequences to []any, and scalars
+// to string.
+func parseYAML(data []byte) (any, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var value any
+		if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+
+	var lines []yamlLine
+	for _, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		if strings.ContainsAny(raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))], "\t") {
+			return nil, fmt.Errorf("tabs are not allowed for indentation")
+		}
+		lines = append(lines, yamlLine{indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: stripYAMLComment(text)})
+	}
+	if len(lines) == 0 {
+		return map[string]any{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	value, err := p.node(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("unexpected content %q", p.lines[p.pos].text)
+	}
+	return value, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) node(indent int) (any, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	result := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || isSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("unexpected indentation at %q", line.text)
+		}
+		key, rest, ok := cutMapping(line.text)
+		if !ok {
+			return nil, fmt.Errorf("expected key: value, got %q", line.text)
+		}
+		p.pos++
+		if rest != "" {
+			result[key] = scalar(rest)
+			continue
+		}
+		if p.pos >= len(p.lines) {
+			result[key] = nil
+			continue
+		}
+		next := p.lines[p.pos]
+		switch {
+		case next.indent > indent:
+			value, err := p.node(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		case next.indent == indent && isSequenceItem(next.text):
+			// Sequences are commonly written at the same indentation as
+			// their key.
+			value, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		default:
+			result[key] = nil
+		}
+	}
+	return result, nil
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	result := make([]any, 0)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isSequenceItem(line.text) {
+			break
+		}
+		content := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if content == "" {
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				value, err := p.node(p.lines[p.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, value)
+			} else {
+				result = append(result, nil)
+			}
+			continue
+		}
+		if _, _, ok := cutMapping(content); ok || isSequenceItem(content) {
+			// The item's content starts a nested block at its own column.
+			offset := indent + len(line.text) - len(content)
+			p.lines[p.pos] = yamlLine{indent: offset, text: content}
+			value, err := p.node(offset)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+			continue
+		}
+		result = append(result, scalar(content))
+		p.pos++
+	}
+	return result, nil
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// cutMapping splits "key: value" or "key:". Colons inside values, such as in
+// URLs, are not separators.
+func cutMapping(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key := text[1 : end+1]
+		rest := strings.TrimSpace(text[end+2:])
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	if idx := strings.Index(text, ": "); idx >= 0 {
+		return strings.TrimSpace(text[:idx]), strings.TrimSpace(text[idx+2:]), true
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(strings.TrimSuffix(text, ":")), "", true
+	}
+	return "", "", false
+}
+
+func scalar(text string) any {
+	switch text {
+	case "{}":
+		return map[string]any{}
+	case "[]":
+		return []any{}
+	case "~", "null":
+		return nil
+	}
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		var value string
+		if err := json.Unmarshal([]byte(text), &value); err == nil {
+			return value
+		}
+		return text[1 : len(text)-1]
+	}
+	if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	}
+	return text
+}
+
+// stripYAMLComment removes a trailing " #" comment outside quotes.
+func stripYAMLComment(text string) string {
+	inSingle, inDouble := false, false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\'':
+			if !inDouble {
+				inSingle = !inSingle
+			}
+		case '"':
+			if !inSingle && (i == 0 || text[i-1] != '\\') {
+				inDouble = !inDouble
+			}
+		case '#':
+			if !inSingle && !inDouble && i > 0 && (text[i-1] == ' ' || text[i-1] == '\t') {
+				return strings.TrimSpace(text[:i])
+			}
+		}
+	}
+	return text
+}
@@ -0,0 +1,255 @@
+// Package metrics keeps the agent's runtime counters and renders them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var pollDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(value float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(pollDurationBuckets))
+	}
+	for i, bound := range pollDurationBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type updateCommandRun struct {
+	duration float64
+	exitCode int
+}
+
+type registry struct {
+	mu                     sync.Mutex
+	version                string
+	pollTotal              map[string]uint64
+	pollDuration           histogram
+	lastSuccessfulPoll     time.Time
+	configStatus           map[string]string
+	certificateExpiry      map[string]time.Time
+	updateCommandLast      map[string]updateCommandRun
+	updateCommandTotal     map[[2]string]uint64
+	inventoryItems         map[string]int
+	inventoryLastCollected time.Time
+}
+
+var current = &registry{
+	pollTotal:          make(map[string]uint64),
+	configStatus:       make(map[string]string),
+	certificateExpiry:  make(map[string]time.Time),
+	updateCommandLast:  make(map[string]updateCommandRun),
+	updateCommandTotal: make(map[[2]string]uint64),
+	inventoryItems:     make(map[string]int),
+}
+
+const (
+	PollSuccess      = "success"
+	PollFailure      = "failure"
+	PollUnauthorized = "unauthorized"
+)
+
+func SetVersion(version string) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.version = version
+}
+
+// ObservePoll records the outcome and latency of a configuration poll.
+func ObservePoll(result string, duration time.Duration) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.pollTotal[result]++
+	current.pollDuration.observe(duration.Seconds())
+	if result == PollSuccess {
+		current.lastSuccessfulPoll = time.Now()
+	}
+}
+
+// SetConfigStatuses replaces the per-configuration status set, so configs that
+// were removed from the agent stop being reported.
+func SetConfigStatuses(statuses map[string]string) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.configStatus = make(map[string]string, len(statuses))
+	for configId, status := range statuses {
+		current.configStatus[configId] = status
+	}
+}
+
+// SetCertificateExpiries replaces the per-configuration deployed certificate
+// expiry set.
+func SetCertificateExpiries(expiries map[string]time.Time) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.certificateExpiry = make(map[string]time.Time, len(expiries))
+	for configId, notAfter := range expiries {
+		current.certificateExpiry[configId] = notAfter
+	}
+}
+
+// ObserveUpdateCommand records a finished update command run. exitCode is -1
+// when the command could not be started or was killed.
+func ObserveUpdateCommand(configId string, duration time.Duration, exitCode int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.updateCommandLast[configId] = updateCommandRun{duration: duration.Seconds(), exitCode: exitCode}
+	result := "success"
+	if exitCode != 0 {
+		result = "failure"
+	}
+	current.updateCommandTotal[[2]string{configId, result}]++
+}
+
+// SetInventoryItems replaces the per-provider inventory item counts.
+func SetInventoryItems(counts map[string]int) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.inventoryItems = make(map[string]int, len(counts))
+	for provider, count := range counts {
+		current.inventoryItems[provider] = count
+	}
+	current.inventoryLastCollected = time.Now()
+}
+
+// Write renders all metrics in the Prometheus text exposition format.
+func Write(w io.Writer) error {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "certkit_agent_info", "gauge", "Agent build information.")
+	writeSample(&b, "certkit_agent_info", labels("version", current.version), 1)
+
+	writeHeader(&b, "certkit_agent_polls_total", "counter", "Configuration polls by result.")
+	for _, result := range sortedKeys(current.pollTotal) {
+		writeSample(&b, "certkit_agent_polls_total", labels("result", result), float64(current.pollTotal[result]))
+	}
+
+	writeHeader(&b, "certkit_agent_poll_duration_seconds", "histogram", "Configuration poll latency.")
+	h := current.pollDuration
+	for i, bound := range pollDurationBuckets {
+		var count uint64
+		if h.counts != nil {
+			count = h.counts[i]
+		}
+		writeSample(&b, "certkit_agent_poll_duration_seconds_bucket", labels("le", formatFloat(bound)), float64(count))
+	}
+	writeSample(&b, "certkit_agent_poll_duration_seconds_bucket", labels("le", "+Inf"), float64(h.count))
+	writeSample(&b, "certkit_agent_poll_duration_seconds_sum", "", h.sum)
+	writeSample(&b, "certkit_agent_poll_duration_seconds_count", "", float64(h.count))
+
+	writeHeader(&b, "certkit_agent_last_successful_poll_timestamp_seconds", "gauge", "Unix time of the last successful configuration poll.")
+	writeSample(&b, "certkit_agent_last_successful_poll_timestamp_seconds", "", unixSeconds(current.lastSuccessfulPoll))
+
+	writeHeader(&b, "certkit_agent_config_status", "gauge", "Last synchronization status per certificate configuration.")
+	for _, configId := range sortedKeys(current.configStatus) {
+		writeSample(&b, "certkit_agent_config_status", labels("config_id", configId, "status", current.configStatus[configId]), 1)
+	}
+
+	writeHeader(&b, "certkit_agent_certificate_expiry_timestamp_seconds", "gauge", "Unix time at which the deployed certificate expires.")
+	for _, configId := range sortedKeys(current.certificateExpiry) {
+		writeSample(&b, "certkit_agent_certificate_expiry_timestamp_seconds", labels("config_id", configId), unixSeconds(current.certificateExpiry[configId]))
+	}
+
+	writeHeader(&b, "certkit_agent_update_command_duration_seconds", "gauge", "Duration of the last update command run.")
+	for _, configId := range sortedKeys(current.updateCommandLast) {
+		writeSample(&b, "certkit_agent_update_command_duration_seconds", labels("config_id", configId), current.updateCommandLast[configId].duration)
+	}
+
+	writeHeader(&b, "certkit_agent_update_command_exit_code", "gauge", "Exit code of the last update command run (-1 if it did not exit normally).")
+	for _, configId := range sortedKeys(current.updateCommandLast) {
+		writeSample(&b, "certkit_agent_update_command_exit_code", labels("config_id", configId), float64(current.updateCommandLast[configId].exitCode))
+	}
+
+	writeHeader(&b, "certkit_agent_update_commands_total", "counter", "Update command runs by result.")
+	runKeys := make([][2]string, 0, len(current.updateCommandTotal))
+	for key := range current.updateCommandTotal {
+		runKeys = append(runKeys, key)
+	}
+	sort.Slice(runKeys, func(i, j int) bool {
+		if runKeys[i][0] != runKeys[j][0] {
+			return runKeys[i][0] < runKeys[j][0]
+		}
+		return runKeys[i][1] < runKeys[j][1]
+	})
+	for _, key := range runKeys {
+		writeSample(&b, "certkit_agent_update_commands_total", labels("config_id", key[0], "result", key[1]), float64(current.updateCommandTotal[key]))
+	}
+
+	writeHeader(&b, "certkit_agent_inventory_items", "gauge", "Inventory items found per provider in the last collection.")
+	for _, provider := range sortedKeys(current.inventoryItems) {
+		writeSample(&b, "certkit_agent_inventory_items", labels("provider", provider), float64(current.inventoryItems[provider]))
+	}
+
+	writeHeader(&b, "certkit_agent_inventory_last_collected_timestamp_seconds", "gauge", "Unix time of the last inventory collection.")
+	writeSample(&b, "certkit_agent_inventory_last_collected_timestamp_seconds", "", unixSeconds(current.inventoryLastCollected))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(b *strings.Builder, name, labelSet string, value float64) {
+	fmt.Fprintf(b, "%s%s %s\n", name, labelSet, formatFloat(value))
+}
+
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escapeLabelValue(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteExposition(t *testing.T) {
+	ObservePoll(PollSuccess, 300*time.Millisecond)
+	SetConfigStatuses(map[string]string{"cfg-1": "SYNCED"})
+	ObserveUpdateCommand("cfg-1", time.Second, 2)
+	SetInventoryItems(map[string]int{"nginx": 3})
+
+	var b strings.Builder
+	if err := Write(&b); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		`certkit_agent_polls_total{result="success"} 1`,
+		`certkit_agent_poll_duration_seconds_bucket{le="0.25"} 0`,
+		`certkit_agent_poll_duration_seconds_bucket{le="0.5"} 1`,
+		`certkit_agent_config_status{config_id="cfg-1",status="SYNCED"} 1`,
+		`certkit_agent_update_command_exit_code{config_id="cfg-1"} 2`,
+		`certkit_agent_update_commands_total{config_id="cfg-1",result="failure"} 1`,
+		`certkit_agent_inventory_items{provider="nginx"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	got := labels("a", "x\"y\\z\nw")
+	want := `{a="x\"y\\z\nw"}`
+	if got != want {
+		t.Fatalf("labels() = %s, want %s", got, want)
+	}
+}
+
+func TestListenRejectsNonLoopback(t *testing.T) {
+	if _, err := Listen("0.0.0.0:9464"); err == nil {
+		t.Fatal("Listen(0.0.0.0:9464) expected error")
+	}
+	listener, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen(127.0.0.1:0) error: %v", err)
+	}
+	listener.Close()
+}
+
+func TestListenUnixSocketKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	notSocket := filepath.Join(dir, "nginx.conf")
+	if err := os.WriteFile(notSocket, []byte("events {}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen("unix:" + notSocket); err == nil {
+		t.Fatal("Listen over a regular file expected error")
+	}
+	if data, err := os.ReadFile(notSocket); err != nil || string(data) != "events {}" {
+		t.Fatalf("file changed: %q, %v", data, err)
+	}
+
+	// A socket left by a previous run is replaced.
+	socketPath := filepath.Join(dir, "metrics.sock")
+	for i := 0; i < 2; i++ {
+		listener, err := Listen("unix:" + socketPath)
+		if err != nil {
+			t.Fatalf("Listen(%d) error: %v", i, err)
+		}
+		if l, ok := listener.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+		listener.Close()
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/certkit-io/certkit-agent/utils"
+)
+
+const unixAddressPrefix = "unix:"
+
+// Listen opens the metrics listener. address is either "unix:/path/to.sock"
+// or a loopback "host:port"; other interfaces are refused so metrics are never
+// exposed off-host by accident.
+func Listen(address string) (net.Listener, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, fmt.Errorf("metrics listen address is empty")
+	}
+
+	if socketPath, ok := strings.CutPrefix(address, unixAddressPrefix); ok {
+		if socketPath == "" {
+			return nil, fmt.Errorf("metrics unix socket path is empty")
+		}
+		if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
+			return nil, err
+		}
+		if err := utils.RemoveStaleSocket(socketPath); err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(socketPath, 0o660); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("chmod %s: %w", socketPath, err)
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics listen address %q: %w", address, err)
+	}
+	if !isLoopbackHost(host) {
+		return nil, fmt.Errorf("metrics listen address %q must be a loopback address or unix socket", address)
+	}
+	return net.Listen("tcp", address)
+}
+
+// Serve exposes /metrics on listener until ctx is cancelled.
+func Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Write(w); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	})
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,332 @@
+// Package sftp is a minimal SFTP (version 3) client: enough to read, write
+// and replace files on a host reached over SSH, without external libraries.
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// chunkSize is the most data sent or requested in one packet. OpenSSH
+// accepts up to 256 KiB; 32 KiB is what every server supports.
+const chunkSize = 32 * 1024
+
+type Client struct {
+	mu         sync.Mutex
+	r          io.Reader
+	w          io.WriteCloser
+	session    *ssh.Session
+	nextId     uint32
+	extensions map[string]string
+}
+
+// NewClient starts the sftp subsystem on conn.
+func NewClient(conn *ssh.Client) (*Client, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("start sftp subsystem: %w", err)
+	}
+	client, err := NewClientPipe(r, w)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	client.session = session
+	return client, nil
+}
+
+// NewClientPipe speaks SFTP over an existing stream, such as the stdio of an
+// sftp-server process.
+func NewClientPipe(r io.Reader, w io.WriteCloser) (*Client, error) {
+	c := &Client{r: r, w: w, extensions: make(map[string]string)}
+	init := buffer{}
+	init.byte(packetInit)
+	init.uint32(protocolVersion)
+	if err := writePacket(w, init); err != nil {
+		return nil, fmt.Errorf("sftp: send init: %w", err)
+	}
+	payload, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: read version: %w", err)
+	}
+	if payload[0] != packetVersion {
+		return nil, fmt.Errorf("sftp: unexpected packet %d during init", payload[0])
+	}
+	reply := &reader{data: payload[1:]}
+	if version := reply.uint32(); reply.err == nil && version < protocolVersion {
+		return nil, fmt.Errorf("sftp: server speaks version %d", version)
+	}
+	for len(reply.data) > 0 && reply.err == nil {
+		name := reply.string()
+		c.extensions[name] = reply.string()
+	}
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	err := c.w.Close()
+	if c.session != nil {
+		c.session.Close()
+	}
+	return err
+}
+
+// request sends one packet and waits for its reply, returning the reply type
+// and the payload after the request id.
+func (c *Client) request(packetType byte, build func(b *buffer)) (byte, *reader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextId++
+	id := c.nextId
+	b := buffer{}
+	b.byte(packetType)
+	b.uint32(id)
+	build(&b)
+	if err := writePacket(c.w, b); err != nil {
+		return 0, nil, err
+	}
+	payload, err := readPacket(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+	reply := &reader{data: payload[1:]}
+	if replyId := reply.uint32(); reply.err != nil || replyId != id {
+		return 0, nil, fmt.Errorf("sftp: reply for request %d, want %d", replyId, id)
+	}
+	return payload[0], reply, nil
+}
+
+// status decodes a STATUS reply, returning nil for StatusOK.
+func status(reply *reader) error {
+	code := reply.uint32()
+	message := reply.string()
+	if reply.err != nil {
+		return reply.err
+	}
+	if code == StatusOK {
+		return nil
+	}
+	return &StatusError{Code: code, Message: message}
+}
+
+func unexpected(packetType byte, reply *reader) error {
+	if packetType == packetStatus {
+		if err := status(reply); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("sftp: unexpected reply packet %d", packetType)
+}
+
+// expectStatus sends a request whose only reply is a status.
+func (c *Client) expectStatus(packetType byte, build func(b *buffer)) error {
+	replyType, reply, err := c.request(packetType, build)
+	if err != nil {
+		return err
+	}
+	if replyType != packetStatus {
+		return unexpected(replyType, reply)
+	}
+	return status(reply)
+}
+
+func (c *Client) open(name string, flags uint32, perm os.FileMode) (string, error) {
+	replyType, reply, err := c.request(packetOpen, func(b *buffer) {
+		b.string(name)
+		b.uint32(flags)
+		if flags&openCreate != 0 {
+			b.uint32(attrPermissions)
+			b.uint32(uint32(perm.Perm()))
+		} else {
+			b.uint32(0)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if replyType != packetHandle {
+		return "", unexpected(replyType, reply)
+	}
+	handle := reply.string()
+	return handle, reply.err
+}
+
+func (c *Client) closeHandle(handle string) error {
+	return c.expectStatus(packetClose, func(b *buffer) { b.string(handle) })
+}
+
+// ReadFile returns the contents of a remote file.
+func (c *Client) ReadFile(name string) ([]byte, error) {
+	handle, err := c.open(name, openRead, 0)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for {
+		replyType, reply, err := c.request(packetRead, func(b *buffer) {
+			b.string(handle)
+			b.uint64(uint64(len(data)))
+			b.uint32(chunkSize)
+		})
+		if err != nil {
+			c.closeHandle(handle)
+			return nil, err
+		}
+		if replyType == packetStatus {
+			err := status(reply)
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.Code == StatusEOF {
+				break
+			}
+			c.closeHandle(handle)
+			if err == nil {
+				err = errors.New("sftp: read returned no data")
+			}
+			return nil, err
+		}
+		if replyType != packetData {
+			c.closeHandle(handle)
+			return nil, unexpected(replyType, reply)
+		}
+		chunk := reply.bytes()
+		if reply.err != nil {
+			c.closeHandle(handle)
+			return nil, reply.err
+		}
+		data = append(data, chunk...)
+	}
+	return data, c.closeHandle(handle)
+}
+
+// WriteFile creates or truncates a remote file and writes data to it. perm
+// applies when the file is created.
+func (c *Client) WriteFile(name string, data []byte, perm os.FileMode) error {
+	handle, err := c.open(name, openWrite|openCreate|openTruncate, perm)
+	if err != nil {
+		return err
+	}
+	for offset := 0; offset < len(data); offset += chunkSize {
+		chunk := data[offset:min(offset+chunkSize, len(data))]
+		err := c.expectStatus(packetWrite, func(b *buffer) {
+			b.string(handle)
+			b.uint64(uint64(offset))
+			b.bytes(chunk)
+		})
+		if err != nil {
+			c.closeHandle(handle)
+			return err
+		}
+	}
+	return c.closeHandle(handle)
+}
+
+// Stat follows symlinks.
+func (c *Client) Stat(name string) (*FileInfo, error) {
+	replyType, reply, err := c.request(packetStat, func(b *buffer) { b.string(name) })
+	if err != nil {
+		return nil, err
+	}
+	if replyType != packetAttrs {
+		return nil, unexpected(replyType, reply)
+	}
+	info := reply.attrs()
+	return &info, reply.err
+}
+
+func (c *Client) Chmod(name string, mode os.FileMode) error {
+	return c.expectStatus(packetSetstat, func(b *buffer) {
+		b.string(name)
+		b.uint32(attrPermissions)
+		b.uint32(uint32(mode.Perm()))
+	})
+}
+
+func (c *Client) Chown(name string, uid uint32, gid uint32) error {
+	return c.expectStatus(packetSetstat, func(b *buffer) {
+		b.string(name)
+		b.uint32(attrUidGid)
+		b.uint32(uid)
+		b.uint32(gid)
+	})
+}
+
+func (c *Client) Remove(name string) error {
+	return c.expectStatus(packetRemove, func(b *buffer) { b.string(name) })
+}
+
+func (c *Client) Mkdir(name string, perm os.FileMode) error {
+	return c.expectStatus(packetMkdir, func(b *buffer) {
+		b.string(name)
+		b.uint32(attrPermissions)
+		b.uint32(uint32(perm.Perm()))
+	})
+}
+
+// MkdirAll creates a directory and any missing parents.
+func (c *Client) MkdirAll(name string, perm os.FileMode) error {
+	info, err := c.Stat(name)
+	if err == nil {
+		if !info.Mode.IsDir() {
+			return fmt.Errorf("sftp: %s is not a directory", name)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if parent := path.Dir(name); parent != name {
+		if err := c.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := c.Mkdir(name, perm); err != nil {
+		// Another writer may have created it in the meantime.
+		if info, statErr := c.Stat(name); statErr == nil && info.Mode.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Rename replaces newName with oldName. Servers with the OpenSSH
+// posix-rename extension do this atomically; otherwise newName is removed
+// first, since version 3 RENAME refuses to overwrite.
+func (c *Client) Rename(oldName string, newName string) error {
+	if _, ok := c.extensions[posixRename]; ok {
+		return c.expectStatus(packetExtended, func(b *buffer) {
+			b.string(posixRename)
+			b.string(oldName)
+			b.string(newName)
+		})
+	}
+	if err := c.Remove(newName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return c.expectStatus(packetRename, func(b *buffer) {
+		b.string(oldName)
+		b.string(newName)
+	})
+}
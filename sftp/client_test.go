@@ -0,0 +1,105 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/certkit-io/certkit-agent/sftp/sftptest"
+	"golang.org/x/crypto/ssh"
+)
+
+func dialTestServer(t *testing.T) *Client {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := sftptest.NewServer(signer.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := ssh.Dial("tcp", server.Addr, &ssh.ClientConfig{
+		User:            "deploy",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(server.HostKey),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientWritesAndReplacesFiles(t *testing.T) {
+	client := dialTestServer(t)
+	dir := filepath.Join(t.TempDir(), "etc", "ssl")
+	if err := client.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	// Larger than one chunk, so reads and writes take several packets.
+	data := bytes.Repeat([]byte("certificate\n"), 10000)
+	target := filepath.Join(dir, "site.pem")
+	if err := client.WriteFile(target+".tmp", data, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(target, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rename(target+".tmp", target); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := client.Chmod(target, 0o640); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+
+	got, err := client.ReadFile(target)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadFile = %d bytes, %v", len(got), err)
+	}
+	info, err := client.Stat(target)
+	if err != nil || info.Size != uint64(len(data)) || info.Mode != 0o640 {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+
+	if _, err := client.ReadFile(filepath.Join(dir, "missing.pem")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(missing) error = %v", err)
+	}
+}
+
+func TestClientRenameWithoutPosixRename(t *testing.T) {
+	client := dialTestServer(t)
+	delete(client.extensions, posixRename)
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(target, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteFile(target+".tmp", []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rename(target+".tmp", target); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "new" {
+		t.Errorf("target = %q", data)
+	}
+}
@@ -0,0 +1,224 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Packet types (SFTP version 3).
+const (
+	packetInit     byte = 1
+	packetVersion  byte = 2
+	packetOpen     byte = 3
+	packetClose    byte = 4
+	packetRead     byte = 5
+	packetWrite    byte = 6
+	packetSetstat  byte = 9
+	packetRemove   byte = 13
+	packetMkdir    byte = 14
+	packetStat     byte = 17
+	packetRename   byte = 18
+	packetStatus   byte = 101
+	packetHandle   byte = 102
+	packetData     byte = 103
+	packetAttrs    byte = 105
+	packetExtended byte = 200
+)
+
+// Open flags.
+const (
+	openRead     uint32 = 0x01
+	openWrite    uint32 = 0x02
+	openCreate   uint32 = 0x08
+	openTruncate uint32 = 0x10
+)
+
+// Attribute flags.
+const (
+	attrSize        uint32 = 0x01
+	attrUidGid      uint32 = 0x02
+	attrPermissions uint32 = 0x04
+	attrAcModTime   uint32 = 0x08
+	attrExtended    uint32 = 0x80000000
+)
+
+// Status codes.
+const (
+	StatusOK               uint32 = 0
+	StatusEOF              uint32 = 1
+	StatusNoSuchFile       uint32 = 2
+	StatusPermissionDenied uint32 = 3
+	StatusFailure          uint32 = 4
+	StatusOpUnsupported    uint32 = 8
+)
+
+const (
+	protocolVersion = 3
+	maxPacketSize   = 256 * 1024
+	posixRename     = "posix-rename@openssh.com"
+)
+
+// StatusError is a failure reported by the server.
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("sftp: status %d", e.Code)
+	}
+	return fmt.Sprintf("sftp: %s (status %d)", e.Message, e.Code)
+}
+
+// Is lets errors.Is match fs.ErrNotExist and fs.ErrPermission.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Code == StatusNoSuchFile
+	case fs.ErrPermission:
+		return e.Code == StatusPermissionDenied
+	}
+	return false
+}
+
+// FileInfo is the subset of file attributes the server reported.
+type FileInfo struct {
+	Size uint64
+	Mode os.FileMode
+	Uid  uint32
+	Gid  uint32
+}
+
+// buffer builds a packet payload.
+type buffer []byte
+
+func (b *buffer) byte(v byte) {
+	*b = append(*b, v)
+}
+
+func (b *buffer) uint32(v uint32) {
+	*b = binary.BigEndian.AppendUint32(*b, v)
+}
+
+func (b *buffer) uint64(v uint64) {
+	*b = binary.BigEndian.AppendUint64(*b, v)
+}
+
+func (b *buffer) string(v string) {
+	b.uint32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) bytes(v []byte) {
+	b.uint32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+// reader decodes a packet payload. The first decoding error sticks.
+type reader struct {
+	data []byte
+	err  error
+}
+
+var errShortPacket = errors.New("sftp: short packet")
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if r.err != nil || len(r.data) < 8 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint64(len(r.data)) < uint64(n) {
+		r.err = errShortPacket
+		return nil
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) attrs() FileInfo {
+	var info FileInfo
+	flags := r.uint32()
+	if flags&attrSize != 0 {
+		info.Size = r.uint64()
+	}
+	if flags&attrUidGid != 0 {
+		info.Uid = r.uint32()
+		info.Gid = r.uint32()
+	}
+	if flags&attrPermissions != 0 {
+		info.Mode = fileMode(r.uint32())
+	}
+	if flags&attrAcModTime != 0 {
+		r.uint32()
+		r.uint32()
+	}
+	if flags&attrExtended != 0 {
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			r.string()
+			r.string()
+		}
+	}
+	return info
+}
+
+// fileMode converts POSIX st_mode bits to an os.FileMode.
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0o777)
+	switch mode & 0o170000 {
+	case 0o040000:
+		m |= os.ModeDir
+	case 0o120000:
+		m |= os.ModeSymlink
+	}
+	return m
+}
+
+func writePacket(w io.Writer, payload []byte) error {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+func readPacket(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > maxPacketSize {
+		return nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
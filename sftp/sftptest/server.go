@@ -0,0 +1,442 @@
+// Package sftptest runs an in-process SSH server for tests. It serves the
+// sftp subsystem against the local filesystem and runs exec requests with
+// sh -c, recording each command.
+package sftptest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type Server struct {
+	// Addr is the "host:port" the server listens on.
+	Addr string
+	// HostKey is the server's public host key.
+	HostKey ssh.PublicKey
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu       sync.Mutex
+	commands []string
+	logins   int
+}
+
+// NewServer starts a server on a loopback port that accepts only the
+// authorized key, for any user.
+func NewServer(authorized ssh.PublicKey) (*Server, error) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, errors.New("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: listener.Addr().String(), HostKey: signer.PublicKey(), listener: listener, config: config}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// Logins returns the number of connections that authenticated.
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Commands returns the exec requests received so far.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.logins++
+	s.mu.Unlock()
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(channel, requests)
+	}
+}
+
+func (s *Server) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		name, ok := requestString(req.Payload)
+		switch {
+		case ok && req.Type == "subsystem" && name == "sftp":
+			req.Reply(true, nil)
+			serveSFTP(channel)
+			return
+		case ok && req.Type == "exec":
+			req.Reply(true, nil)
+			s.mu.Lock()
+			s.commands = append(s.commands, name)
+			s.mu.Unlock()
+			cmd := exec.Command("sh", "-c", name)
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			status := 0
+			if err := cmd.Run(); err != nil {
+				status = 255
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					status = exitErr.ExitCode()
+				}
+			}
+			channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, uint32(status)))
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func requestString(payload []byte) (string, bool) {
+	if len(payload) < 4 {
+		return "", false
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint64(len(payload)-4) < uint64(n) {
+		return "", false
+	}
+	return string(payload[4 : 4+n]), true
+}
+
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpSetstat  = 9
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpAttrs    = 105
+	fxpExtended = 200
+
+	fxOK           = 0
+	fxEOF          = 1
+	fxNoSuchFile   = 2
+	fxPermission   = 3
+	fxFailure      = 4
+	fxUnsupported  = 8
+	posixRenameExt = "posix-rename@openssh.com"
+)
+
+// packet decodes and encodes SFTP fields.
+type packet struct {
+	data []byte
+}
+
+func (p *packet) uint32() uint32 {
+	if len(p.data) < 4 {
+		p.data = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(p.data)
+	p.data = p.data[4:]
+	return v
+}
+
+func (p *packet) uint64() uint64 {
+	return uint64(p.uint32())<<32 | uint64(p.uint32())
+}
+
+func (p *packet) string() string {
+	n := p.uint32()
+	if uint64(len(p.data)) < uint64(n) {
+		p.data = nil
+		return ""
+	}
+	v := string(p.data[:n])
+	p.data = p.data[n:]
+	return v
+}
+
+// attrs applies the permissions and ownership in an attribute block to path,
+// or returns the permissions when path is empty.
+func (p *packet) attrs(path string) (os.FileMode, error) {
+	flags := p.uint32()
+	var mode os.FileMode = 0o644
+	if flags&0x1 != 0 {
+		p.uint64()
+	}
+	if flags&0x2 != 0 {
+		uid, gid := p.uint32(), p.uint32()
+		if path != "" {
+			if err := os.Chown(path, int(uid), int(gid)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if flags&0x4 != 0 {
+		mode = os.FileMode(p.uint32() & 0o777)
+		if path != "" {
+			if err := os.Chmod(path, mode); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return mode, nil
+}
+
+type response []byte
+
+func (r *response) byte(v byte)     { *r = append(*r, v) }
+func (r *response) uint32(v uint32) { *r = binary.BigEndian.AppendUint32(*r, v) }
+func (r *response) string(v string) {
+	r.uint32(uint32(len(v)))
+	*r = append(*r, v...)
+}
+
+func send(w io.Writer, r response) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(r))), r...))
+	return err
+}
+
+func statusFor(err error) uint32 {
+	switch {
+	case err == nil:
+		return fxOK
+	case errors.Is(err, io.EOF):
+		return fxEOF
+	case errors.Is(err, os.ErrNotExist):
+		return fxNoSuchFile
+	case errors.Is(err, os.ErrPermission):
+		return fxPermission
+	}
+	return fxFailure
+}
+
+func serveSFTP(rw io.ReadWriter) {
+	handles := make(map[string]*os.File)
+	defer func() {
+		for _, f := range handles {
+			f.Close()
+		}
+	}()
+	nextHandle := 0
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(rw, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(rw, body); err != nil {
+			return
+		}
+		p := &packet{data: body[1:]}
+		if body[0] == fxpInit {
+			r := response{}
+			r.byte(fxpVersion)
+			r.uint32(3)
+			r.string(posixRenameExt)
+			r.string("1")
+			if send(rw, r) != nil {
+				return
+			}
+			continue
+		}
+
+		id := p.uint32()
+		r := response{}
+		reply := func(err error) {
+			r = response{}
+			r.byte(fxpStatus)
+			r.uint32(id)
+			r.uint32(statusFor(err))
+			message := ""
+			if err != nil {
+				message = err.Error()
+			}
+			r.string(message)
+			r.string("")
+		}
+
+		switch body[0] {
+		case fxpOpen:
+			path := p.string()
+			pflags := p.uint32()
+			mode, _ := p.attrs("")
+			flags := 0
+			switch {
+			case pflags&0x3 == 0x3:
+				flags = os.O_RDWR
+			case pflags&0x2 != 0:
+				flags = os.O_WRONLY
+			}
+			if pflags&0x8 != 0 {
+				flags |= os.O_CREATE
+			}
+			if pflags&0x10 != 0 {
+				flags |= os.O_TRUNC
+			}
+			f, err := os.OpenFile(path, flags, mode)
+			if err != nil {
+				reply(err)
+				break
+			}
+			nextHandle++
+			handle := strconv.Itoa(nextHandle)
+			handles[handle] = f
+			r.byte(fxpHandle)
+			r.uint32(id)
+			r.string(handle)
+		case fxpClose:
+			handle := p.string()
+			f, ok := handles[handle]
+			if !ok {
+				reply(errors.New("bad handle"))
+				break
+			}
+			delete(handles, handle)
+			reply(f.Close())
+		case fxpRead:
+			f, ok := handles[p.string()]
+			offset := p.uint64()
+			length := p.uint32()
+			if !ok {
+				reply(errors.New("bad handle"))
+				break
+			}
+			buf := make([]byte, length)
+			n, err := f.ReadAt(buf, int64(offset))
+			if n == 0 {
+				if err == nil {
+					err = io.EOF
+				}
+				reply(err)
+				break
+			}
+			r.byte(fxpData)
+			r.uint32(id)
+			r.string(string(buf[:n]))
+		case fxpWrite:
+			f, ok := handles[p.string()]
+			offset := p.uint64()
+			data := p.string()
+			if !ok {
+				reply(errors.New("bad handle"))
+				break
+			}
+			_, err := f.WriteAt([]byte(data), int64(offset))
+			reply(err)
+		case fxpStat:
+			info, err := os.Stat(p.string())
+			if err != nil {
+				reply(err)
+				break
+			}
+			mode := uint32(info.Mode().Perm())
+			if info.IsDir() {
+				mode |= 0o040000
+			} else {
+				mode |= 0o100000
+			}
+			r.byte(fxpAttrs)
+			r.uint32(id)
+			r.uint32(0x1 | 0x4)
+			r = binary.BigEndian.AppendUint64(r, uint64(info.Size()))
+			r.uint32(mode)
+		case fxpSetstat:
+			path := p.string()
+			_, err := p.attrs(path)
+			reply(err)
+		case fxpRemove:
+			reply(os.Remove(p.string()))
+		case fxpMkdir:
+			path := p.string()
+			mode, _ := p.attrs("")
+			reply(os.Mkdir(path, mode))
+		case fxpRename:
+			oldPath, newPath := p.string(), p.string()
+			if _, err := os.Lstat(newPath); err == nil {
+				reply(errors.New("file exists"))
+				break
+			}
+			reply(os.Rename(oldPath, newPath))
+		case fxpExtended:
+			if p.string() != posixRenameExt {
+				r.byte(fxpStatus)
+				r.uint32(id)
+				r.uint32(fxUnsupported)
+				r.string("unsupported extension")
+				r.string("")
+				break
+			}
+			oldPath, newPath := p.string(), p.string()
+			reply(os.Rename(oldPath, newPath))
+		default:
+			r.byte(fxpStatus)
+			r.uint32(id)
+			r.uint32(fxUnsupported)
+			r.string("unsupported")
+			r.string("")
+		}
+		if send(rw, r) != nil {
+			return
+		}
+	}
+}
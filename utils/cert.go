@@ -7,7 +7,9 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/pkcs12"
 )
@@ -18,6 +20,12 @@ func GetCertificateSha1(path string) (string, error) {
 		return "", err
 	}
 
+	return GetCertificateSha1FromPem(data)
+}
+
+// GetCertificateSha1FromPem returns the SHA-1 of the first certificate in
+// data.
+func GetCertificateSha1FromPem(data []byte) (string, error) {
 	certDER, err := firstCertificateDERFromPEM(data)
 	if err != nil {
 		return "", err
@@ -99,3 +107,62 @@ func ensureTrailingNewline(value string) string {
 	}
 	return value + "\n"
 }
+
+func GetCertificateNotAfter(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return GetCertificateNotAfterFromPem(data)
+}
+
+func GetCertificateNotAfterFromPem(data []byte) (time.Time, error) {
+	certDER, err := firstCertificateDERFromPEM(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	return cert.NotAfter, nil
+}
+
+func GetCertificateNotAfterFromPfx(path string, password string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	pemBlocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decode pfx: %w", err)
+	}
+
+	for _, block := range pemBlocks {
+		if block == nil || block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parse certificate from pfx: %w", err)
+		}
+		return cert.NotAfter, nil
+	}
+
+	return time.Time{}, fmt.Errorf("no certificate block found in PFX")
+}
+
+// PfxPasswordFilePath is where the agent stores the password for a PFX it
+// deployed: next to the PFX, as <name>.pfxpassword.txt.
+func PfxPasswordFilePath(pfxPath string) string {
+	fileName := filepath.Base(pfxPath)
+	fileExt := filepath.Ext(fileName)
+	fileStem := strings.TrimSuffix(fileName, fileExt)
+	if fileStem == "" {
+		fileStem = fileName
+	}
+	return filepath.Join(filepath.Dir(pfxPath), fileStem+".pfxpassword.txt")
+}
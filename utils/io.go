@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -51,3 +53,23 @@ func WriteFileAtomic(path string, contents []byte, perm os.FileMode) error {
 
 	return os.Rename(tmpName, path)
 }
+
+// RemoveStaleSocket removes a unix socket left behind at path by a previous
+// run. Anything else at path is an error and is left untouched, so a
+// mistyped socket path cannot delete a real file.
+func RemoveStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove stale socket %s: %w", path, err)
+	}
+	return nil
+}
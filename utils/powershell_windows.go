@@ -3,13 +3,20 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
 )
 
 func RunPowerShell(script string) (string, error) {
-	cmd := exec.Command("powershell", "-NoProfile", "-Command", script)
+	return RunPowerShellContext(context.Background(), script)
+}
+
+// RunPowerShellContext is RunPowerShell, killing the PowerShell process if
+// ctx is cancelled before it exits.
+func RunPowerShellContext(ctx context.Context, script string) (string, error) {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", script)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return string(out), fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
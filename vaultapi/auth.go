@@ -0,0 +1,64 @@
+package vaultapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Auth is the result of a login.
+type Auth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// TTL is how long the token is valid for; zero means it does not expire.
+func (a *Auth) TTL() time.Duration {
+	return time.Duration(a.LeaseDuration) * time.Second
+}
+
+// LoginAppRole logs in with the AppRole method mounted at mount and, on
+// success, uses the returned token for later requests.
+func (c *Client) LoginAppRole(ctx context.Context, mount string, roleId string, secretId string) (*Auth, error) {
+	body := map[string]string{"role_id": roleId}
+	if secretId != "" {
+		body["secret_id"] = secretId
+	}
+	return c.login(ctx, mount, body)
+}
+
+// LoginKubernetes logs in with the Kubernetes method mounted at mount, using
+// a service account token (JWT) for role.
+func (c *Client) LoginKubernetes(ctx context.Context, mount string, role string, jwt string) (*Auth, error) {
+	return c.login(ctx, mount, map[string]string{"role": role, "jwt": jwt})
+}
+
+func (c *Client) login(ctx context.Context, mount string, body map[string]string) (*Auth, error) {
+	var response struct {
+		Auth *Auth `json:"auth"`
+	}
+	if err := c.do(ctx, http.MethodPost, "auth/"+escapePath(mount)+"/login", body, &response); err != nil {
+		return nil, err
+	}
+	if response.Auth == nil || response.Auth.ClientToken == "" {
+		return nil, errors.New("login returned no token")
+	}
+	c.token = response.Auth.ClientToken
+	return response.Auth, nil
+}
+
+// escapePath escapes each segment of a slash-separated Vault path, dropping
+// empty segments.
+func escapePath(path string) string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, url.PathEscape(segment))
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,143 @@
+// Package vaultapi is a minimal HashiCorp Vault client covering login and the
+// KV version 2 secrets engine.
+package vaultapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Config is how to reach a Vault server. Namespace is sent as
+// X-Vault-Namespace for Vault Enterprise; CAData is a PEM bundle to trust.
+type Config struct {
+	Address   string
+	Namespace string
+	CAData    []byte
+}
+
+type Client struct {
+	address   string
+	namespace string
+	token     string
+	http      *http.Client
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if strings.TrimSpace(cfg.Address) == "" {
+		return nil, errors.New("no Vault address")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(cfg.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.CAData) {
+			return nil, errors.New("no certificates in Vault CA data")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &Client{
+		address:   strings.TrimSuffix(strings.TrimSpace(cfg.Address), "/"),
+		namespace: cfg.Namespace,
+		http: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+			Timeout:   requestTimeout,
+		},
+	}, nil
+}
+
+// SetToken sets the token sent with every request.
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Error is a failed request, with the messages from Vault's errors array.
+type Error struct {
+	StatusCode int
+	Errors     []string
+
+	body []byte
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault api: status=%d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault api: status=%d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// IsNotFound reports whether err is a 404, which Vault also returns for
+// deleted KV secrets.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsPermissionDenied reports whether err is a 403, which is also how Vault
+// answers requests made with an expired or revoked token.
+func IsPermissionDenied(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.address+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	req.Header.Set("X-Vault-Request", "true")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		apiErr := &Error{StatusCode: resp.StatusCode, body: data}
+		var payload struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &payload) == nil && len(payload.Errors) > 0 {
+			apiErr.Errors = payload.Errors
+		} else if text := strings.TrimSpace(string(data)); text != "" {
+			apiErr.Errors = []string{text}
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
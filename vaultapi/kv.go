@@ -0,0 +1,64 @@
+package vaultapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// KVSecret is the latest version of a KV version 2 secret. Data is nil when
+// that version was deleted or destroyed.
+type KVSecret struct {
+	Data    map[string]any
+	Version int
+}
+
+type kvReadResponse struct {
+	Data struct {
+		Data     map[string]any `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+// ReadKV reads the secret at path in the KV v2 engine mounted at mount. A
+// secret that never existed is a not-found error; one whose latest version
+// was deleted is returned without data, so it can be written back
+// check-and-set against that version.
+func (c *Client) ReadKV(ctx context.Context, mount string, path string) (*KVSecret, error) {
+	var response kvReadResponse
+	err := c.do(ctx, http.MethodGet, escapePath(mount)+"/data/"+escapePath(path), nil, &response)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		// Vault still answers 404 for deleted versions, with their metadata.
+		var deleted kvReadResponse
+		if json.Unmarshal(apiErr.body, &deleted) == nil && deleted.Data.Metadata.Version > 0 {
+			return &KVSecret{Version: deleted.Data.Metadata.Version}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &KVSecret{Data: response.Data.Data, Version: response.Data.Metadata.Version}, nil
+}
+
+// WriteKV writes a new version of the secret at path and returns its version
+// number. When cas is set the write only succeeds if the current version is
+// *cas (0 meaning the secret must not exist yet).
+func (c *Client) WriteKV(ctx context.Context, mount string, path string, data map[string]any, cas *int) (int, error) {
+	body := map[string]any{"data": data}
+	if cas != nil {
+		body["options"] = map[string]any{"cas": *cas}
+	}
+	var response struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, escapePath(mount)+"/data/"+escapePath(path), body, &response); err != nil {
+		return 0, err
+	}
+	return response.Data.Version, nil
+}